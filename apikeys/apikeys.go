@@ -0,0 +1,136 @@
+// apikeys/apikeys.go
+
+// Package apikeys implements scoped API keys for server-to-server integrations.
+// Keys are shown once when created; only their SHA-256 hash is stored.
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"gocommerce/apiversion"
+	"gocommerce/logging"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// keyPrefix makes keys easy to recognise, for example by secret scanners
+const keyPrefix = "gck_"
+
+// touchInterval limits how often a key's last_used_at is written
+const touchInterval = time.Minute
+
+// Scopes lists every scope a key can be granted. A write scope also grants read.
+// There is no users:write: accounts, their roles and their sessions can only be
+// changed by a signed-in admin.
+var Scopes = []string{
+	"products:read", "products:write",
+	"orders:read", "orders:write",
+	"users:read",
+	"webhooks:read", "webhooks:write",
+}
+
+// ErrInvalidKey is returned for unknown, revoked and expired keys
+var ErrInvalidKey = errors.New("invalid API key")
+
+// Key is a stored API key. The key itself is never stored.
+type Key struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // The start of the key, to tell keys apart
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  *int       `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"` // Set when a key is rotated and still in its grace period
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// ValidScope reports whether scope is one of Scopes.
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the key has been granted scope.
+func (k *Key) Allows(scope string) bool {
+	resource, action, _ := strings.Cut(scope, ":")
+	for _, s := range k.Scopes {
+		if s == scope || (action == "read" && s == resource+":write") {
+			return true
+		}
+	}
+	return false
+}
+
+// Generate returns a new random key, the prefix shown in listings and the hash to store.
+func Generate() (string, string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	key := keyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, key[:len(keyPrefix)+8], Hash(key), nil
+}
+
+// Hash returns the hex SHA-256 of a key.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// FromRequest returns the key sent in the X-API-Key header or as "Authorization: ApiKey <key>".
+func FromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "ApiKey ") {
+		return strings.TrimPrefix(auth, "ApiKey ")
+	}
+	return ""
+}
+
+// RequiredScope returns the scope needed for a request, derived from the resource
+// in its path (/api/<resource>/... in any version) and whether the method can change state.
+// It returns false for paths no scope covers, which keys can't call at all.
+func RequiredScope(method, path string) (string, bool) {
+	resource, _, _ := strings.Cut(strings.TrimPrefix(apiversion.Unversioned(path), "/api/"), "/")
+	action := "write"
+	if method == http.MethodGet || method == http.MethodHead {
+		action = "read"
+	}
+	scope := resource + ":" + action
+	return scope, ValidScope(scope)
+}
+
+// Authenticate looks up a live key and records that it was used.
+func Authenticate(ctx context.Context, db *sql.DB, key string) (*Key, error) {
+	var k Key
+	var lastUsedAt sql.NullTime
+	err := db.QueryRowContext(ctx, `SELECT id, name, prefix, scopes, last_used_at FROM api_keys
+		WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())`,
+		Hash(key)).Scan(&k.ID, &k.Name, &k.Prefix, pq.Array(&k.Scopes), &lastUsedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidKey
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !lastUsedAt.Valid || time.Since(lastUsedAt.Time) > touchInterval {
+		if _, err := db.ExecContext(ctx, "UPDATE api_keys SET last_used_at = NOW() WHERE id = $1", k.ID); err != nil {
+			logging.FromContext(ctx).Error("Error updating API key last used time", "error", err)
+		}
+	}
+	return &k, nil
+}
@@ -0,0 +1,108 @@
+package apikeys
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAllows(t *testing.T) {
+	key := &Key{Scopes: []string{"products:write", "orders:read"}}
+	tests := []struct {
+		scope string
+		want  bool
+	}{
+		{"products:write", true},
+		{"products:read", true}, // Write grants read
+		{"orders:read", true},
+		{"orders:write", false}, // Read doesn't grant write
+		{"users:read", false},
+		{"products", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := key.Allows(tt.scope); got != tt.want {
+			t.Errorf("Allows(%q) = %v, want %v", tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestRequiredScope(t *testing.T) {
+	tests := []struct {
+		method, path string
+		scope        string
+		ok           bool
+	}{
+		{http.MethodGet, "/api/v1/products", "products:read", true},
+		{http.MethodHead, "/api/v1/products/3", "products:read", true},
+		{http.MethodPost, "/api/v1/products", "products:write", true},
+		{http.MethodDelete, "/api/v1/orders/3", "orders:write", true},
+		{http.MethodGet, "/api/orders", "orders:read", true}, // Unversioned alias
+		{http.MethodGet, "/api/v1/users/3", "users:read", true},
+		{http.MethodPut, "/api/v1/webhooks/3", "webhooks:write", true},
+		// Nothing grants changes to accounts, nor anything outside the resources
+		{http.MethodPut, "/api/v1/users/3", "users:write", false},
+		{http.MethodDelete, "/api/v1/users/3/sessions", "users:write", false},
+		{http.MethodPost, "/api/v1/users/3/erasure", "users:write", false},
+		{http.MethodPost, "/api/v1/api-keys", "api-keys:write", false},
+		{http.MethodGet, "/api/v1/me", "me:read", false},
+		{http.MethodGet, "/api/v1/data-requests", "data-requests:read", false},
+	}
+	for _, tt := range tests {
+		scope, ok := RequiredScope(tt.method, tt.path)
+		if scope != tt.scope || ok != tt.ok {
+			t.Errorf("RequiredScope(%s %s) = %q, %v, want %q, %v", tt.method, tt.path, scope, ok, tt.scope, tt.ok)
+		}
+	}
+}
+
+func TestValidScope(t *testing.T) {
+	for _, scope := range Scopes {
+		if !ValidScope(scope) {
+			t.Errorf("ValidScope(%q) = false for a listed scope", scope)
+		}
+	}
+	for _, scope := range []string{"users:write", "api-keys:read", "admin", ""} {
+		if ValidScope(scope) {
+			t.Errorf("ValidScope(%q) = true", scope)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	key, prefix, hash, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, keyPrefix) || !strings.HasPrefix(key, prefix) || len(prefix) != len(keyPrefix)+8 {
+		t.Errorf("Generate() = key %q, prefix %q", key, prefix)
+	}
+	if hash != Hash(key) || len(hash) != 64 {
+		t.Errorf("Generate() hash %q doesn't match Hash(key)", hash)
+	}
+	if other, _, _, _ := Generate(); other == key {
+		t.Error("Generate() returned the same key twice")
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	tests := []struct {
+		header, value string
+		want          string
+	}{
+		{"X-API-Key", "gck_abc", "gck_abc"},
+		{"Authorization", "ApiKey gck_abc", "gck_abc"},
+		{"Authorization", "Bearer eyJ", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/products", nil)
+		if tt.header != "" {
+			r.Header.Set(tt.header, tt.value)
+		}
+		if got := FromRequest(r); got != tt.want {
+			t.Errorf("FromRequest(%s: %s) = %q, want %q", tt.header, tt.value, got, tt.want)
+		}
+	}
+}
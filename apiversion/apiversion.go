@@ -0,0 +1,79 @@
+// apiversion/apiversion.go
+
+// Package apiversion versions the API by path. Version 1 is served under /api/v1
+// and a version 2 would be served under /api/v2 next to it, each from its own
+// subrouter. The unversioned paths the API started with, such as /api/products,
+// stay available as aliases of v1 until their sunset date.
+//
+// Responses from deprecated endpoints, the aliases included, carry a Deprecation
+// header (RFC 9745), a Sunset header (RFC 8594) once a date is set, and a Link to
+// the successor. After the sunset they answer 410 Gone.
+package apiversion
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// apiPrefix is where every version of the API lives
+const apiPrefix = "/api"
+
+// Version is an API version, such as v1.
+type Version string
+
+// V1 is the first version of the API, and the one unversioned paths alias.
+const V1 Version = "v1"
+
+// Prefix is the path prefix of the version's routes, such as /api/v1.
+func (v Version) Prefix() string {
+	return apiPrefix + "/" + string(v)
+}
+
+// Path returns path in the version: V1.Path("/products") is /api/v1/products.
+func (v Version) Path(path string) string {
+	return v.Prefix() + path
+}
+
+// versionedPath matches API paths that name their version
+var versionedPath = regexp.MustCompile(`^/api/v[0-9]+(/|$)`)
+
+// Unversioned strips the version from an API path, so /api/v1/products becomes
+// /api/products. Other paths are returned unchanged. It lets rules written for
+// the API's paths, like which are public, apply to every version.
+func Unversioned(path string) string {
+	if loc := versionedPath.FindStringIndex(path); loc != nil {
+		return apiPrefix + "/" + path[loc[1]:]
+	}
+	return path
+}
+
+// isAlias reports whether path is an unversioned API path
+func isAlias(path string) bool {
+	return strings.HasPrefix(path, apiPrefix+"/") && !versionedPath.MatchString(path)
+}
+
+// Aliases serves unversioned API paths as their v1 equivalents: /api/products is
+// answered by /api/v1/products, with headers announcing the deprecation. It wraps
+// the router, since the path has to be rewritten before a route is matched.
+func Aliases(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAlias(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		successor := V1.Path(strings.TrimPrefix(r.URL.Path, apiPrefix))
+		if !Legacy.announce(w, r, successor) {
+			return
+		}
+
+		// Rewrite a copy so the request logger still records the path as called
+		aliased := r.Clone(withAlias(r.Context()))
+		aliased.URL.Path = successor
+		if r.URL.RawPath != "" {
+			aliased.URL.RawPath = V1.Path(strings.TrimPrefix(r.URL.RawPath, apiPrefix))
+		}
+		next.ServeHTTP(w, aliased)
+	})
+}
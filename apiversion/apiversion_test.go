@@ -0,0 +1,101 @@
+package apiversion
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestUnversioned(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/v1/products", "/api/products"},
+		{"/api/v1/products/7/restore", "/api/products/7/restore"},
+		{"/api/v12/orders", "/api/orders"},
+		{"/api/v1", "/api/"},
+		{"/api/products", "/api/products"},
+		{"/api/v1beta/products", "/api/v1beta/products"},
+		{"/api/version", "/api/version"},
+		{"/v1/api/products", "/v1/api/products"},
+		{"/health", "/health"},
+	}
+	for _, tt := range tests {
+		if got := Unversioned(tt.path); got != tt.want {
+			t.Errorf("Unversioned(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestAliases(t *testing.T) {
+	var served *http.Request
+	handler := Aliases(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = r
+	}))
+
+	tests := []struct {
+		name      string
+		target    string
+		wantPath  string
+		wantAlias bool
+	}{
+		{"unversioned path", "/api/products/7?include_deleted=true", "/api/v1/products/7", true},
+		{"escaped path", "/api/products/a%2Fb", "/api/v1/products/a%2Fb", true},
+		{"versioned path", "/api/v1/products", "/api/v1/products", false},
+		{"outside the API", "/health", "/health", false},
+		{"the API root", "/api", "/api", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			served = nil
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			handler.ServeHTTP(w, r)
+
+			if served == nil {
+				t.Fatalf("Request wasn't passed on, answered %d", w.Code)
+			}
+			if served.URL.EscapedPath() != tt.wantPath || served.URL.RawQuery != r.URL.RawQuery {
+				t.Errorf("Served %s?%s, want %s?%s", served.URL.EscapedPath(), served.URL.RawQuery, tt.wantPath, r.URL.RawQuery)
+			}
+			if alias, _ := served.Context().Value(aliasKey{}).(bool); alias != tt.wantAlias {
+				t.Errorf("Request marked as an alias: %v, want %v", alias, tt.wantAlias)
+			}
+
+			deprecation := w.Header().Get("Deprecation")
+			if tt.wantAlias {
+				if r.URL.Path == served.URL.Path {
+					t.Error("The original request was rewritten")
+				}
+				if deprecation != "@"+strconv.FormatInt(Legacy.Since.Unix(), 10) ||
+					w.Header().Get("Link") != "<"+served.URL.Path+`>; rel="successor-version"` {
+					t.Errorf("Alias headers = %v", w.Header())
+				}
+			} else if deprecation != "" {
+				t.Errorf("%s announced a deprecation", tt.target)
+			}
+		})
+	}
+}
+
+func TestAliasesSunset(t *testing.T) {
+	defer func(legacy Deprecation) { Legacy = legacy }(Legacy)
+
+	Legacy.Sunset = time.Now().Add(24 * time.Hour)
+	handler := Aliases(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/products", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Sunset") != Legacy.Sunset.UTC().Format(http.TimeFormat) {
+		t.Errorf("Before the sunset the alias answered %d with Sunset %q", w.Code, w.Header().Get("Sunset"))
+	}
+
+	Legacy.Sunset = time.Now().Add(-time.Hour)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/products", nil))
+	if w.Code != http.StatusGone || w.Header().Get("Link") != `</api/v1/products>; rel="successor-version"` {
+		t.Errorf("After the sunset the alias answered %d with Link %q, want 410 and the successor", w.Code, w.Header().Get("Link"))
+	}
+}
@@ -0,0 +1,121 @@
+// apiversion/deprecation.go
+
+package apiversion
+
+import (
+	"context"
+	"gocommerce/apperrors"
+	"gocommerce/config"
+	"gocommerce/metrics"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Deprecation describes an endpoint on its way out.
+type Deprecation struct {
+	Since     time.Time // When it was deprecated
+	Sunset    time.Time // When it stops working; zero until that's decided
+	Successor string    // Path of the replacement, if there is one
+}
+
+// Legacy is the deprecation of the unversioned paths. Their sunset is set with
+// API_LEGACY_SUNSET (YYYY-MM-DD) once clients have moved to /api/v1.
+var Legacy = Deprecation{
+	Since:  time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+	Sunset: config.Date("API_LEGACY_SUNSET", time.Time{}),
+}
+
+var (
+	mu         sync.RWMutex
+	deprecated = map[string]Deprecation{}
+)
+
+// Deprecate marks the endpoint registered for method and path template, such as
+// GET /api/v1/products/{id}, as deprecated. Call it when registering routes.
+func Deprecate(method, path string, d Deprecation) {
+	mu.Lock()
+	defer mu.Unlock()
+	deprecated[method+" "+path] = d
+}
+
+// Lookup returns the deprecation of the endpoint registered for method and path template.
+func Lookup(method, path string) (Deprecation, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d, ok := deprecated[method+" "+path]
+	return d, ok
+}
+
+// Deprecated lists every deprecated endpoint as "METHOD /path/template".
+func Deprecated() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	endpoints := make([]string, 0, len(deprecated))
+	for endpoint := range deprecated {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	return endpoints
+}
+
+type aliasKey struct{}
+
+func withAlias(ctx context.Context) context.Context {
+	return context.WithValue(ctx, aliasKey{}, true)
+}
+
+// Middleware announces the deprecation of the endpoints registered with Deprecate
+// and counts requests to any deprecated endpoint. It is installed on the router so
+// endpoints are matched by route template.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if alias, _ := r.Context().Value(aliasKey{}).(bool); alias {
+			// Aliases already announced themselves before the path was rewritten
+			metrics.DeprecatedRequests.WithLabelValues(Unversioned(template)).Inc()
+		} else if d, ok := Lookup(r.Method, template); ok {
+			metrics.DeprecatedRequests.WithLabelValues(template).Inc()
+			if !d.announce(w, r, d.Successor) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// announce sets the deprecation headers and returns true, or answers 410 Gone and
+// returns false if the sunset has passed.
+func (d Deprecation) announce(w http.ResponseWriter, r *http.Request, successor string) bool {
+	if successor != "" {
+		w.Header().Add("Link", "<"+successor+`>; rel="successor-version"`)
+	}
+	if !d.Sunset.IsZero() && !time.Now().Before(d.Sunset) {
+		detail := "This endpoint was removed on " + d.Sunset.Format(time.DateOnly)
+		if successor != "" {
+			detail += "; use " + successor + " instead"
+		}
+		apperrors.Write(w, r, apperrors.Gone(detail))
+		return false
+	}
+
+	w.Header().Set("Deprecation", "@"+strconv.FormatInt(d.Since.Unix(), 10))
+	if !d.Sunset.IsZero() {
+		w.Header().Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+	}
+	return true
+}
@@ -0,0 +1,172 @@
+// apperrors/apperrors.go
+
+// Package apperrors defines the typed errors handlers return to clients. Each one
+// carries an HTTP status, a stable machine-readable code and a message that is safe
+// to show; Write renders it as an RFC 7807 application/problem+json response.
+// Internal errors keep their cause for the logs and never send it to the client.
+package apperrors
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Stable error codes. Clients branch on these, so existing values must not change.
+const (
+	CodeBadRequest       = "bad_request"
+	CodeInvalidJSON      = "invalid_json"
+	CodeValidation       = "validation_failed"
+	CodeNotFound         = "not_found"
+	CodeGone             = "gone"
+	CodeConflict         = "conflict"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeTooManyRequests  = "too_many_requests"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeRequestTooLarge  = "request_too_large"
+	CodeBadGateway       = "bad_gateway"
+	CodeInternal         = "internal_error"
+)
+
+// FieldError describes why one field of the request was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is an error with everything needed to answer the request that caused it.
+type Error struct {
+	Status int
+	Code   string
+	// Detail is shown to the client
+	Detail string
+	Fields []FieldError
+	// Err is the underlying cause. It is logged, never sent.
+	Err error
+	// logMsg is the log message for server errors
+	logMsg string
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Detail, e.Err)
+	}
+	return e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// WithCode replaces the error's code with a more specific one, such as
+// "invalid_credentials" for a 401.
+func (e *Error) WithCode(code string) *Error {
+	e.Code = code
+	return e
+}
+
+// New returns an error with the given status, code and client-facing detail.
+func New(status int, code, detail string) *Error {
+	return &Error{Status: status, Code: code, Detail: detail}
+}
+
+// BadRequest is for malformed requests that aren't about a particular field.
+func BadRequest(detail string) *Error {
+	return New(http.StatusBadRequest, CodeBadRequest, detail)
+}
+
+// Validation is for request data that is well-formed but not acceptable. fields
+// says which fields were rejected and why.
+func Validation(detail string, fields ...FieldError) *Error {
+	e := New(http.StatusBadRequest, CodeValidation, detail)
+	e.Fields = fields
+	return e
+}
+
+// InvalidField is a validation error about a single field.
+func InvalidField(field, message string) *Error {
+	return Validation(message, FieldError{Field: field, Message: message})
+}
+
+// InvalidJSON describes a request body that could not be decoded, without echoing
+// the decoder's message, which names Go types.
+func InvalidJSON(err error) *Error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		e := Validation("Request body has a field of the wrong type", FieldError{
+			Field:   typeErr.Field,
+			Message: "must be a " + jsonType(typeErr.Type.Kind().String()),
+		})
+		e.Err = err
+		return e
+	}
+	e := New(http.StatusBadRequest, CodeInvalidJSON, "Request body is not valid JSON")
+	e.Err = err
+	return e
+}
+
+// jsonType names the JSON type a Go kind is decoded from
+func jsonType(kind string) string {
+	switch kind {
+	case "string":
+		return "string"
+	case "bool":
+		return "boolean"
+	case "slice", "array":
+		return "array"
+	case "struct", "map", "ptr":
+		return "object"
+	default:
+		return "number"
+	}
+}
+
+// NotFound is for resources that don't exist or that the caller may not see.
+func NotFound(detail string) *Error {
+	return New(http.StatusNotFound, CodeNotFound, detail)
+}
+
+// Gone is for endpoints that have been removed after their sunset date.
+func Gone(detail string) *Error {
+	return New(http.StatusGone, CodeGone, detail)
+}
+
+// Conflict is for requests that clash with the resource's current state.
+func Conflict(detail string) *Error {
+	return New(http.StatusConflict, CodeConflict, detail)
+}
+
+// Unauthorized is for requests without valid credentials.
+func Unauthorized(detail string) *Error {
+	return New(http.StatusUnauthorized, CodeUnauthorized, detail)
+}
+
+// Forbidden is for authenticated requests the caller may not make.
+func Forbidden(detail string) *Error {
+	return New(http.StatusForbidden, CodeForbidden, detail)
+}
+
+// TooManyRequests is for callers that hit a rate limit.
+func TooManyRequests(detail string) *Error {
+	return New(http.StatusTooManyRequests, CodeTooManyRequests, detail)
+}
+
+// BadGateway is for failures of a service the request depends on. msg and err are
+// logged; the client only sees detail.
+func BadGateway(detail, msg string, err error) *Error {
+	e := New(http.StatusBadGateway, CodeBadGateway, detail)
+	e.Err = err
+	e.logMsg = msg
+	return e
+}
+
+// Internal wraps an unexpected error. msg and err are logged when the response is
+// written; the client gets a generic message and the request ID to quote.
+func Internal(msg string, err error) *Error {
+	e := New(http.StatusInternalServerError, CodeInternal, "An internal error occurred")
+	e.Err = err
+	e.logMsg = msg
+	return e
+}
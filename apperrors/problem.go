@@ -0,0 +1,80 @@
+// apperrors/problem.go
+
+package apperrors
+
+import (
+	"encoding/json"
+	"errors"
+	"gocommerce/constants"
+	"gocommerce/logging"
+	"net/http"
+)
+
+// ContentType is the media type of problem responses
+const ContentType = "application/problem+json"
+
+// typePrefix turns a code into the problem type URI
+const typePrefix = "urn:gocommerce:problem:"
+
+// Problem is the RFC 7807 body written for an error. Code and RequestID are
+// extension members.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// Write answers r with err as a problem response. Errors that aren't an *Error are
+// treated as internal. Server errors are logged with their cause, which is left
+// out of the response.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = Internal("Unhandled error", err)
+	}
+
+	if e.Status >= http.StatusInternalServerError {
+		msg := e.logMsg
+		if msg == "" {
+			msg = "Request failed"
+		}
+		logging.FromContext(r.Context()).Error(msg, "error", e.Err, "status", e.Status, "code", e.Code)
+	}
+
+	requestID, _ := r.Context().Value(constants.RequestIDKey).(string)
+	problem := Problem{
+		Type:      typePrefix + e.Code,
+		Title:     http.StatusText(e.Status),
+		Status:    e.Status,
+		Detail:    e.Detail,
+		Instance:  r.URL.Path,
+		Code:      e.Code,
+		RequestID: requestID,
+		Errors:    e.Fields,
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// NotFoundHandler answers requests that match no route.
+func NotFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, NotFound("No such resource"))
+	})
+}
+
+// MethodNotAllowedHandler answers requests whose path matches a route but whose
+// method doesn't.
+func MethodNotAllowedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, New(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed for this resource"))
+	})
+}
@@ -0,0 +1,120 @@
+package apperrors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gocommerce/constants"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+		wantDetail string
+	}{
+		{"bad request", BadRequest("Invalid product ID"), http.StatusBadRequest, CodeBadRequest, "Invalid product ID"},
+		{"not found", NotFound("User not found"), http.StatusNotFound, CodeNotFound, "User not found"},
+		{"gone", Gone("Removed"), http.StatusGone, CodeGone, "Removed"},
+		{"conflict", Conflict("email already in use"), http.StatusConflict, CodeConflict, "email already in use"},
+		{"unauthorized with a specific code", Unauthorized("Invalid credentials").WithCode("invalid_credentials"), http.StatusUnauthorized, "invalid_credentials", "Invalid credentials"},
+		{"forbidden", Forbidden("Forbidden"), http.StatusForbidden, CodeForbidden, "Forbidden"},
+		{"too many requests", TooManyRequests("Slow down"), http.StatusTooManyRequests, CodeTooManyRequests, "Slow down"},
+		{"wrapped", fmt.Errorf("loading: %w", NotFound("Order not found")), http.StatusNotFound, CodeNotFound, "Order not found"},
+		{"bad gateway hides the cause", BadGateway("Payment provider unavailable", "Error charging card", errors.New("dial tcp 10.0.0.1")), http.StatusBadGateway, CodeBadGateway, "Payment provider unavailable"},
+		{"internal hides the cause", Internal("Error loading user", errors.New("pq: password authentication failed")), http.StatusInternalServerError, CodeInternal, "An internal error occurred"},
+		{"plain errors are internal", errors.New("pq: relation users does not exist"), http.StatusInternalServerError, CodeInternal, "An internal error occurred"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/users/7", nil)
+			r = r.WithContext(context.WithValue(r.Context(), constants.RequestIDKey, "req-1"))
+			w := httptest.NewRecorder()
+			Write(w, r, tt.err)
+
+			if w.Code != tt.wantStatus || w.Header().Get("Content-Type") != ContentType {
+				t.Errorf("Answered %d with %s, want %d with %s", w.Code, w.Header().Get("Content-Type"), tt.wantStatus, ContentType)
+			}
+			var problem Problem
+			if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+				t.Fatal(err)
+			}
+			want := Problem{
+				Type:      typePrefix + tt.wantCode,
+				Title:     http.StatusText(tt.wantStatus),
+				Status:    tt.wantStatus,
+				Detail:    tt.wantDetail,
+				Instance:  "/api/v1/users/7",
+				Code:      tt.wantCode,
+				RequestID: "req-1",
+			}
+			if !reflect.DeepEqual(problem, want) {
+				t.Errorf("Problem = %+v, want %+v", problem, want)
+			}
+			for _, leak := range []string{"pq:", "dial tcp"} {
+				if strings.Contains(w.Body.String(), leak) {
+					t.Errorf("Response leaks the cause: %s", w.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestValidationProblem(t *testing.T) {
+	w := httptest.NewRecorder()
+	Write(w, httptest.NewRequest(http.MethodPost, "/api/v1/products", nil), Validation("Request body failed validation",
+		FieldError{Field: "name", Message: "is required"},
+		FieldError{Field: "price", Message: "must be at least 0"}))
+
+	var body map[string]any
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Code != http.StatusBadRequest || body["code"] != CodeValidation {
+		t.Errorf("Answered %d with %v", w.Code, body)
+	}
+	fields, _ := body["errors"].([]any)
+	if len(fields) != 2 || fmt.Sprint(fields[0]) != "map[field:name message:is required]" {
+		t.Errorf("errors = %v, want both fields", body["errors"])
+	}
+	if _, ok := body["request_id"]; ok {
+		t.Error("request_id is set without a request ID")
+	}
+}
+
+func TestInvalidJSON(t *testing.T) {
+	var v struct {
+		Price float64 `json:"price"`
+		Tags  []string
+	}
+	typeErr := json.Unmarshal([]byte(`{"price":"free"}`), &v)
+	if e := InvalidJSON(typeErr); e.Code != CodeValidation || !reflect.DeepEqual(e.Fields, []FieldError{{Field: "price", Message: "must be a number"}}) || !errors.Is(e, typeErr) {
+		t.Errorf("InvalidJSON(type error) = %+v", e)
+	}
+
+	syntaxErr := json.Unmarshal([]byte(`{"price":`), &v)
+	e := InvalidJSON(syntaxErr)
+	if e.Status != http.StatusBadRequest || e.Code != CodeInvalidJSON || strings.Contains(e.Detail, "float64") {
+		t.Errorf("InvalidJSON(syntax error) = %+v", e)
+	}
+}
+
+func TestFallbackHandlers(t *testing.T) {
+	w := httptest.NewRecorder()
+	NotFoundHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/nope", nil))
+	if w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != ContentType {
+		t.Errorf("NotFoundHandler answered %d with %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	w = httptest.NewRecorder()
+	MethodNotAllowedHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/api/v1/products", nil))
+	if w.Code != http.StatusMethodNotAllowed || !strings.Contains(w.Body.String(), CodeMethodNotAllowed) {
+		t.Errorf("MethodNotAllowedHandler answered %d: %s", w.Code, w.Body)
+	}
+}
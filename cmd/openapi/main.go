@@ -0,0 +1,47 @@
+// Command openapi writes the OpenAPI document for the routes the server registers.
+// With -check it writes nothing and fails if any route is undocumented, as the
+// openapi package's tests do for a new endpoint never added to handlers.APIDocs.
+//
+//	go run ./cmd/openapi > openapi.json
+//	go run ./cmd/openapi -check
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"gocommerce/handlers"
+	"gocommerce/logging"
+	"gocommerce/middleware"
+	"gocommerce/openapi"
+	"gocommerce/routes"
+	"log/slog"
+	"os"
+
+	"github.com/gorilla/mux"
+)
+
+func main() {
+	check := flag.Bool("check", false, "only check every route is documented")
+	flag.Parse()
+	// Logs go to stderr, stdout is the document
+	slog.SetDefault(logging.New(os.Stderr))
+
+	// The handlers are never called, so they don't need a database
+	router := mux.NewRouter()
+	routes.RegisterAll(router, &handlers.Handlers{})
+
+	if undocumented := openapi.Undocumented(router, handlers.APIDocs); len(undocumented) > 0 {
+		slog.Error("Routes missing from handlers.APIDocs", "routes", undocumented)
+		os.Exit(1)
+	}
+	if *check {
+		return
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(openapi.Build(router, handlers.APIDocs, middleware.IsPublicPath)); err != nil {
+		slog.Error("Error writing OpenAPI document", "error", err)
+		os.Exit(1)
+	}
+}
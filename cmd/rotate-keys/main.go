@@ -0,0 +1,32 @@
+// Command rotate-keys generates a new JWT signing key and retires the previous
+// one. The new key is published in the JWKS straight away and starts signing
+// tokens after tokens.PublishAhead, once every running API instance and every
+// verifier caching the JWKS has seen it; retired keys keep verifying tokens
+// until they are pruned.
+//
+//	go run ./cmd/rotate-keys -dir keys -alg EdDSA
+package main
+
+import (
+	"flag"
+	"gocommerce/config"
+	"gocommerce/logging"
+	"gocommerce/tokens"
+	"log/slog"
+	"os"
+)
+
+func main() {
+	dir := flag.String("dir", config.String("JWT_KEYS_DIR", "keys"), "directory holding the key set")
+	alg := flag.String("alg", config.String("JWT_SIGNING_ALG", tokens.AlgRS256), "signing algorithm: RS256 or EdDSA")
+	retain := flag.Duration("retain", tokens.DefaultRetention, "how long retired keys are kept for verification")
+	flag.Parse()
+	slog.SetDefault(logging.New(os.Stdout))
+
+	key, err := tokens.Rotate(*dir, *alg, *retain)
+	if err != nil {
+		slog.Error("Key rotation failed", "dir", *dir, "error", err)
+		os.Exit(1)
+	}
+	slog.Info("Created signing key", "kid", key.ID, "alg", key.Algorithm, "dir", *dir, "activates_at", key.ActivatesAt)
+}
@@ -0,0 +1,76 @@
+// config/config.go
+
+// Package config reads application settings from environment variables,
+// falling back to a default when a variable is unset or malformed.
+package config
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// String returns the value of key, or def if it is unset.
+func String(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// Int returns the value of key parsed as an integer, or def.
+func Int(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		slog.Warn("Invalid config value, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return n
+}
+
+// Bool returns the value of key parsed as a boolean, or def.
+func Bool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		slog.Warn("Invalid config value, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return b
+}
+
+// Duration returns the value of key parsed with time.ParseDuration (e.g. "15m"), or def.
+func Duration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		slog.Warn("Invalid config value, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return d
+}
+
+// Date returns the value of key parsed as a YYYY-MM-DD date in UTC, or def.
+func Date(key string, def time.Time) time.Time {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		slog.Warn("Invalid config value, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return t
+}
@@ -3,3 +3,12 @@ package constants
 type ContextKey string
 
 const UserIDKey ContextKey = "userID"
+
+// SessionIDKey holds the ID of the session the request's access token belongs to
+const SessionIDKey ContextKey = "sessionID"
+
+// APIKeyIDKey holds the ID of the API key a request was authenticated with
+const APIKeyIDKey ContextKey = "apiKeyID"
+
+// RequestIDKey holds the request's X-Request-ID
+const RequestIDKey ContextKey = "requestID"
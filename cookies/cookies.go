@@ -0,0 +1,128 @@
+// cookies/cookies.go
+
+// Package cookies implements the optional browser session mode, where the access
+// and refresh tokens are kept in HttpOnly cookies instead of being handed to scripts.
+package cookies
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"gocommerce/config"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CSRFHeader carries the double-submit CSRF token on cookie-authenticated requests
+const CSRFHeader = "X-CSRF-Token"
+
+var (
+	// Enabled makes login and refresh set session cookies instead of returning tokens in the body
+	Enabled = config.Bool("AUTH_COOKIES", false)
+
+	AccessCookieName  = config.String("AUTH_ACCESS_COOKIE", "access_token")
+	RefreshCookieName = config.String("AUTH_REFRESH_COOKIE", "refresh_token")
+	CSRFCookieName    = config.String("AUTH_CSRF_COOKIE", "csrf_token")
+
+	domain = config.String("AUTH_COOKIE_DOMAIN", "")
+	// secure can be turned off for local development over plain HTTP
+	secure   = config.Bool("AUTH_COOKIE_SECURE", true)
+	sameSite = parseSameSite(config.String("AUTH_COOKIE_SAMESITE", "strict"))
+)
+
+// refreshPaths limit the refresh cookie to the endpoints that need it. A copy is
+// also kept for the unversioned /api/auth aliases, which browsers would otherwise
+// call without it, until the aliases are removed.
+var refreshPaths = []string{"/api/v1/auth", "/api/auth"}
+
+func parseSameSite(v string) http.SameSite {
+	switch strings.ToLower(v) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "lax":
+		return http.SameSiteLaxMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		slog.Warn("Invalid AUTH_COOKIE_SAMESITE, using strict", "value", v)
+		return http.SameSiteStrictMode
+	}
+}
+
+// newCookie returns a cookie that lasts for maxAge, or one that expires the
+// browser's copy straight away if maxAge is negative.
+func newCookie(name, value, path string, maxAge time.Duration, httpOnly bool) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   domain,
+		MaxAge:   int(maxAge.Seconds()),
+		Secure:   secure,
+		HttpOnly: httpOnly,
+		SameSite: sameSite,
+	}
+	// A MaxAge of 0 sends no Max-Age at all, which would leave the cookie in place
+	if maxAge < 0 {
+		cookie.MaxAge = -1
+	}
+	return cookie
+}
+
+// SetTokens writes the access and refresh token cookies along with a fresh CSRF
+// token, and returns the CSRF token so it can also be given to the client.
+func SetTokens(w http.ResponseWriter, accessToken, refreshToken string, accessTTL, refreshTTL time.Duration) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	csrfToken := hex.EncodeToString(buf)
+
+	http.SetCookie(w, newCookie(AccessCookieName, accessToken, "/", accessTTL, true))
+	for _, path := range refreshPaths {
+		http.SetCookie(w, newCookie(RefreshCookieName, refreshToken, path, refreshTTL, true))
+	}
+	// Not HttpOnly: scripts read it and echo it back in the CSRF header
+	http.SetCookie(w, newCookie(CSRFCookieName, csrfToken, "/", refreshTTL, false))
+	return csrfToken, nil
+}
+
+// Clear expires all session cookies.
+func Clear(w http.ResponseWriter) {
+	http.SetCookie(w, newCookie(AccessCookieName, "", "/", -1, true))
+	for _, path := range refreshPaths {
+		http.SetCookie(w, newCookie(RefreshCookieName, "", path, -1, true))
+	}
+	http.SetCookie(w, newCookie(CSRFCookieName, "", "/", -1, false))
+}
+
+// Value returns the value of the named cookie, or "" if the request doesn't have it.
+func Value(r *http.Request, name string) string {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// SafeMethod reports whether method can't change state and so needs no CSRF token.
+func SafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// ValidCSRF reports whether the CSRF header matches the CSRF cookie. A cross-site
+// page can make the browser send the cookie but can't read it to set the header.
+func ValidCSRF(r *http.Request) bool {
+	cookie := Value(r, CSRFCookieName)
+	header := r.Header.Get(CSRFHeader)
+	if cookie == "" || header == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) == 1
+}
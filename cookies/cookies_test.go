@@ -0,0 +1,149 @@
+package cookies
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestValidCSRF(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie string
+		header string
+		want   bool
+	}{
+		{"matching", "abc123", "abc123", true},
+		{"different", "abc123", "abc124", false},
+		{"prefix", "abc123", "abc", false},
+		{"no header", "abc123", "", false},
+		{"no cookie", "", "abc123", false},
+		{"neither", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/orders", nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				r.Header.Set(CSRFHeader, tt.header)
+			}
+			if got := ValidCSRF(r); got != tt.want {
+				t.Errorf("ValidCSRF() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSafeMethod(t *testing.T) {
+	for method, want := range map[string]bool{
+		http.MethodGet: true, http.MethodHead: true, http.MethodOptions: true,
+		http.MethodPost: false, http.MethodPut: false, http.MethodPatch: false, http.MethodDelete: false,
+	} {
+		if got := SafeMethod(method); got != want {
+			t.Errorf("SafeMethod(%s) = %v, want %v", method, got, want)
+		}
+	}
+}
+
+func TestSetTokens(t *testing.T) {
+	w := httptest.NewRecorder()
+	csrf, err := SetTokens(w, "access", "refresh", time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(csrf) != 64 {
+		t.Errorf("CSRF token %q, want 32 random bytes in hex", csrf)
+	}
+
+	set := map[string]*http.Cookie{}
+	var refreshCookies []*http.Cookie
+	for _, c := range w.Result().Cookies() {
+		set[c.Name] = c
+		if c.Name == RefreshCookieName {
+			refreshCookies = append(refreshCookies, c)
+		}
+	}
+	access, csrfCookie := set[AccessCookieName], set[CSRFCookieName]
+	if access == nil || len(refreshCookies) != len(refreshPaths) || csrfCookie == nil {
+		t.Fatalf("Cookies set: %v", w.Result().Cookies())
+	}
+	if access.Value != "access" || !access.HttpOnly || access.Path != "/" || access.MaxAge != 60 {
+		t.Errorf("Access cookie = %+v", access)
+	}
+	// The refresh token only goes to the endpoints that use it
+	for i, refresh := range refreshCookies {
+		if refresh.Value != "refresh" || !refresh.HttpOnly || refresh.Path != refreshPaths[i] || refresh.MaxAge != 3600 {
+			t.Errorf("Refresh cookie = %+v", refresh)
+		}
+	}
+	// Scripts have to read the CSRF cookie to echo it in the header
+	if csrfCookie.Value != csrf || csrfCookie.HttpOnly {
+		t.Errorf("CSRF cookie = %+v", csrfCookie)
+	}
+
+	// A request echoing the token passes the check
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.AddCookie(csrfCookie)
+	r.Header.Set(CSRFHeader, csrf)
+	if !ValidCSRF(r) {
+		t.Error("ValidCSRF() rejected the token SetTokens issued")
+	}
+
+	again, _ := SetTokens(httptest.NewRecorder(), "access", "refresh", time.Minute, time.Hour)
+	if again == csrf {
+		t.Error("SetTokens() issued the same CSRF token twice")
+	}
+}
+
+func TestClear(t *testing.T) {
+	w := httptest.NewRecorder()
+	Clear(w)
+	cleared := map[string]bool{}
+	for _, c := range w.Result().Cookies() {
+		if c.MaxAge >= 0 || c.Value != "" {
+			t.Errorf("Cookie %s isn't expired: %+v", c.Name, c)
+		}
+		cleared[c.Name+" "+c.Path] = true
+	}
+	for _, want := range []string{AccessCookieName + " /", RefreshCookieName + " /api/v1/auth", RefreshCookieName + " /api/auth", CSRFCookieName + " /"} {
+		if !cleared[want] {
+			t.Errorf("Clear() didn't expire %s", want)
+		}
+	}
+}
+
+// TestRefreshCookieScope checks which requests a browser sends the refresh token
+// with, including the unversioned aliases kept for the transition
+func TestRefreshCookieScope(t *testing.T) {
+	w := httptest.NewRecorder()
+	if _, err := SetTokens(w, "access", "refresh", time.Minute, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	jar, _ := cookiejar.New(nil)
+	jar.SetCookies(&url.URL{Scheme: "https", Host: "shop.test", Path: "/api/v1/auth/login"}, w.Result().Cookies())
+
+	for path, want := range map[string]bool{
+		"/api/v1/auth/refresh":   true,
+		"/api/v1/auth/logout":    true,
+		"/api/auth/refresh":      true,
+		"/api/auth/logout":       true,
+		"/api/v1/products":       false,
+		"/api/authors":           false,
+		"/api/v1/me/data-export": false,
+	} {
+		sent := false
+		for _, c := range jar.Cookies(&url.URL{Scheme: "https", Host: "shop.test", Path: path}) {
+			if c.Name == RefreshCookieName && c.Value == "refresh" {
+				sent = true
+			}
+		}
+		if sent != want {
+			t.Errorf("Refresh cookie sent to %s: %v, want %v", path, sent, want)
+		}
+	}
+}
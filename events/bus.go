@@ -0,0 +1,64 @@
+// events/bus.go
+
+package events
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Handler processes a single event. Returning an error causes the event to be retried.
+type Handler func(ctx context.Context, event Event) error
+
+// Bus fans events out to in-process subscribers.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[string][]Handler
+}
+
+// allEvents is the subscription key for handlers that receive every event type
+const allEvents = "*"
+
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[string][]Handler)}
+}
+
+// Subscribe registers handler for the given event type.
+func (b *Bus) Subscribe(eventType string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[eventType] = append(b.subscribers[eventType], handler)
+}
+
+// SubscribeAll registers handler for every event type.
+func (b *Bus) SubscribeAll(handler Handler) {
+	b.Subscribe(allEvents, handler)
+}
+
+// Publish delivers event to every matching subscriber. All subscribers are
+// called even if one fails; the first error is returned.
+func (b *Bus) Publish(ctx context.Context, event Event) error {
+	b.mu.RLock()
+	handlers := append(append([]Handler{}, b.subscribers[event.Type]...), b.subscribers[allEvents]...)
+	b.mu.RUnlock()
+
+	var firstErr error
+	for _, handler := range handlers {
+		if err := safeCall(ctx, handler, event); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// safeCall runs handler and turns a panic into an error so one bad subscriber
+// can't take down the dispatcher.
+func safeCall(ctx context.Context, handler Handler, event Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("subscriber panicked handling %s: %v", event.Type, r)
+		}
+	}()
+	return handler(ctx, event)
+}
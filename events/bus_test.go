@@ -0,0 +1,57 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPublish(t *testing.T) {
+	bus := NewBus()
+	var got []string
+	record := func(name string, err error) Handler {
+		return func(ctx context.Context, e Event) error {
+			got = append(got, name)
+			return err
+		}
+	}
+	failure := errors.New("subscriber failed")
+	bus.Subscribe(OrderPlaced, record("order", failure))
+	bus.Subscribe(UserRegistered, record("user", nil))
+	bus.Subscribe(OrderPlaced, func(context.Context, Event) error { panic("boom") })
+	bus.SubscribeAll(record("all", nil))
+
+	err := bus.Publish(context.Background(), Event{Type: OrderPlaced})
+	if !errors.Is(err, failure) {
+		t.Errorf("Publish() = %v, want the first subscriber's error", err)
+	}
+	if len(got) != 2 || got[0] != "order" || got[1] != "all" {
+		t.Errorf("Subscribers called: %v, want [order all]", got)
+	}
+
+	// A panicking subscriber is reported, not fatal
+	bus = NewBus()
+	bus.SubscribeAll(func(context.Context, Event) error { panic("boom") })
+	if err := bus.Publish(context.Background(), Event{Type: OrderPlaced}); err == nil {
+		t.Error("Publish() = nil, want the subscriber's panic as an error")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{7, 60 * time.Second},
+		{50, 60 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := Backoff(time.Second, time.Minute, tt.attempt); got != tt.want {
+			t.Errorf("Backoff(attempt %d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// events/dispatcher.go
+
+package events
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// Dispatcher polls the outbox and delivers pending events to the bus. An event
+// is marked dispatched only after every subscriber succeeded; failures are
+// retried with exponential backoff until MaxAttempts, after which the event is
+// marked failed and left in the table for inspection.
+type Dispatcher struct {
+	DB           *sql.DB
+	Bus          *Bus
+	PollInterval time.Duration
+	BatchSize    int
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	// Lease is how long claimed events are hidden from other dispatchers while their
+	// subscribers run; events whose dispatcher died become claimable again after it
+	Lease time.Duration
+	// Heartbeat, if set, is called before each batch so health checks can tell the worker is alive
+	Heartbeat func()
+}
+
+func NewDispatcher(db *sql.DB, bus *Bus) *Dispatcher {
+	return &Dispatcher{
+		DB:           db,
+		Bus:          bus,
+		PollInterval: 2 * time.Second,
+		BatchSize:    50,
+		MaxAttempts:  10,
+		BaseBackoff:  time.Second,
+		MaxBackoff:   10 * time.Minute,
+		Lease:        5 * time.Minute,
+	}
+}
+
+// Run dispatches events until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	slog.Info("Outbox dispatcher started")
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Keep draining while there is a full backlog, then wait for the next tick
+		for {
+			if d.Heartbeat != nil {
+				d.Heartbeat()
+			}
+			n, err := d.dispatchBatch(ctx)
+			if err != nil {
+				slog.Error("Outbox dispatcher: batch failed", "error", err)
+				break
+			}
+			if n < d.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Info("Outbox dispatcher stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatchBatch delivers up to BatchSize due events and returns how many were processed.
+func (d *Dispatcher) dispatchBatch(ctx context.Context) (int, error) {
+	// Claim events by pushing next_attempt_at past the lease, so several API instances
+	// can share the outbox without double delivery and subscribers run outside a
+	// transaction. Subscribers writing rows that reference outbox_events, such as
+	// webhook deliveries, would otherwise wait on the claim's row locks forever.
+	rows, err := d.DB.QueryContext(ctx, `
+		UPDATE outbox_events SET next_attempt_at = $2
+		WHERE id IN (
+			SELECT id FROM outbox_events
+			WHERE dispatched_at IS NULL AND failed_at IS NULL AND next_attempt_at <= NOW()
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING id, event_type, aggregate_id, payload, occurred_at, attempts`,
+		d.BatchSize, time.Now().Add(d.Lease))
+	if err != nil {
+		return 0, err
+	}
+
+	var batch []Event
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.ID, &e.Type, &e.AggregateID, &e.Payload, &e.OccurredAt, &e.Attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	// RETURNING doesn't keep the subquery's order
+	sort.Slice(batch, func(i, j int) bool { return batch[i].ID < batch[j].ID })
+
+	for _, e := range batch {
+		e.Attempts++
+		if err := d.Bus.Publish(ctx, e); err != nil {
+			if err := d.markFailedAttempt(ctx, e, err); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if _, err := d.DB.ExecContext(ctx, `UPDATE outbox_events SET attempts = $1, dispatched_at = NOW(), last_error = NULL WHERE id = $2`,
+			e.Attempts, e.ID); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(batch), nil
+}
+
+func (d *Dispatcher) markFailedAttempt(ctx context.Context, e Event, cause error) error {
+	if e.Attempts >= d.MaxAttempts {
+		slog.Error("Outbox dispatcher: giving up on event", "event_id", e.ID, "event_type", e.Type, "attempts", e.Attempts, "error", cause)
+		_, err := d.DB.ExecContext(ctx, `UPDATE outbox_events SET attempts = $1, last_error = $2, failed_at = NOW() WHERE id = $3`,
+			e.Attempts, cause.Error(), e.ID)
+		return err
+	}
+
+	delay := Backoff(d.BaseBackoff, d.MaxBackoff, e.Attempts)
+	slog.Warn("Outbox dispatcher: event failed, retrying", "event_id", e.ID, "event_type", e.Type, "attempt", e.Attempts, "retry_in", delay, "error", cause)
+	_, err := d.DB.ExecContext(ctx, `UPDATE outbox_events SET attempts = $1, last_error = $2, next_attempt_at = $3 WHERE id = $4`,
+		e.Attempts, cause.Error(), time.Now().Add(delay), e.ID)
+	return err
+}
+
+// Backoff returns the delay before retry number attempt: base doubled for each
+// previous attempt and capped at max.
+func Backoff(base, max time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= max {
+			return max
+		}
+	}
+	return delay
+}
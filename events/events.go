@@ -0,0 +1,91 @@
+// events/events.go
+
+package events
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Event types published through the outbox
+const (
+	OrderPlaced         = "order.placed"
+	OrderStatusChanged  = "order.status_changed"
+	OrderDeleted        = "order.deleted"
+	UserRegistered      = "user.registered"
+	ProductCreated      = "product.created"
+	ProductPriceChanged = "product.price_changed"
+	ProductDeleted      = "product.deleted"
+	CartItemAdded       = "cart.item_added"
+	CartItemUpdated     = "cart.item_updated"
+	CartItemRemoved     = "cart.item_removed"
+)
+
+// Types lists every event type, in the order above
+var Types = []string{
+	OrderPlaced, OrderStatusChanged, OrderDeleted, UserRegistered, ProductCreated,
+	ProductPriceChanged, ProductDeleted, CartItemAdded, CartItemUpdated, CartItemRemoved,
+}
+
+// Event is a domain event as stored in the outbox and handed to subscribers.
+// Delivery is at-least-once, so subscribers should use ID to ignore duplicates.
+type Event struct {
+	ID          int64           `json:"id"`
+	Type        string          `json:"type"`
+	AggregateID int             `json:"aggregate_id"`
+	Payload     json.RawMessage `json:"payload"`
+	OccurredAt  time.Time       `json:"occurred_at"`
+	Attempts    int             `json:"attempts"`
+}
+
+// Decode unmarshals the event payload into v.
+func (e Event) Decode(v interface{}) error {
+	return json.Unmarshal(e.Payload, v)
+}
+
+type OrderPlacedPayload struct {
+	OrderID    int     `json:"order_id"`
+	CustomerID int     `json:"customer_id"`
+	TotalPrice float64 `json:"total_price"`
+	Status     string  `json:"status"`
+}
+
+type OrderStatusChangedPayload struct {
+	OrderID    int    `json:"order_id"`
+	CustomerID int    `json:"customer_id"`
+	OldStatus  string `json:"old_status"`
+	NewStatus  string `json:"new_status"`
+}
+
+type OrderDeletedPayload struct {
+	OrderID int `json:"order_id"`
+}
+
+type UserRegisteredPayload struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+type ProductCreatedPayload struct {
+	ProductID int     `json:"product_id"`
+	Name      string  `json:"name"`
+	Price     float64 `json:"price"`
+}
+
+type ProductPriceChangedPayload struct {
+	ProductID int     `json:"product_id"`
+	OldPrice  float64 `json:"old_price"`
+	NewPrice  float64 `json:"new_price"`
+}
+
+type ProductDeletedPayload struct {
+	ProductID int `json:"product_id"`
+}
+
+type CartItemPayload struct {
+	UserID    int `json:"user_id"`
+	ProductID int `json:"product_id"`
+	ItemID    int `json:"item_id,omitempty"`
+	Quantity  int `json:"quantity"`
+}
@@ -0,0 +1,25 @@
+// events/outbox.go
+
+package events
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// Record writes an event to the outbox as part of tx. The event is only
+// dispatched if tx commits, so it can never describe a change that was rolled back.
+func Record(tx *sql.Tx, eventType string, aggregateID int, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error encoding %s payload: %w", eventType, err)
+	}
+
+	_, err = tx.Exec(`INSERT INTO outbox_events (event_type, aggregate_id, payload) VALUES ($1, $2, $3)`,
+		eventType, aggregateID, data)
+	if err != nil {
+		return fmt.Errorf("error writing %s to outbox: %w", eventType, err)
+	}
+	return nil
+}
@@ -7,7 +7,7 @@ export const fetchCartData = () => async (dispatch) => {
     dispatch({ type: 'FETCH_CART_REQUEST' });
 
     try {
-        const response = await fetchWithToken('/api/cart');
+        const response = await fetchWithToken('/api/v1/cart');
         if (!response.ok) {
             throw new Error('Failed to fetch cart');
         }
@@ -7,7 +7,7 @@ import { updateCartItems } from '../actions/cartActions';
 const AddToCart = ({ product_id, updateCartItems }) => {
     const [quantity, setQuantity] = useState(1);
     const addToCart = async () => {
-        const response = await fetchWithToken('/api/cart/items', {
+        const response = await fetchWithToken('/api/v1/cart/items', {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
@@ -1,6 +1,7 @@
 import React, { useState, useEffect } from 'react';
 import { BrowserRouter as Router, Route, Routes, Navigate } from 'react-router-dom';
 import Login from './Login';
+import LoginCallback from './LoginCallback';
 import Register from './Register';
 import ProductList from './ProductList';
 import ShoppingCart from './ShoppinCart';
@@ -26,6 +27,7 @@ const App = ({ cartLoading, cartError, fetchCartData }) => {
         <Router>
             <Routes>
                 <Route path="/login" element={isLoggedIn ? <Navigate to="/products" /> : <Login onLoginSuccess={handleLoginSuccess} />} />
+                <Route path="/login/callback" element={isLoggedIn ? <Navigate to="/products" /> : <LoginCallback onLoginSuccess={handleLoginSuccess} />} />
                 <Route path="/register" element={<Register />} />
                 <Route path="/products" element={<ProductList />} />
                 <Route path="/cart" element={<ShoppingCart />} />
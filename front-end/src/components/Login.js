@@ -11,7 +11,7 @@ const Login = ({ onLoginSuccess }) => {
         e.preventDefault();
 
         try {
-            const response = await fetch(`/api/auth/login`, {
+            const response = await fetch(`/api/v1/auth/login`, {
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json',
@@ -0,0 +1,42 @@
+import React, { useEffect, useState } from 'react';
+import { Link } from 'react-router-dom';
+
+// Messages for the error codes the API sends back after a social login
+const errorMessages = {
+    login_denied: 'The login was cancelled.',
+    invalid_state: 'The login expired or was started in another browser. Please try again.',
+    email_not_verified: 'Please verify your email address with the provider first.',
+    account_not_verified: 'An account with this email already exists. Log in with your password and verify your email address to link it.',
+};
+
+// LoginCallback is where the API sends the browser after a social login. The outcome
+// is in the URL fragment, which is removed from the address bar straight away.
+const LoginCallback = ({ onLoginSuccess }) => {
+    const [message, setMessage] = useState('Signing you in...');
+    const [outcome] = useState(() => new URLSearchParams(window.location.hash.slice(1)));
+
+    useEffect(() => {
+        window.history.replaceState(null, '', window.location.pathname);
+
+        if (outcome.get('error')) {
+            setMessage(errorMessages[outcome.get('error')] || 'Login failed. Please try again.');
+        } else if (outcome.get('mfa_token')) {
+            setMessage('Your account requires two-factor authentication.');
+        } else {
+            if (outcome.get('token')) {
+                localStorage.setItem('accessToken', outcome.get('token'));
+                localStorage.setItem('refreshToken', outcome.get('refresh_token'));
+            }
+            onLoginSuccess();
+        }
+    }, [outcome, onLoginSuccess]);
+
+    return (
+        <div className="login-container">
+            <p>{message}</p>
+            <Link to="/login">Back to login</Link>
+        </div>
+    );
+};
+
+export default LoginCallback;
@@ -12,7 +12,7 @@ const ProductList = () => {
         const fetchData = async () => {
             setLoading(true);
             try {
-                const response = await fetchWithToken('/api/products');
+                const response = await fetchWithToken('/api/v1/products');
                 if (!response.ok) {
                     throw new Error('Failed to fetch products');
                 }
@@ -25,13 +25,15 @@ const Register = () => {
     const handleSubmit = async (e) => {
         e.preventDefault();
         if (passwordMatch) {
+            // The API rejects fields it doesn't know, so confirmPassword stays here
+            const { confirmPassword, ...body } = formData;
             try {
-                const response = await fetch('/api/auth/register', {
+                const response = await fetch('/api/v1/auth/register', {
                     method: 'POST',
                     headers: {
                         'Content-Type': 'application/json'
                     },
-                    body: JSON.stringify(formData)
+                    body: JSON.stringify(body)
                 });
 
                 if (!response.ok) {
@@ -16,7 +16,7 @@ const ShoppingCart = ({ cartData, cartLoading, cartError, fetchCartData, hasFetc
 
     const onUpdate = async (productId, newQuantity) => {
         // Logic to update the item on the server
-        const response = await fetchWithToken(`/api/cart/items/${productId}`, {
+        const response = await fetchWithToken(`/api/v1/cart/items/${productId}`, {
             method: 'PUT',
             body: JSON.stringify({ quantity: newQuantity })
         });
@@ -29,7 +29,7 @@ const ShoppingCart = ({ cartData, cartLoading, cartError, fetchCartData, hasFetc
 
     const onDelete = async (productId) => {
         // Logic to delete the item from the server
-        const response = await fetchWithToken(`/api/cart/items/${productId}`, {
+        const response = await fetchWithToken(`/api/v1/cart/items/${productId}`, {
             method: 'DELETE'
         });
         if (!response.ok) {
@@ -47,7 +47,7 @@ const ShoppingCart = ({ cartData, cartLoading, cartError, fetchCartData, hasFetc
         const fetchProductDetails = async () => {
           const cartItemsWithDetails = await Promise.all(
             cartData.map(async (cartItem) => {
-              const response = await fetchWithToken(`/api/products/${cartItem.product_id}`);
+              const response = await fetchWithToken(`/api/v1/products/${cartItem.product_id}`);
               if (response.ok) {
                 const productData = await response.json();
                 return { ...cartItem, productData };
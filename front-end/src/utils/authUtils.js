@@ -26,17 +26,19 @@ export const fetchWithToken = async (url, options = {}) => {
 
 const refreshAccessToken = async (refreshToken) => {
     try {
-        const response = await fetch('/api/auth/refresh', {
+        const response = await fetch('/api/v1/auth/refresh', {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
             },
-            body: JSON.stringify({ refreshToken }),
+            body: JSON.stringify({ refresh_token: refreshToken }),
         });
 
         if (response.ok) {
             const data = await response.json();
-            localStorage.setItem('accessToken', data.accessToken); // Update the access token
+            // Refresh tokens are single use, so keep the replacement too
+            localStorage.setItem('accessToken', data.token);
+            localStorage.setItem('refreshToken', data.refreshToken);
             return true;
         } else {
             // Handle refresh token failure (e.g., remove tokens, redirect to login)
@@ -0,0 +1,154 @@
+// apiDocs.go
+
+package handlers
+
+import (
+	"gocommerce/apikeys"
+	"gocommerce/events"
+	"gocommerce/health"
+	"gocommerce/models"
+	"gocommerce/openapi"
+	"gocommerce/privacy"
+	"gocommerce/tokens"
+	"gocommerce/webhooks"
+	"net/http"
+	"strings"
+)
+
+// messageResponse is the body of endpoints that only confirm what they did
+type messageResponse struct {
+	Message string `json:"message"`
+}
+
+// statusResponse is the liveness probe's body
+type statusResponse struct {
+	Status string `json:"status"`
+}
+
+var (
+	includeDeletedParam = openapi.Param{Name: "include_deleted", Type: "boolean", Description: "Also return soft-deleted rows (admin only)"}
+	loginResponse       = openapi.OneOf(tokenResponse{}, mfaChallenge{})
+	webhookRequestRules = "url must be https on a public host; deliveries never follow redirects. " +
+		"event_types holds \"*\" or any of: " + strings.Join(events.Types, ", ")
+)
+
+// APIDocs documents every route for the OpenAPI document, keyed by method and full
+// path template, such as GET /api/v1/products/{id}. A route missing from here is
+// left out of the document and reported by `go run ./cmd/openapi -check`.
+var APIDocs = map[string]openapi.Operation{
+	// Products
+	"GET /api/v1/products":               {Summary: "List products", Query: []openapi.Param{includeDeletedParam}, Response: []models.Product{}},
+	"GET /api/v1/products/{id}":          {Summary: "Get a product", Query: []openapi.Param{includeDeletedParam}, Response: models.Product{}},
+	"POST /api/v1/products":              {Summary: "Create a product (staff)", Body: productRequest{}, Status: http.StatusCreated, Response: models.Product{}},
+	"PUT /api/v1/products/{id}":          {Summary: "Replace a product (staff)", Body: productRequest{}, Response: models.Product{}},
+	"DELETE /api/v1/products/{id}":       {Summary: "Delete a product (admin)", Status: http.StatusNoContent},
+	"POST /api/v1/products/{id}/restore": {Summary: "Restore a deleted product (admin)", Response: models.Product{}},
+
+	// Users
+	"GET /api/v1/users":      {Summary: "List users (admin or API key)", Query: []openapi.Param{includeDeletedParam}, Response: []models.User{}},
+	"GET /api/v1/users/{id}": {Summary: "Get a user (admin or API key)", Query: []openapi.Param{includeDeletedParam}, Response: models.User{}},
+	"POST /api/v1/users":     {Summary: "Create a user (admin)", Body: createUserRequest{}, Status: http.StatusCreated, Response: models.User{}},
+	"PUT /api/v1/users/{id}": {Summary: "Replace a user's details (admin)", Body: updateUserRequest{}, Response: updatedUser{},
+		Description: "Responds 202 when a new email address is waiting for verification; the account keeps its current address until the user follows the link sent to the new one."},
+	"DELETE /api/v1/users/{id}":          {Summary: "Delete a user (admin)", Status: http.StatusNoContent},
+	"POST /api/v1/users/{id}/restore":    {Summary: "Restore a deleted user (admin)", Response: models.User{}},
+	"DELETE /api/v1/users/{id}/sessions": {Summary: "Sign a user out everywhere (admin)", Status: http.StatusNoContent},
+	"POST /api/v1/users/{id}/erasure":    {Summary: "File an erasure on a user's behalf (admin)", Tag: "data-requests", Status: http.StatusAccepted, Response: models.DataRequest{}},
+
+	// Orders
+	"GET /api/v1/orders":      {Summary: "List orders", Query: []openapi.Param{includeDeletedParam}, Response: []models.Order{}},
+	"GET /api/v1/orders/{id}": {Summary: "Get an order", Query: []openapi.Param{includeDeletedParam}, Response: models.Order{}},
+	"POST /api/v1/orders": {Summary: "Create an order", Body: createOrderRequest{}, Status: http.StatusCreated, Response: models.Order{},
+		Description: "With an API key, the order is placed for customer_id, whose email address must be verified when checkout requires it."},
+	"PUT /api/v1/orders/{id}":          {Summary: "Change an order's status (admin)", Body: updateOrderRequest{}, Response: models.Order{}},
+	"DELETE /api/v1/orders/{id}":       {Summary: "Delete an order (admin)", Status: http.StatusNoContent},
+	"POST /api/v1/orders/{id}/restore": {Summary: "Restore a deleted order (admin)", Response: models.Order{}},
+
+	// Shopping cart
+	"GET /api/v1/cart":               {Summary: "Get the current user's cart", Response: []models.CartItem{}},
+	"POST /api/v1/cart/items":        {Summary: "Add a product to the cart", Body: addCartItemRequest{}, Response: []models.CartItem{}},
+	"PUT /api/v1/cart/items/{id}":    {Summary: "Change a cart item's quantity", Body: updateCartItemRequest{}, Response: messageResponse{}},
+	"DELETE /api/v1/cart/items/{id}": {Summary: "Remove an item from the cart", Response: messageResponse{}},
+
+	// Authentication
+	"POST /api/v1/auth/login": {Summary: "Log in with a password", Body: loginRequest{}, Response: loginResponse,
+		Description: "Returns tokens, or an MFA token when the account needs a second factor. In cookie mode the tokens are set as cookies and only csrfToken is returned."},
+	"POST /api/v1/auth/register": {Summary: "Create an account", Body: registerRequest{}, Status: http.StatusCreated, Response: registerResponse{}},
+	"POST /api/v1/auth/refresh": {Summary: "Exchange a refresh token for new tokens", Body: refreshTokenRequest{}, OptionalBody: true, Response: tokenResponse{},
+		Description: "Cookie sessions send no body and must send the CSRF token. Refresh tokens are single use: keep the one returned."},
+	"POST /api/v1/auth/logout":   {Summary: "End the session", Body: refreshTokenRequest{}, OptionalBody: true, Status: http.StatusNoContent},
+	"GET /.well-known/jwks.json": {Summary: "Public keys for verifying tokens", Tag: "auth", Response: tokens.JWKS{}},
+	"POST /api/v1/auth/forgot-password": {Summary: "Email a password reset link", Body: forgotPasswordRequest{}, Status: http.StatusAccepted, Response: messageResponse{},
+		Description: "Requests are limited per address and per client IP; over the limit the answer is 429 with Retry-After."},
+	"POST /api/v1/auth/reset-password": {Summary: "Set a new password with a reset token", Body: resetPasswordRequest{}, Response: messageResponse{}},
+	"GET /api/v1/auth/verify": {Summary: "Confirm an email address", Response: messageResponse{},
+		Query: []openapi.Param{{Name: "token", Description: "The token from the emailed link"}}},
+	"POST /api/v1/auth/verify/resend": {Summary: "Send a new verification email", Status: http.StatusAccepted, Response: messageResponse{},
+		Description: "Goes to the pending address while an email change is waiting to be confirmed, otherwise to the current one"},
+
+	// Two-factor authentication
+	"POST /api/v1/auth/mfa/verify":         {Summary: "Finish a login with a TOTP or recovery code", Body: mfaRequest{}, Response: tokenResponse{}},
+	"POST /api/v1/auth/mfa/enroll":         {Summary: "Start the MFA enrollment a login requires", Body: mfaRequest{}, Response: mfaEnrollment{}},
+	"POST /api/v1/auth/mfa/enroll/confirm": {Summary: "Finish required MFA enrollment and log in", Body: mfaRequest{}, Response: tokenResponse{}},
+	"POST /api/v1/me/mfa/enroll":           {Summary: "Start MFA enrollment", Tag: "me", Response: mfaEnrollment{}},
+	"POST /api/v1/me/mfa/confirm":          {Summary: "Enable MFA", Tag: "me", Body: mfaRequest{}, Response: recoveryCodesResponse{}},
+	"POST /api/v1/me/mfa/disable":          {Summary: "Disable MFA", Tag: "me", Body: mfaRequest{}, Response: messageResponse{}},
+	"POST /api/v1/me/mfa/recovery-codes":   {Summary: "Replace the recovery codes", Tag: "me", Body: mfaRequest{}, Response: recoveryCodesResponse{}},
+
+	// Social login
+	"GET /api/v1/auth/oidc/{provider}/login": {Summary: "Redirect to an OpenID Connect provider", Status: http.StatusFound},
+	"GET /api/v1/auth/oidc/{provider}/callback": {Summary: "Finish a login when the provider redirects back", Status: http.StatusFound,
+		Description: "Redirects to the front end's login callback page with the outcome in the URL fragment: error, " +
+			"mfa_token with mfa_required or mfa_enrollment_required, or on success csrf_token in cookie mode and token and refresh_token otherwise."},
+
+	// The current user's account
+	"GET /api/v1/me": {Summary: "Get the current user's profile", Response: Profile{}},
+	"PATCH /api/v1/me": {Summary: "Change the username or email address", Body: updateProfileRequest{}, Response: Profile{},
+		Description: "Responds 202 when a new email address is waiting for verification. Changing it needs current_password."},
+	"DELETE /api/v1/me":                          {Summary: "Delete the current user's account", Body: deleteAccountRequest{}, Status: http.StatusNoContent},
+	"POST /api/v1/me/password":                   {Summary: "Change the password", Body: changePasswordRequest{}, Response: messageResponse{}},
+	"GET /api/v1/me/sessions":                    {Summary: "List the current user's sessions", Response: []Session{}},
+	"DELETE /api/v1/me/sessions/{id}":            {Summary: "Sign out of a session", Status: http.StatusNoContent},
+	"POST /api/v1/me/export":                     {Summary: "Queue an export of the user's data", Status: http.StatusAccepted, Response: models.DataRequest{}},
+	"POST /api/v1/me/erasure":                    {Summary: "Close the account and queue its erasure", Body: deleteAccountRequest{}, Status: http.StatusAccepted, Response: models.DataRequest{}},
+	"GET /api/v1/me/data-requests":               {Summary: "List the user's data requests", Response: []models.DataRequest{}},
+	"GET /api/v1/me/data-requests/{id}/download": {Summary: "Download a completed export", ContentType: "application/zip"},
+
+	// Data requests
+	"GET /api/v1/data-requests": {Summary: "List data requests (admin)", Response: []models.DataRequest{}, Query: []openapi.Param{
+		{Name: "status", Enum: []string{privacy.StatusPending, privacy.StatusProcessing, privacy.StatusCompleted, privacy.StatusFailed, privacy.StatusExpired}},
+		{Name: "type", Enum: []string{privacy.TypeExport, privacy.TypeErasure}},
+	}},
+	"GET /api/v1/data-requests/{id}":        {Summary: "Get a data request (admin)", Response: models.DataRequest{}},
+	"POST /api/v1/data-requests/{id}/retry": {Summary: "Requeue a failed data request (admin)", Response: models.DataRequest{}},
+
+	// Webhooks
+	"GET /api/v1/webhooks": {Summary: "List subscriptions (admin or API key)", Response: []models.WebhookSubscription{}},
+	"POST /api/v1/webhooks": {Summary: "Create a subscription (admin or API key)", Body: webhookRequest{}, Status: http.StatusCreated, Response: models.WebhookSubscription{},
+		Description: webhookRequestRules},
+	"GET /api/v1/webhooks/{id}": {Summary: "Get a subscription (admin or API key)", Response: models.WebhookSubscription{}},
+	"PUT /api/v1/webhooks/{id}": {Summary: "Replace a subscription (admin or API key)", Body: webhookRequest{}, Response: models.WebhookSubscription{},
+		Description: webhookRequestRules},
+	"DELETE /api/v1/webhooks/{id}": {Summary: "Delete a subscription (admin or API key)", Status: http.StatusNoContent},
+	"GET /api/v1/webhooks/{id}/deliveries": {Summary: "List a subscription's latest deliveries (admin or API key)", Response: []models.WebhookDelivery{},
+		Query: []openapi.Param{{Name: "status", Enum: []string{webhooks.StatusPending, webhooks.StatusSucceeded, webhooks.StatusDead}}}},
+	"GET /api/v1/webhooks/deliveries/{id}":            {Summary: "Get a delivery and its attempts (admin or API key)", Response: webhookDeliveryDetail{}},
+	"POST /api/v1/webhooks/deliveries/{id}/redeliver": {Summary: "Send a delivery again (admin or API key)", Status: http.StatusAccepted, Response: messageResponse{}},
+
+	// API keys
+	"GET /api/v1/api-keys": {Summary: "List API keys (admin)", Response: []apikeys.Key{},
+		Query: []openapi.Param{{Name: "include_revoked", Type: "boolean", Description: "Also return revoked and expired keys"}}},
+	"POST /api/v1/api-keys": {Summary: "Create an API key (admin)", Body: apiKeyRequest{}, Status: http.StatusCreated, Response: apiKeyResponse{},
+		Description: "The key is only ever shown in this response. scopes holds any of: " + strings.Join(apikeys.Scopes, ", ")},
+	"POST /api/v1/api-keys/{id}/rotate": {Summary: "Replace an API key (admin)", Status: http.StatusCreated, Response: apiKeyResponse{},
+		Description: "The old key keeps working for API_KEY_ROTATION_GRACE."},
+	"DELETE /api/v1/api-keys/{id}": {Summary: "Revoke an API key (admin)", Status: http.StatusNoContent},
+
+	// Operations
+	"GET /healthz": {Summary: "Liveness probe", Tag: "health", Response: statusResponse{}},
+	"GET /readyz":  {Summary: "Readiness probe", Tag: "health", Response: health.Report{}, Description: "Responds 503 with the same body when a check fails."},
+	"GET /metrics": {Summary: "Prometheus metrics", Tag: "health", ID: "GetMetrics", ContentType: "text/plain",
+		Description: "Needs METRICS_TOKEN as a bearer token. Without a configured token it responds 403, unless METRICS_PUBLIC opens it for local development."},
+	"GET /api/v1/openapi.json": {Summary: "This document", Tag: "docs", ID: "GetOpenAPIDocument", Response: map[string]any{}},
+	"GET /api/v1/docs":         {Summary: "Browsable API reference", Tag: "docs", ID: "GetAPIDocs", ContentType: "text/html"},
+}
@@ -0,0 +1,243 @@
+// apiKeysHandler.go
+
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"gocommerce/apikeys"
+	"gocommerce/apperrors"
+	"gocommerce/config"
+	"gocommerce/constants"
+	"gocommerce/validation"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// apiKeyRotationGrace is how long a rotated key keeps working, so integrations can switch over
+var apiKeyRotationGrace = config.Duration("API_KEY_ROTATION_GRACE", 24*time.Hour)
+
+// APIKeyHandler manages API keys. Every endpoint is admin only and can't be called with an API key.
+type APIKeyHandler struct {
+	DB *sql.DB
+}
+
+type apiKeyRequest struct {
+	Name   string   `json:"name" validate:"required,max=100"`
+	Scopes []string `json:"scopes" validate:"required"`
+}
+
+// Validate checks every scope is one apikeys knows about.
+func (req *apiKeyRequest) Validate() []apperrors.FieldError {
+	var errs []apperrors.FieldError
+	for i, scope := range req.Scopes {
+		if !apikeys.ValidScope(scope) {
+			errs = append(errs, apperrors.FieldError{
+				Field:   "scopes[" + strconv.Itoa(i) + "]",
+				Message: "must be one of: " + strings.Join(apikeys.Scopes, ", "),
+			})
+		}
+	}
+	return errs
+}
+
+// apiKeyResponse is returned when a key is created or rotated, the only time the key is shown
+type apiKeyResponse struct {
+	apikeys.Key
+	Value string `json:"key"`
+}
+
+// insertAPIKey generates a key and stores it with tx.
+func insertAPIKey(tx *sql.Tx, name string, scopes []string, createdBy int) (*apiKeyResponse, error) {
+	key, prefix, keyHash, err := apikeys.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &apiKeyResponse{Value: key}
+	resp.Name = name
+	resp.Prefix = prefix
+	resp.Scopes = scopes
+	resp.CreatedBy = &createdBy
+	err = tx.QueryRow(`INSERT INTO api_keys (name, prefix, key_hash, scopes, created_by)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`,
+		name, prefix, keyHash, pq.Array(scopes), createdBy).Scan(&resp.ID, &resp.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (h *APIKeyHandler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(h.DB, w, r) {
+		return
+	}
+
+	query := "SELECT id, name, prefix, scopes, created_by, created_at, last_used_at, expires_at, revoked_at FROM api_keys"
+	if r.URL.Query().Get("include_revoked") != "true" {
+		query += " WHERE revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())"
+	}
+	rows, err := h.DB.QueryContext(r.Context(), query+" ORDER BY id")
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error fetching API keys", err))
+		return
+	}
+	defer rows.Close()
+
+	keys := []apikeys.Key{}
+	for rows.Next() {
+		var k apikeys.Key
+		var createdBy sql.NullInt64
+		var lastUsedAt, expiresAt, revokedAt sql.NullTime
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, pq.Array(&k.Scopes), &createdBy, &k.CreatedAt,
+			&lastUsedAt, &expiresAt, &revokedAt); err != nil {
+			apperrors.Write(w, r, apperrors.Internal("Error scanning API key", err))
+			return
+		}
+		if createdBy.Valid {
+			id := int(createdBy.Int64)
+			k.CreatedBy = &id
+		}
+		if lastUsedAt.Valid {
+			k.LastUsedAt = &lastUsedAt.Time
+		}
+		if expiresAt.Valid {
+			k.ExpiresAt = &expiresAt.Time
+		}
+		if revokedAt.Valid {
+			k.RevokedAt = &revokedAt.Time
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error iterating API keys", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// CreateAPIKey creates a key and returns it. The key can't be retrieved again.
+func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(h.DB, w, r) {
+		return
+	}
+	userID, _ := r.Context().Value(constants.UserIDKey).(int)
+
+	var req apiKeyRequest
+	if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+
+	tx, err := h.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error starting transaction", err))
+		return
+	}
+	defer tx.Rollback()
+
+	resp, err := insertAPIKey(tx, req.Name, req.Scopes, userID)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error creating API key", err))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error committing transaction", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// RotateAPIKey issues a replacement key with the same name and scopes. The old key
+// keeps working until the rotation grace period ends.
+func (h *APIKeyHandler) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(h.DB, w, r) {
+		return
+	}
+	userID, _ := r.Context().Value(constants.UserIDKey).(int)
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid API key ID"))
+		return
+	}
+
+	tx, err := h.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error starting transaction", err))
+		return
+	}
+	defer tx.Rollback()
+
+	// Locking the row stops two rotations of the same key from racing
+	var name string
+	var scopes []string
+	err = tx.QueryRowContext(r.Context(), `SELECT name, scopes FROM api_keys
+		WHERE id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW()) FOR UPDATE`,
+		id).Scan(&name, pq.Array(&scopes))
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.NotFound("API key not found"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error fetching API key", err))
+		return
+	}
+
+	// Never extend a grace period that is already shorter
+	_, err = tx.ExecContext(r.Context(), "UPDATE api_keys SET expires_at = LEAST(COALESCE(expires_at, $1), $1) WHERE id = $2",
+		time.Now().Add(apiKeyRotationGrace), id)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error expiring API key", err))
+		return
+	}
+
+	resp, err := insertAPIKey(tx, name, scopes, userID)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error creating API key", err))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error committing transaction", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// RevokeAPIKey stops a key from working immediately.
+func (h *APIKeyHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(h.DB, w, r) {
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid API key ID"))
+		return
+	}
+
+	result, err := h.DB.ExecContext(r.Context(), "UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL", id)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error revoking API key", err))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apperrors.Write(w, r, apperrors.NotFound("API key not found"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
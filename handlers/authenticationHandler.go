@@ -1,297 +1,484 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"fmt"
+	"gocommerce/apperrors"
+	"gocommerce/cookies"
+	"gocommerce/events"
+	"gocommerce/logging"
+	"gocommerce/metrics"
 	"gocommerce/models"
-	"log"
+	"gocommerce/notifications"
+	"gocommerce/oidclogin"
+	"gocommerce/tokens"
+	"gocommerce/validation"
 	"net/http"
-	"os"
-	"regexp"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthenticationHandler struct {
-	DB *sql.DB
+	DB       *sql.DB
+	Notifier *notifications.Notifier
+	// OIDCProviders are the external login providers, keyed by name
+	OIDCProviders map[string]*oidclogin.Provider
 }
 
-var jwtSecretKey = []byte(os.Getenv("JWT_SECRET_KEY"))
+// errInvalidCredentials covers both unknown usernames and wrong passwords so
+// callers can't tell them apart
+var errInvalidCredentials = errors.New("invalid credentials")
 
-func (h *AuthenticationHandler) validateCredentials(username, password string) (float64, error) {
+// registerRequest is the body for signing up
+type registerRequest struct {
+	Username string `json:"username" validate:"required,min=3,max=50,username"`
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Password string `json:"password" validate:"required,min=8,max=72"` // bcrypt ignores anything past 72 bytes
+}
+
+// loginRequest is the body for logging in with a password
+type loginRequest struct {
+	Username string `json:"username" validate:"required,max=255"`
+	Password string `json:"password" validate:"required"`
+}
+
+// refreshTokenRequest carries the refresh token for clients that don't use cookies.
+// Logout doesn't require it, since there may be nothing left to revoke.
+type refreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// tokenResponse is the body of every response that signs a user in or refreshes their
+// tokens. In cookie mode the tokens are set as cookies and only the CSRF token is returned.
+type tokenResponse struct {
+	Token         string   `json:"token,omitempty"`
+	RefreshToken  string   `json:"refreshToken,omitempty"`
+	CSRFToken     string   `json:"csrfToken,omitempty"`
+	RecoveryCodes []string `json:"recovery_codes,omitempty"` // Only when MFA enrollment finishes the login
+}
+
+// registerResponse is the body returned when an account is created
+type registerResponse struct {
+	Message string       `json:"message"`
+	User    registeredAs `json:"user"`
+}
+
+type registeredAs struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// dummyPasswordHash returns a bcrypt hash with the same cost as real ones.
+// Comparing against it for unknown usernames makes them take as long as a
+// wrong password.
+func dummyPasswordHash() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), passwordHashCost)
+	})
+	return dummyHash
+}
+
+func (h *AuthenticationHandler) validateCredentials(username, password string) (int, error) {
 	var hashedPassword string
-	var userID float64
-	err := h.DB.QueryRow("SELECT id, password_hash FROM users WHERE username = $1", username).Scan(&userID, &hashedPassword)
-	if err != nil {
-		// Handle sql.ErrNoRows separately if needed (user not found)
-		// Return 0 for the userID and the error
+	var userID int
+	err := h.DB.QueryRow("SELECT id, password_hash FROM users WHERE username = $1 AND deleted_at IS NULL", username).Scan(&userID, &hashedPassword)
+	if err == sql.ErrNoRows {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return 0, errInvalidCredentials
+	} else if err != nil {
 		return 0, err
 	}
 
 	// Compare the hashed password with the provided password
 	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 	if err != nil {
-		// Return 0 for the userID and a specific error indicating password mismatch
-		return 0, fmt.Errorf("password mismatch")
+		return 0, errInvalidCredentials
 	}
 
 	// Return the user ID and nil error if the credentials are valid
 	return userID, nil
 }
 
-func (h *AuthenticationHandler) GenerateToken(userID float64) (string, string, error) {
-	// Log the user ID being used
-	log.Printf("Generating token for user ID: %v", userID)
+// Token lifetimes
+const (
+	accessTokenTTL  = 15 * time.Minute   // short-lived access token
+	refreshTokenTTL = 7 * 24 * time.Hour // long-lived refresh token
+)
 
-	// Generate Access Token
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(time.Minute * 15).Unix(), // short-lived access token
-	})
+// errRefreshReplayed is returned by rotateTokens when the refresh token being
+// exchanged is no longer the session's latest one
+var errRefreshReplayed = errors.New("refresh token has already been exchanged")
+
+// GenerateToken issues an access and refresh token pair for a session. The new refresh
+// token becomes the only one in the session's family that can be exchanged.
+func (h *AuthenticationHandler) GenerateToken(ctx context.Context, userID, sessionID int) (string, string, error) {
+	return h.rotateTokens(ctx, userID, sessionID, "")
+}
+
+// rotateTokens is GenerateToken for a refresh. Unless previousJTI is empty, the
+// session only moves on if previousJTI is still its latest refresh token. That is
+// checked by the statement that rotates it, so two requests exchanging the same
+// token can't both succeed; the loser gets errRefreshReplayed.
+func (h *AuthenticationHandler) rotateTokens(ctx context.Context, userID, sessionID int, previousJTI string) (string, string, error) {
+	logger := logging.FromContext(ctx)
+	logger.Debug("Generating tokens", "session_id", sessionID)
 
-	// Log the Access Token Claims
-	log.Printf("Access Token Claims: %+v", accessToken.Claims)
+	// Generate Access Token
+	accessClaims, err := tokens.NewClaims(userID, tokens.TypeAccess, accessTokenTTL)
+	if err != nil {
+		return "", "", err
+	}
+	accessClaims.SessionID = sessionID
 
-	at, err := accessToken.SignedString(jwtSecretKey)
+	at, err := tokens.Sign(accessClaims)
 	if err != nil {
-		log.Printf("Error signing access token: %v", err)
+		logger.Error("Error signing access token", "error", err)
+		return "", "", err
+	}
+
+	// The token version lets a password reset revoke every outstanding refresh token
+	var tokenVersion int
+	if err := h.DB.QueryRowContext(ctx, "SELECT token_version FROM users WHERE id = $1", userID).Scan(&tokenVersion); err != nil {
+		logger.Error("Error loading token version", "error", err)
 		return "", "", err
 	}
 
 	// Generate Refresh Token
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 24 * 7).Unix(), // long-lived refresh token
-	})
+	refreshClaims, err := tokens.NewClaims(userID, tokens.TypeRefresh, refreshTokenTTL)
+	if err != nil {
+		return "", "", err
+	}
+	refreshClaims.TokenVersion = tokenVersion
+	refreshClaims.SessionID = sessionID
 
-	// Log the Refresh Token Claims
-	log.Printf("Refresh Token Claims: %+v", refreshToken.Claims)
+	rt, err := tokens.Sign(refreshClaims)
+	if err != nil {
+		logger.Error("Error signing refresh token", "error", err)
+		return "", "", err
+	}
 
-	rt, err := refreshToken.SignedString(jwtSecretKey)
+	// Rotate the session's refresh token and extend it to the new token's expiry
+	query := "UPDATE sessions SET refresh_jti = $1, last_used_at = NOW(), expires_at = $2 WHERE id = $3"
+	args := []interface{}{refreshClaims.ID, refreshClaims.ExpiresAt.Time, sessionID}
+	if previousJTI != "" {
+		query += " AND refresh_jti = $4 AND revoked_at IS NULL"
+		args = append(args, previousJTI)
+	}
+	result, err := h.DB.ExecContext(ctx, query, args...)
 	if err != nil {
-		log.Printf("Error signing refresh token: %v", err)
+		logger.Error("Error updating session", "error", err)
 		return "", "", err
 	}
+	if n, _ := result.RowsAffected(); n == 0 && previousJTI != "" {
+		return "", "", errRefreshReplayed
+	}
 
 	return at, rt, nil
 }
 
+// passwordHashCost is the bcrypt cost used for every stored password
+const passwordHashCost = 14
+
 func hashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
 	return string(bytes), err
 }
 
-// validateUserData checks the new user data for validity.
-func (h *AuthenticationHandler) validateUserData(user *models.User) error {
-	// Validate email format
-	if err := validateEmailFormat(user.Email); err != nil {
+// normalizeEmail lower-cases an address so the unique index and lookups ignore case
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// createUserRecord hashes password and inserts user, filling in its ID.
+func (h *AuthenticationHandler) createUserRecord(user *models.User, password string) error {
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
 		return err
 	}
+	user.PasswordHash = hashedPassword
 
-	// Check if username or email is already in use
-	if err := h.checkUserExists(user.Username, user.Email); err != nil {
+	tx, err := h.DB.Begin()
+	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	// Check password complexity
-	if err := validatePasswordComplexity(user.Password); err != nil {
+	// Insert user record into the database
+	sqlStatement := `INSERT INTO users (username, email, password_hash) VALUES ($1, $2, $3) RETURNING id`
+	if err := tx.QueryRow(sqlStatement, user.Username, user.Email, user.PasswordHash).Scan(&user.ID); err != nil {
 		return err
 	}
 
-	return nil
-}
-
-// validateEmailFormat validates the format of the email.
-func validateEmailFormat(email string) error {
-	// Regular expression for validating an email
-	re := regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`)
-	if !re.MatchString(email) {
-		return errors.New("invalid email format")
+	err = events.Record(tx, events.UserRegistered, user.ID, events.UserRegisteredPayload{
+		UserID:   user.ID,
+		Username: user.Username,
+		Email:    user.Email,
+	})
+	if err != nil {
+		return err
 	}
-	return nil
-}
 
-// checkUserExists checks if the username or email is already in use.
-func (h *AuthenticationHandler) checkUserExists(username, email string) error {
-	var id int
-	err := h.DB.QueryRow("SELECT id FROM users WHERE username = $1 OR email = $2", username, email).Scan(&id)
-	if err != nil && err != sql.ErrNoRows {
-		return errors.New("username or email already in use")
-	}
-	return nil
+	return tx.Commit()
 }
 
-// validatePasswordComplexity checks if the password meets complexity requirements.
-func validatePasswordComplexity(password string) error {
-	// Example: Minimum 8 characters
-	if len(password) < 8 {
-		return errors.New("password must be at least 8 characters long")
+func (h *AuthenticationHandler) Login(w http.ResponseWriter, r *http.Request) {
+	var creds loginRequest
+	if err := validation.Decode(w, r, &creds); err != nil {
+		apperrors.Write(w, r, err)
+		return
 	}
-	return nil
-}
 
-func (h *AuthenticationHandler) createUserRecord(user *models.User) error {
-	hashedPassword, err := hashPassword(user.Password)
+	throttle := loginThrottle{DB: h.DB}
+	ip := clientIP(r)
+
+	// The attempt is counted, and locked accounts and IPs rejected, before the password is checked
+	attempts, lockedFor, err := throttle.attempt(creds.Username, ip)
 	if err != nil {
-		return err
+		apperrors.Write(w, r, apperrors.Internal("Error checking login throttle", err))
+		return
 	}
-	user.PasswordHash = hashedPassword
-
-	// Insert user record into the database
-	sqlStatement := `INSERT INTO users (username, email, password_hash) VALUES ($1, $2, $3)`
-	_, err = h.DB.Exec(sqlStatement, user.Username, user.Email, user.PasswordHash)
-	return err
-}
-
-func (h *AuthenticationHandler) Login(w http.ResponseWriter, r *http.Request) {
-	var creds models.Credentials
-	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if lockedFor > 0 {
+		metrics.LoginFailures.WithLabelValues(metrics.LoginLockedOut).Inc()
+		retryAfter(w, lockedFor)
+		apperrors.Write(w, r, apperrors.TooManyRequests("Too many failed login attempts, try again later"))
 		return
 	}
 
 	// Validate credentials
 	userID, err := h.validateCredentials(creds.Username, creds.Password)
-	if err != nil {
-		if err.Error() == "password mismatch" {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
-		} else {
-			// Handle other errors, like database errors
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			log.Printf("Error validating credentials: %v", err)
+	if err == errInvalidCredentials {
+		metrics.LoginFailures.WithLabelValues(metrics.LoginInvalidCredentials).Inc()
+		if err := throttle.failed(creds.Username, attempts); err != nil {
+			logger(r).Error("Error recording failed login", "error", err)
 		}
+		apperrors.Write(w, r, apperrors.Unauthorized("Invalid credentials").WithCode("invalid_credentials"))
 		return
-	}
-	// Check if userID is valid
-	if userID == 0 {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+	} else if err != nil {
+		// Handle other errors, like database errors
+		apperrors.Write(w, r, apperrors.Internal("Error validating credentials", err))
 		return
 	}
 
-	// Generate token
-	accessToken, refreshToken, err := h.GenerateToken(userID)
-	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
-		log.Printf("Error generating token: %v", err)
+	if err := throttle.succeeded(creds.Username, ip); err != nil {
+		logger(r).Error("Error resetting login throttle", "error", err)
+	}
+
+	// Accounts with MFA get a short-lived pending token instead of real tokens
+	if h.requireSecondFactor(w, r, userID) {
 		return
 	}
 
-	response := map[string]string{
-		"token":        accessToken,
-		"refreshToken": refreshToken,
+	h.respondWithTokens(w, r, userID, nil)
+}
+
+// JWKS publishes the public signing keys so other services can verify tokens.
+func (h *AuthenticationHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(tokens.Default().JWKS())
+}
+
+// respondWithTokens starts a session, generates its first access and refresh token pair
+// and writes the login response, including any recovery codes.
+func (h *AuthenticationHandler) respondWithTokens(w http.ResponseWriter, r *http.Request, userID int, recoveryCodes []string) {
+	response, err := h.issueTokens(w, r, userID)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
 	}
+	response.RecoveryCodes = recoveryCodes
 
 	// Encoding the response as JSON and sending it
 	json.NewEncoder(w).Encode(response)
 }
 
-func (h *AuthenticationHandler) Register(w http.ResponseWriter, r *http.Request) {
-	var user models.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+// issueTokens starts a session and generates its first access and refresh token pair.
+// In cookie mode the tokens are set as cookies and only the CSRF token is returned.
+func (h *AuthenticationHandler) issueTokens(w http.ResponseWriter, r *http.Request, userID int) (tokenResponse, error) {
+	sessionID, err := h.createSession(r, userID)
+	if err != nil {
+		return tokenResponse{}, apperrors.Internal("Error creating session", err)
+	}
+
+	// Generate token
+	accessToken, refreshToken, err := h.GenerateToken(r.Context(), userID, sessionID)
+	if err != nil {
+		return tokenResponse{}, apperrors.Internal("Error generating token", err)
+	}
+
+	// In cookie mode the tokens never reach the page's scripts
+	if cookies.Enabled {
+		csrfToken, err := cookies.SetTokens(w, accessToken, refreshToken, accessTokenTTL, refreshTokenTTL)
+		if err != nil {
+			return tokenResponse{}, apperrors.Internal("Error generating CSRF token", err)
+		}
+		return tokenResponse{CSRFToken: csrfToken}, nil
 	}
+	return tokenResponse{Token: accessToken, RefreshToken: refreshToken}, nil
+}
 
-	// Validate user data
-	if err := h.validateUserData(&user); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+// Logout ends the session named by the refresh token cookie and clears the session
+// cookies. Bearer token clients can send their refresh token in the body instead.
+func (h *AuthenticationHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	// The path is public so an expired session can still log out, which means the
+	// CSRF check the middleware does for cookie requests has to happen here
+	hasSession := cookies.Value(r, cookies.AccessCookieName) != "" || cookies.Value(r, cookies.RefreshCookieName) != ""
+	if hasSession && !cookies.ValidCSRF(r) {
+		apperrors.Write(w, r, apperrors.Forbidden("Invalid CSRF token").WithCode("invalid_csrf_token"))
 		return
 	}
 
-	// Hash password
-	hashedPassword, err := hashPassword(user.Password)
-	if err != nil {
-		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+	refreshToken := cookies.Value(r, cookies.RefreshCookieName)
+	if refreshToken == "" {
+		var req refreshTokenRequest
+		if err := validation.DecodeOptional(w, r, &req); err != nil {
+			apperrors.Write(w, r, err)
+			return
+		}
+		refreshToken = req.RefreshToken
+	}
+	// An invalid or expired token has nothing left to revoke
+	if claims, err := tokens.ParseClaims(refreshToken, tokens.TypeRefresh); err == nil {
+		if userID, err := claims.UserID(); err == nil {
+			_, err := h.DB.ExecContext(r.Context(), "UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL",
+				claims.SessionID, userID)
+			if err != nil {
+				apperrors.Write(w, r, apperrors.Internal("Error revoking session", err))
+				return
+			}
+		}
+	}
+
+	cookies.Clear(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AuthenticationHandler) Register(w http.ResponseWriter, r *http.Request) {
+	var req registerRequest
+	if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
 		return
 	}
-	user.PasswordHash = hashedPassword
 
-	if err := h.createUserRecord(&user); err != nil {
-		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+	user := models.User{Username: strings.TrimSpace(req.Username), Email: normalizeEmail(req.Email)}
+	err := h.createUserRecord(&user, req.Password)
+	if isUniqueViolation(err) {
+		apperrors.Write(w, r, apperrors.Conflict("username or email already in use"))
 		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error creating user", err))
+		return
+	}
+
+	// The account exists either way; a failed email can be retried through the resend endpoint
+	if err := h.sendVerificationEmail(user.ID, user.Username, user.Email); err != nil {
+		logger(r).Error("Error sending verification email", "error", err)
 	}
 	// Respond with success or user data (excluding sensitive information like password)
 	w.WriteHeader(http.StatusCreated)
-	response := map[string]interface{}{
-		"message": "User successfully registered",
-		"user": map[string]interface{}{
-			"id":       user.ID,
-			"username": user.Username,
-			"email":    user.Email,
-		},
-	}
-	json.NewEncoder(w).Encode(response)
-}
-
-// validateRefreshToken checks if the refresh token is valid.
-func validateRefreshToken(tokenString string) (*jwt.Token, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Don't forget to validate the signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method in token")
-		}
-		return jwtSecretKey, nil
+	json.NewEncoder(w).Encode(registerResponse{
+		Message: "User successfully registered",
+		User:    registeredAs{ID: user.ID, Username: user.Username, Email: user.Email},
 	})
+}
 
+// validateRefreshToken checks if the refresh token is valid. Signature, issuer,
+// audience and expiry are all checked, and access or MFA tokens are rejected.
+func validateRefreshToken(ctx context.Context, tokenString string) (*tokens.Claims, error) {
+	claims, err := tokens.ParseClaims(tokenString, tokens.TypeRefresh)
 	if err != nil {
-		return nil, err
-	}
-
-	// Check if token is valid and not expired
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		// You can also check for the "exp" claim here manually, but jwt-go does it for you
-		if exp, ok := claims["exp"].(float64); ok {
-			if time.Unix(int64(exp), 0).Before(time.Now()) {
-				return nil, errors.New("token is expired")
-			}
-			return token, nil
-		}
+		logging.FromContext(ctx).Info("Invalid refresh token", "error", err)
+		return nil, errors.New("invalid token")
 	}
-	return nil, errors.New("invalid token")
+	return claims, nil
 }
 
 func (h *AuthenticationHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
-	var tokenDetails models.TokenDetails
-	if err := json.NewDecoder(r.Body).Decode(&tokenDetails); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var req refreshTokenRequest
+	if refreshToken := cookies.Value(r, cookies.RefreshCookieName); refreshToken != "" {
+		// Cookie sessions refresh without a body, so they need the CSRF token instead
+		if !cookies.ValidCSRF(r) {
+			apperrors.Write(w, r, apperrors.Forbidden("Invalid CSRF token").WithCode("invalid_csrf_token"))
+			return
+		}
+		req.RefreshToken = refreshToken
+	} else if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
 		return
 	}
 
 	// Validate the refresh token
-	token, err := validateRefreshToken(tokenDetails.RefreshToken)
+	claims, err := validateRefreshToken(r.Context(), req.RefreshToken)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		apperrors.Write(w, r, apperrors.Unauthorized("Invalid or expired refresh token"))
 		return
 	}
 
-	// Extract user ID or other needed info from token
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		http.Error(w, "Failed to parse token claims", http.StatusInternalServerError)
+	userID, err := claims.UserID()
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid token"))
 		return
 	}
 
-	userID, ok := claims["user_id"].(float64)
-	if !ok {
-		http.Error(w, "Failed to retrieve user ID from token", http.StatusInternalServerError)
+	// Reject refresh tokens issued before the user's tokens were revoked
+	var currentVersion int
+	err = h.DB.QueryRowContext(r.Context(), "SELECT token_version FROM users WHERE id = $1 AND deleted_at IS NULL", userID).Scan(&currentVersion)
+	if err == sql.ErrNoRows || (err == nil && claims.TokenVersion != currentVersion) {
+		apperrors.Write(w, r, apperrors.Unauthorized("token has been revoked").WithCode("token_revoked"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error checking token version", err))
 		return
 	}
 
-	// Generate new tokens
-	accessToken, refreshToken, err := h.GenerateToken(userID)
-	if err != nil {
-		http.Error(w, "Failed to generate tokens", http.StatusInternalServerError)
+	var revoked bool
+	err = h.DB.QueryRowContext(r.Context(), "SELECT revoked_at IS NOT NULL FROM sessions WHERE id = $1 AND user_id = $2",
+		claims.SessionID, userID).Scan(&revoked)
+	if err == sql.ErrNoRows || (err == nil && revoked) {
+		apperrors.Write(w, r, apperrors.Unauthorized("token has been revoked").WithCode("token_revoked"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error loading session", err))
 		return
 	}
 
-	// Return the new tokens
-	json.NewEncoder(w).Encode(models.TokenDetails{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		// Set expiration times as needed
-	})
+	// Only the latest refresh token of a live session can be exchanged
+	accessToken, refreshToken, err := h.rotateTokens(r.Context(), userID, claims.SessionID, claims.ID)
+	if errors.Is(err, errRefreshReplayed) {
+		// An old token from the family was replayed, so it has probably been stolen.
+		// Revoke the whole session rather than guess which holder is legitimate.
+		logger(r).Warn("Refresh token reuse detected, revoking the session", "session_id", claims.SessionID)
+		if _, err := h.DB.ExecContext(r.Context(), "UPDATE sessions SET revoked_at = NOW() WHERE id = $1", claims.SessionID); err != nil {
+			logger(r).Error("Error revoking session", "error", err)
+		}
+		apperrors.Write(w, r, apperrors.Unauthorized("token has been revoked").WithCode("token_revoked"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error generating tokens", err))
+		return
+	}
+
+	if cookies.Enabled {
+		csrfToken, err := cookies.SetTokens(w, accessToken, refreshToken, accessTokenTTL, refreshTokenTTL)
+		if err != nil {
+			apperrors.Write(w, r, apperrors.Internal("Error generating CSRF token", err))
+			return
+		}
+		json.NewEncoder(w).Encode(tokenResponse{CSRFToken: csrfToken})
+		return
+	}
+
+	// Return the new tokens in the same shape as a login
+	json.NewEncoder(w).Encode(tokenResponse{Token: accessToken, RefreshToken: refreshToken})
 }
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"encoding/json"
+	"gocommerce/apiversion"
+	"gocommerce/apperrors"
+	"gocommerce/cookies"
+	"gocommerce/dbtest"
+	"gocommerce/tokens"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// TestRefreshTokenReuse checks each refresh token is exchanged once, and that
+// replaying an old one revokes the whole session
+func TestRefreshTokenReuse(t *testing.T) {
+	db := dbtest.Open(t)
+	t.Setenv("JWT_KEYS_DIR", t.TempDir())
+	if err := tokens.Init(); err != nil {
+		t.Fatal(err)
+	}
+	h := &AuthenticationHandler{DB: db}
+
+	userID := createTestUser(t, db, "refresher", "user", "correct horse battery")
+	login := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", nil)
+	sessionID, err := h.createSession(login, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, first, err := h.GenerateToken(login.Context(), userID, sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refresh := func(t *testing.T, refreshToken string) (*httptest.ResponseRecorder, tokenResponse) {
+		t.Helper()
+		body, _ := json.Marshal(refreshTokenRequest{RefreshToken: refreshToken})
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/refresh", strings.NewReader(string(body)))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.RefreshToken(w, r)
+		var resp tokenResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp
+	}
+	revoked := func(t *testing.T, w *httptest.ResponseRecorder) {
+		t.Helper()
+		var problem apperrors.Problem
+		json.Unmarshal(w.Body.Bytes(), &problem)
+		if w.Code != http.StatusUnauthorized || problem.Code != "token_revoked" {
+			t.Errorf("Refresh answered %d with %s, want 401 token_revoked", w.Code, problem.Code)
+		}
+	}
+
+	w, rotated := refresh(t, first)
+	if w.Code != http.StatusOK || rotated.Token == "" || rotated.RefreshToken == "" || rotated.RefreshToken == first {
+		t.Fatalf("Refresh answered %d: %s", w.Code, w.Body)
+	}
+
+	// The first token was already exchanged, so this is a replay
+	w, _ = refresh(t, first)
+	revoked(t, w)
+
+	var sessionRevoked bool
+	if err := db.QueryRow("SELECT revoked_at IS NOT NULL FROM sessions WHERE id = $1", sessionID).Scan(&sessionRevoked); err != nil {
+		t.Fatal(err)
+	}
+	if !sessionRevoked {
+		t.Error("Replaying a refresh token left the session live")
+	}
+
+	// The legitimate holder is signed out too
+	w, _ = refresh(t, rotated.RefreshToken)
+	revoked(t, w)
+
+	w, _ = refresh(t, "not-a-token")
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("A malformed token answered %d, want 401", w.Code)
+	}
+}
+
+// TestCookieRefreshThroughAlias checks a browser session can still refresh
+// through the unversioned /api/auth alias
+func TestCookieRefreshThroughAlias(t *testing.T) {
+	db := dbtest.Open(t)
+	t.Setenv("JWT_KEYS_DIR", t.TempDir())
+	if err := tokens.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer func(enabled bool) { cookies.Enabled = enabled }(cookies.Enabled)
+	cookies.Enabled = true
+	h := &AuthenticationHandler{DB: db}
+	router := mux.NewRouter()
+	router.HandleFunc("/api/v1/auth/refresh", h.RefreshToken).Methods(http.MethodPost)
+	api := apiversion.Aliases(router)
+
+	// Sign in as the versioned login endpoint would
+	userID := createTestUser(t, db, "browser", RoleCustomer, "correct horse battery")
+	login := httptest.NewRequest(http.MethodPost, "https://shop.test/api/v1/auth/login", nil)
+	sessionID, err := h.createSession(login, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken, refreshToken, err := h.GenerateToken(login.Context(), userID, sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	csrf, err := cookies.SetTokens(w, accessToken, refreshToken, accessTokenTTL, refreshTokenTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jar, _ := cookiejar.New(nil)
+	jar.SetCookies(login.URL, w.Result().Cookies())
+
+	refreshURL, _ := url.Parse("https://shop.test/api/auth/refresh")
+	r := httptest.NewRequest(http.MethodPost, refreshURL.String(), nil)
+	for _, c := range jar.Cookies(refreshURL) {
+		r.AddCookie(c)
+	}
+	r.Header.Set(cookies.CSRFHeader, csrf)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+
+	var resp tokenResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.CSRFToken == "" {
+		t.Fatalf("Refresh through the alias answered %d: %s", w.Code, w.Body)
+	}
+	jar.SetCookies(refreshURL, w.Result().Cookies())
+	for _, path := range []string{"/api/v1/auth/refresh", "/api/auth/refresh"} {
+		var sent string
+		for _, c := range jar.Cookies(&url.URL{Scheme: "https", Host: "shop.test", Path: path}) {
+			if c.Name == cookies.RefreshCookieName {
+				sent = c.Value
+			}
+		}
+		if sent == "" || sent == refreshToken {
+			t.Errorf("The browser sends %s the refresh token %q, want the rotated one", path, sent)
+		}
+	}
+}
+
+// TestConcurrentRefresh checks two requests racing to exchange the same refresh
+// token can't both get a new one
+func TestConcurrentRefresh(t *testing.T) {
+	db := dbtest.Open(t)
+	t.Setenv("JWT_KEYS_DIR", t.TempDir())
+	if err := tokens.Init(); err != nil {
+		t.Fatal(err)
+	}
+	h := &AuthenticationHandler{DB: db}
+
+	userID := createTestUser(t, db, "racer", RoleCustomer, "correct horse battery")
+	login := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", nil)
+	sessionID, err := h.createSession(login, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, refreshToken, err := h.GenerateToken(login.Context(), userID, sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const racers = 8
+	codes := make(chan int, racers)
+	var wg sync.WaitGroup
+	for i := 0; i < racers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/refresh", strings.NewReader(`{"refresh_token":"`+refreshToken+`"}`))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			h.RefreshToken(w, r)
+			codes <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	succeeded := 0
+	for code := range codes {
+		if code == http.StatusOK {
+			succeeded++
+		} else if code != http.StatusUnauthorized {
+			t.Errorf("A racing refresh answered %d", code)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d of %d racing refreshes succeeded, want exactly 1", succeeded, racers)
+	}
+
+	// The losers presented a token that was already exchanged, which is a replay
+	var revoked bool
+	db.QueryRow("SELECT revoked_at IS NOT NULL FROM sessions WHERE id = $1", sessionID).Scan(&revoked)
+	if !revoked {
+		t.Error("The session survived a replayed refresh token")
+	}
+}
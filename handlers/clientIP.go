@@ -0,0 +1,28 @@
+// handlers/clientIP.go
+
+package handlers
+
+import (
+	"gocommerce/config"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trustProxyHeaders enables X-Forwarded-For. Only turn it on behind a proxy
+// that overwrites the header, otherwise clients can pick their own IP.
+var trustProxyHeaders = config.Bool("TRUST_PROXY_HEADERS", false)
+
+// clientIP returns the address of the client making the request.
+func clientIP(r *http.Request) string {
+	if trustProxyHeaders {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
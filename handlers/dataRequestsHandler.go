@@ -0,0 +1,345 @@
+// dataRequestsHandler.go
+
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"gocommerce/apiversion"
+	"gocommerce/apperrors"
+	"gocommerce/constants"
+	"gocommerce/cookies"
+	"gocommerce/models"
+	"gocommerce/privacy"
+	"gocommerce/validation"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// DataRequestHandler handles data export and erasure requests. Users file their own
+// under /api/me; admins see the whole queue and can file erasures on a user's behalf.
+type DataRequestHandler struct {
+	DB *sql.DB
+}
+
+const dataRequestColumns = `id, user_id, request_type, status, requested_by, attempts, last_error,
+	requested_at, started_at, completed_at, expires_at, file_name`
+
+// scanDataRequest reads a row selected with dataRequestColumns.
+func scanDataRequest(row interface{ Scan(...interface{}) error }) (models.DataRequest, error) {
+	var d models.DataRequest
+	var fileName *string
+	err := row.Scan(&d.ID, &d.UserID, &d.Type, &d.Status, &d.RequestedBy, &d.Attempts, &d.LastError,
+		&d.RequestedAt, &d.StartedAt, &d.CompletedAt, &d.ExpiresAt, &fileName)
+	if err == nil && fileName != nil && d.Status == privacy.StatusCompleted {
+		d.DownloadURL = apiversion.V1.Path(fmt.Sprintf("/me/data-requests/%d/download", d.ID))
+	}
+	return d, err
+}
+
+// queryDataRequests runs a query selecting dataRequestColumns and writes the result as JSON.
+func (h *DataRequestHandler) queryDataRequests(w http.ResponseWriter, r *http.Request, query string, args ...interface{}) {
+	rows, err := h.DB.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error fetching data requests", err))
+		return
+	}
+	defer rows.Close()
+
+	requests := []models.DataRequest{}
+	for rows.Next() {
+		d, err := scanDataRequest(rows)
+		if err != nil {
+			apperrors.Write(w, r, apperrors.Internal("Error reading data request", err))
+			return
+		}
+		requests = append(requests, d)
+	}
+	if err := rows.Err(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error iterating data requests", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(requests)
+}
+
+// fileDataRequest queues a request, or returns the one already waiting for the same
+// user and type. The bool reports whether a new request was created.
+func fileDataRequest(q interface {
+	QueryRow(string, ...interface{}) *sql.Row
+}, userID int, requestType string, requestedBy *int) (models.DataRequest, bool, error) {
+	existing, err := scanDataRequest(q.QueryRow(`SELECT `+dataRequestColumns+` FROM data_requests
+		WHERE user_id = $1 AND request_type = $2 AND status IN ($3, $4)`,
+		userID, requestType, privacy.StatusPending, privacy.StatusProcessing))
+	if err == nil {
+		return existing, false, nil
+	} else if err != sql.ErrNoRows {
+		return existing, false, err
+	}
+
+	created, err := scanDataRequest(q.QueryRow(`INSERT INTO data_requests (user_id, request_type, requested_by)
+		VALUES ($1, $2, $3) RETURNING `+dataRequestColumns, userID, requestType, requestedBy))
+	return created, err == nil, err
+}
+
+// writeDataRequest responds with 202 for a newly queued request and 200 for one already in the queue.
+func writeDataRequest(w http.ResponseWriter, d models.DataRequest, created bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if created {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(d)
+}
+
+// RequestExport queues a ZIP export of the current user's data.
+func (h *DataRequestHandler) RequestExport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(constants.UserIDKey).(int)
+	if !ok {
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
+		return
+	}
+
+	d, created, err := fileDataRequest(h.DB, userID, privacy.TypeExport, nil)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error filing export request", err))
+		return
+	}
+
+	writeDataRequest(w, d, created)
+}
+
+// RequestErasure closes the current user's account straight away and queues the
+// erasure of their personal data. The password is required.
+func (h *DataRequestHandler) RequestErasure(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(constants.UserIDKey).(int)
+	if !ok {
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
+		return
+	}
+
+	var req deleteAccountRequest
+	if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	if !checkCurrentPassword(h.DB, w, r, userID, req.Password) {
+		return
+	}
+
+	tx, err := h.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error starting transaction", err))
+		return
+	}
+	defer tx.Rollback()
+
+	// Signs the user out everywhere; the worker then anonymizes the account
+	if err := softDeleteUser(tx, userID); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error deleting user", err))
+		return
+	}
+	d, created, err := fileDataRequest(tx, userID, privacy.TypeErasure, nil)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error filing erasure request", err))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error committing transaction", err))
+		return
+	}
+
+	cookies.Clear(w)
+	writeDataRequest(w, d, created)
+}
+
+// GetMyDataRequests lists the current user's requests.
+func (h *DataRequestHandler) GetMyDataRequests(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(constants.UserIDKey).(int)
+	if !ok {
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
+		return
+	}
+
+	h.queryDataRequests(w, r, `SELECT `+dataRequestColumns+` FROM data_requests WHERE user_id = $1 ORDER BY id DESC`, userID)
+}
+
+// DownloadExport sends a completed export bundle to the user it belongs to.
+func (h *DataRequestHandler) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(constants.UserIDKey).(int)
+	if !ok {
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid request ID"))
+		return
+	}
+
+	// Scoping by user_id means another user's export looks the same as a missing one
+	var fileName string
+	err = h.DB.QueryRowContext(r.Context(), `SELECT file_name FROM data_requests
+		WHERE id = $1 AND user_id = $2 AND request_type = $3 AND status = $4 AND file_name IS NOT NULL`,
+		id, userID, privacy.TypeExport, privacy.StatusCompleted).Scan(&fileName)
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.NotFound("Data request not found"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error fetching export", err))
+		return
+	}
+
+	f, err := os.Open(filepath.Join(privacy.ExportDir, fileName))
+	if os.IsNotExist(err) {
+		apperrors.Write(w, r, apperrors.NotFound("Data request not found"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error opening export", err))
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error reading export", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+fileName+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeContent(w, r, fileName, info.ModTime(), f)
+}
+
+// GetDataRequests lists the request queue, optionally filtered by ?status= and ?type= (admin only).
+func (h *DataRequestHandler) GetDataRequests(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(h.DB, w, r) {
+		return
+	}
+
+	query := `SELECT ` + dataRequestColumns + ` FROM data_requests WHERE TRUE`
+	args := []interface{}{}
+	if status := r.URL.Query().Get("status"); status != "" {
+		args = append(args, status)
+		query += " AND status = $" + strconv.Itoa(len(args))
+	}
+	if requestType := r.URL.Query().Get("type"); requestType != "" {
+		args = append(args, requestType)
+		query += " AND request_type = $" + strconv.Itoa(len(args))
+	}
+	query += " ORDER BY requested_at LIMIT 100"
+
+	h.queryDataRequests(w, r, query, args...)
+}
+
+// GetDataRequest returns a single request (admin only).
+func (h *DataRequestHandler) GetDataRequest(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(h.DB, w, r) {
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid request ID"))
+		return
+	}
+
+	d, err := scanDataRequest(h.DB.QueryRowContext(r.Context(), `SELECT `+dataRequestColumns+` FROM data_requests WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.NotFound("Data request not found"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error fetching data request", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+// RetryDataRequest puts a failed request back in the queue with fresh attempts (admin only).
+func (h *DataRequestHandler) RetryDataRequest(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(h.DB, w, r) {
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid request ID"))
+		return
+	}
+
+	d, err := scanDataRequest(h.DB.QueryRowContext(r.Context(), `UPDATE data_requests SET status = $1, attempts = 0
+		WHERE id = $2 AND status = $3 RETURNING `+dataRequestColumns, privacy.StatusPending, id, privacy.StatusFailed))
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.Conflict("Only failed requests can be retried"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error retrying data request", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+// RequestUserErasure files an erasure on behalf of a user, for requests received
+// outside the app (admin only).
+func (h *DataRequestHandler) RequestUserErasure(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(h.DB, w, r) {
+		return
+	}
+
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid user ID"))
+		return
+	}
+
+	var requestedBy *int
+	if adminID, ok := r.Context().Value(constants.UserIDKey).(int); ok {
+		requestedBy = &adminID
+	}
+
+	tx, err := h.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error starting transaction", err))
+		return
+	}
+	defer tx.Rollback()
+
+	// Already deleted accounts can still be erased
+	err = softDeleteUser(tx, userID)
+	if err == sql.ErrNoRows {
+		var exists bool
+		if err := tx.QueryRowContext(r.Context(), "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", userID).Scan(&exists); err != nil {
+			apperrors.Write(w, r, apperrors.Internal("Error loading user", err))
+			return
+		} else if !exists {
+			apperrors.Write(w, r, apperrors.NotFound("User not found"))
+			return
+		}
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error deleting user", err))
+		return
+	}
+
+	d, created, err := fileDataRequest(tx, userID, privacy.TypeErasure, requestedBy)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error filing erasure request", err))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error committing transaction", err))
+		return
+	}
+
+	writeDataRequest(w, d, created)
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"encoding/json"
+	"gocommerce/apperrors"
+	"gocommerce/dbtest"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRequestUserErasure(t *testing.T) {
+	db := dbtest.Open(t)
+	h := &DataRequestHandler{DB: db}
+	adminID := createTestUser(t, db, "admin", RoleAdmin, "admin-password")
+	userID := createTestUser(t, db, "leaving", RoleCustomer, "user-password")
+
+	erase := func(id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := asUser(adminID, http.MethodPost, "/api/v1/users/"+id+"/erasure", "")
+		h.RequestUserErasure(w, mux.SetURLVars(r, map[string]string{"id": id}))
+		return w
+	}
+
+	w := erase(strconv.Itoa(userID + 100))
+	var problem apperrors.Problem
+	json.Unmarshal(w.Body.Bytes(), &problem)
+	if w.Code != http.StatusNotFound || problem.Detail != "User not found" {
+		t.Errorf("Erasing an unknown user answered %d %q, want 404 User not found", w.Code, problem.Detail)
+	}
+
+	if w := erase(strconv.Itoa(userID)); w.Code != http.StatusAccepted {
+		t.Fatalf("RequestUserErasure answered %d: %s", w.Code, w.Body)
+	}
+	// The account is closed straight away, and erasing it again returns the queued request
+	if w := erase(strconv.Itoa(userID)); w.Code != http.StatusOK {
+		t.Errorf("Erasing a closed account answered %d, want 200: %s", w.Code, w.Body)
+	}
+	var deleted bool
+	var requests int
+	db.QueryRow(`SELECT deleted_at IS NOT NULL FROM users WHERE id = $1`, userID).Scan(&deleted)
+	db.QueryRow(`SELECT COUNT(*) FROM data_requests WHERE user_id = $1 AND request_type = 'erasure'`, userID).Scan(&requests)
+	if !deleted || requests != 1 {
+		t.Errorf("After two erasure requests the user is deleted: %v with %d requests, want true and 1", deleted, requests)
+	}
+}
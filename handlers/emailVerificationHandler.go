@@ -0,0 +1,212 @@
+// emailVerificationHandler.go
+
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"gocommerce/apiversion"
+	"gocommerce/apperrors"
+	"gocommerce/config"
+	"gocommerce/constants"
+	"gocommerce/notifications"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var (
+	// emailVerificationTTL is how long a verification link stays valid
+	emailVerificationTTL = config.Duration("EMAIL_VERIFICATION_TTL", 48*time.Hour)
+	// verificationResendInterval is the minimum time between two verification emails
+	verificationResendInterval = config.Duration("EMAIL_VERIFICATION_RESEND_INTERVAL", time.Minute)
+	// verificationMaxPerDay caps how many verification emails one account can request per day
+	verificationMaxPerDay = config.Int("EMAIL_VERIFICATION_MAX_PER_DAY", 5)
+	// requireVerifiedEmailForCheckout blocks order creation until the email is verified.
+	// Browsing and the cart stay available to unverified accounts.
+	requireVerifiedEmailForCheckout = config.Bool("REQUIRE_VERIFIED_EMAIL_FOR_CHECKOUT", true)
+	// apiBaseURL is the public URL of this API, used to build verification links
+	apiBaseURL = config.String("API_BASE_URL", "http://localhost:8088")
+)
+
+var errEmailTaken = errors.New("email already in use")
+
+// execer is a *sql.DB or a *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// sendVerificationEmail issues a new verification token for the user and emails the link.
+func (h *AuthenticationHandler) sendVerificationEmail(userID int, username, email string) error {
+	token, err := issueVerificationToken(h.DB, userID, email)
+	if err != nil {
+		return err
+	}
+	return sendVerificationLink(h.Notifier, username, email, token)
+}
+
+// issueVerificationToken stores a new token proving whoever follows it controls email.
+func issueVerificationToken(db execer, userID int, email string) (string, error) {
+	token, tokenHash, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.Exec("INSERT INTO email_verification_tokens (user_id, email, token_hash, expires_at) VALUES ($1, $2, $3, $4)",
+		userID, email, tokenHash, time.Now().Add(emailVerificationTTL))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// sendVerificationLink emails the verification link for token to email.
+func sendVerificationLink(notifier *notifications.Notifier, username, email, token string) error {
+	return notifier.Send(notifications.TemplateVerifyEmail, email, map[string]interface{}{
+		"Username":  username,
+		"Link":      apiBaseURL + apiversion.V1.Path("/auth/verify?token=") + url.QueryEscape(token),
+		"ExpiresIn": emailVerificationTTL.String(),
+	})
+}
+
+// requestEmailChange records email as the user's pending address and issues a
+// token to verify it, as part of tx. The account's email only changes, and is
+// marked verified, once the link sent to the new address is followed.
+func requestEmailChange(tx *sql.Tx, userID int, email string) (string, error) {
+	var taken bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(email) = $1 AND id <> $2)", email, userID).Scan(&taken); err != nil {
+		return "", err
+	}
+	if taken {
+		return "", errEmailTaken
+	}
+
+	if _, err := tx.Exec("UPDATE users SET pending_email = $1, updated_at = NOW() WHERE id = $2", email, userID); err != nil {
+		return "", err
+	}
+	return issueVerificationToken(tx, userID, email)
+}
+
+func (h *AuthenticationHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		apperrors.Write(w, r, apperrors.BadRequest("Missing token"))
+		return
+	}
+
+	tx, err := h.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error starting transaction", err))
+		return
+	}
+	defer tx.Rollback()
+
+	// The token only counts if it was sent to the address the account still has,
+	// or to the new address it is changing to. Addresses stored before they were
+	// normalised may differ in case.
+	var tokenID, userID int
+	var email string
+	err = tx.QueryRowContext(r.Context(), `SELECT t.id, t.user_id, t.email FROM email_verification_tokens t
+		JOIN users u ON u.id = t.user_id AND LOWER(t.email) IN (LOWER(u.email), LOWER(u.pending_email)) AND u.deleted_at IS NULL
+		WHERE t.token_hash = $1 AND t.used_at IS NULL AND t.expires_at > NOW() FOR UPDATE OF t`, hashToken(token)).Scan(&tokenID, &userID, &email)
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid or expired verification token"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error looking up verification token", err))
+		return
+	}
+
+	if _, err := tx.ExecContext(r.Context(), "UPDATE email_verification_tokens SET used_at = NOW() WHERE user_id = $1 AND LOWER(email) = LOWER($2) AND used_at IS NULL", userID, email); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error consuming verification token", err))
+		return
+	}
+
+	// Verifying a pending address makes it the account's email
+	_, err = tx.ExecContext(r.Context(), `UPDATE users SET email = $2, email_verified_at = NOW(), updated_at = NOW(),
+		pending_email = CASE WHEN LOWER(pending_email) = LOWER($2) THEN NULL ELSE pending_email END
+		WHERE id = $1`, userID, email)
+	if isUniqueViolation(err) {
+		apperrors.Write(w, r, apperrors.Conflict("Email address is already in use by another account"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error marking email verified", err))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error committing transaction", err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email address verified"})
+}
+
+func (h *AuthenticationHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(constants.UserIDKey).(int)
+	if !ok {
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
+		return
+	}
+
+	var username, email string
+	var pendingEmail *string
+	var verifiedAt *time.Time
+	err := h.DB.QueryRowContext(r.Context(), "SELECT username, email, pending_email, email_verified_at FROM users WHERE id = $1 AND deleted_at IS NULL", userID).
+		Scan(&username, &email, &pendingEmail, &verifiedAt)
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error loading user", err))
+		return
+	}
+
+	// An email change waiting to be confirmed gets its link again, whether or not
+	// the current address is verified
+	if pendingEmail != nil {
+		email = *pendingEmail
+	} else if verifiedAt != nil {
+		apperrors.Write(w, r, apperrors.Conflict("Email address is already verified"))
+		return
+	}
+
+	// Throttle: one email per interval and a daily cap
+	var sentToday int
+	var lastSent *time.Time
+	err = h.DB.QueryRowContext(r.Context(), `SELECT COUNT(*), MAX(created_at) FROM email_verification_tokens
+		WHERE user_id = $1 AND created_at > NOW() - INTERVAL '1 day'`, userID).Scan(&sentToday, &lastSent)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error checking verification throttle", err))
+		return
+	}
+	if lastSent != nil {
+		if wait := time.Until(lastSent.Add(verificationResendInterval)); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			apperrors.Write(w, r, apperrors.TooManyRequests("Please wait before requesting another verification email"))
+			return
+		}
+	}
+	if sentToday >= verificationMaxPerDay {
+		w.Header().Set("Retry-After", strconv.Itoa(int((24 * time.Hour).Seconds())))
+		apperrors.Write(w, r, apperrors.TooManyRequests("Too many verification emails requested today"))
+		return
+	}
+
+	if err := h.sendVerificationEmail(userID, username, email); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error sending verification email", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Verification email sent"})
+}
+
+// emailVerified reports whether the user has confirmed their email address.
+func emailVerified(db *sql.DB, userID int) (bool, error) {
+	var verified bool
+	err := db.QueryRow("SELECT email_verified_at IS NOT NULL FROM users WHERE id = $1", userID).Scan(&verified)
+	return verified, err
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"gocommerce/dbtest"
+	"gocommerce/notifications"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func verifyRequest(token string) *http.Request {
+	return httptest.NewRequest(http.MethodGet, "/api/v1/auth/verify?token="+url.QueryEscape(token), nil)
+}
+
+// TestVerifyEmailIgnoresCase checks an address stored before emails were
+// normalised still verifies with a token sent to its lower-case form
+func TestVerifyEmailIgnoresCase(t *testing.T) {
+	db := dbtest.Open(t)
+	h := &AuthenticationHandler{DB: db}
+	var userID int
+	if err := db.QueryRow(`INSERT INTO users (username, email, password_hash) VALUES ('ada', 'Ada@Example.COM', 'x') RETURNING id`).Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	token, err := issueVerificationToken(db, userID, "ada@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	h.VerifyEmail(w, verifyRequest(token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("VerifyEmail answered %d: %s", w.Code, w.Body)
+	}
+	if got := loadAccount(t, db, userID); !got.Verified {
+		t.Errorf("Account after verifying = %+v, want it verified", got)
+	}
+
+	// The token is used up
+	w = httptest.NewRecorder()
+	h.VerifyEmail(w, verifyRequest(token))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Reusing the token answered %d, want 400", w.Code)
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	db := dbtest.Open(t)
+	mailer := &notifications.MemoryMailer{}
+	queue := notifications.NewQueue(mailer, 10)
+	h := &AuthenticationHandler{DB: db, Notifier: notifications.NewNotifier(db, queue)}
+	userID := createTestUser(t, db, "grace", RoleCustomer, "user-password")
+
+	resend := func() int {
+		w := httptest.NewRecorder()
+		h.ResendVerification(w, asUser(userID, http.MethodPost, "/api/v1/auth/verify/resend", ""))
+		return w.Code
+	}
+
+	if code := resend(); code != http.StatusConflict {
+		t.Errorf("Resending for a verified address answered %d, want 409", code)
+	}
+
+	// A pending change is resent to the new address even though the old one is verified
+	if _, err := db.Exec(`UPDATE users SET pending_email = 'grace@new.example' WHERE id = $1`, userID); err != nil {
+		t.Fatal(err)
+	}
+	if code := resend(); code != http.StatusAccepted {
+		t.Fatalf("Resending for a pending change answered %d, want 202", code)
+	}
+	var tokens int
+	db.QueryRow(`SELECT COUNT(*) FROM email_verification_tokens WHERE user_id = $1 AND email = 'grace@new.example'`, userID).Scan(&tokens)
+	if tokens != 1 {
+		t.Errorf("%d tokens issued for the pending address, want 1", tokens)
+	}
+	if code := resend(); code != http.StatusTooManyRequests {
+		t.Errorf("Resending straight away answered %d, want 429", code)
+	}
+}
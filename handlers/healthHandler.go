@@ -0,0 +1,34 @@
+// healthHandler.go
+
+package handlers
+
+import (
+	"encoding/json"
+	"gocommerce/health"
+	"net/http"
+)
+
+// HealthHandler serves the liveness and readiness probes. Both are public.
+type HealthHandler struct {
+	Checker *health.Checker
+}
+
+// Healthz reports that the process is up and serving requests.
+func (h *HealthHandler) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"status": health.StatusOK})
+}
+
+// Readyz runs the dependency checks and responds 503 if any of them fail, so the
+// instance is taken out of rotation until it recovers.
+func (h *HealthHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+	report := h.Checker.Run(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != health.StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gocommerce/health"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadyz(t *testing.T) {
+	database := health.Check{Name: "database", Run: func(context.Context) (map[string]interface{}, error) { return nil, nil }}
+	outbox := health.Check{Name: "outbox", Run: func(context.Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"pending": 12}, errors.New("outbox is 5m0s behind")
+	}}
+
+	tests := []struct {
+		name       string
+		checks     []health.Check
+		wantStatus int
+		wantReport string
+	}{
+		{"ready", []health.Check{database}, http.StatusOK, health.StatusOK},
+		{"a check fails", []health.Check{database, outbox}, http.StatusServiceUnavailable, health.StatusFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &HealthHandler{Checker: &health.Checker{Checks: tt.checks, Timeout: time.Second}}
+			w := httptest.NewRecorder()
+			h.Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if w.Code != tt.wantStatus || w.Header().Get("Cache-Control") != "no-store" {
+				t.Errorf("Answered %d with Cache-Control %q, want %d with no-store", w.Code, w.Header().Get("Cache-Control"), tt.wantStatus)
+			}
+			var report health.Report
+			if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Status != tt.wantReport || len(report.Checks) != len(tt.checks) {
+				t.Errorf("Report = %+v, want %s", report, tt.wantReport)
+			}
+		})
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	w := httptest.NewRecorder()
+	(&HealthHandler{}).Healthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("Answered %d with Cache-Control %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+}
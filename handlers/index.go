@@ -4,6 +4,13 @@ package handlers
 
 import (
 	"database/sql"
+	"gocommerce/apiversion"
+	"gocommerce/health"
+	"gocommerce/logging"
+	"gocommerce/notifications"
+	"gocommerce/oidclogin"
+	"log/slog"
+	"net/http"
 )
 
 type Handlers struct {
@@ -12,15 +19,33 @@ type Handlers struct {
 	OrderHandler          OrderHandler
 	ShoppingCartHandler   ShoppingCartHandler
 	AuthenticationHandler AuthenticationHandler
+	WebhookHandler        WebhookHandler
+	APIKeyHandler         APIKeyHandler
+	DataRequestHandler    DataRequestHandler
+	HealthHandler         HealthHandler
 	// Add other handlers as needed
 }
 
-func NewHandlers(db *sql.DB) *Handlers {
+func NewHandlers(db *sql.DB, notifier *notifications.Notifier, workers *health.Workers) *Handlers {
 	return &Handlers{
-		ProductHandler:        ProductHandler{DB: db},
-		UserHandler:           UserHandler{DB: db},
-		OrderHandler:          OrderHandler{DB: db},
-		ShoppingCartHandler:   ShoppingCartHandler{DB: db},
-		AuthenticationHandler: AuthenticationHandler{DB: db},
+		ProductHandler:      ProductHandler{DB: db},
+		UserHandler:         UserHandler{DB: db, Notifier: notifier},
+		OrderHandler:        OrderHandler{DB: db},
+		ShoppingCartHandler: ShoppingCartHandler{DB: db},
+		AuthenticationHandler: AuthenticationHandler{
+			DB:            db,
+			Notifier:      notifier,
+			OIDCProviders: oidclogin.LoadProviders(apiBaseURL + apiversion.V1.Path("/auth/oidc")),
+		},
+		WebhookHandler:     WebhookHandler{DB: db},
+		APIKeyHandler:      APIKeyHandler{DB: db},
+		DataRequestHandler: DataRequestHandler{DB: db},
+		HealthHandler:      HealthHandler{Checker: health.NewChecker(db, workers)},
 	}
 }
+
+// logger returns the logger for a request, which tags each line with the request ID
+// and, once authenticated, the user.
+func logger(r *http.Request) *slog.Logger {
+	return logging.FromContext(r.Context())
+}
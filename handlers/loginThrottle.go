@@ -0,0 +1,143 @@
+// handlers/loginThrottle.go
+
+package handlers
+
+import (
+	"database/sql"
+	"gocommerce/config"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Login throttle scopes
+const (
+	throttleAccount = "account"
+	throttleIP      = "ip"
+	// Password reset requests, counted per address and per client IP
+	throttleResetEmail = "reset_email"
+	throttleResetIP    = "reset_ip"
+)
+
+var (
+	loginMaxAccountFailures = config.Int("LOGIN_MAX_ACCOUNT_FAILURES", 5)
+	loginMaxIPFailures      = config.Int("LOGIN_MAX_IP_FAILURES", 20)
+	// loginFailureWindow is how long a failure counts towards a lockout
+	loginFailureWindow = config.Duration("LOGIN_FAILURE_WINDOW", 15*time.Minute)
+	// loginLockoutDuration is how long a locked account or IP has to wait; it unlocks by itself afterwards
+	loginLockoutDuration = config.Duration("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+
+	passwordResetMaxPerEmail = config.Int("PASSWORD_RESET_MAX_PER_EMAIL", 3)
+	passwordResetMaxPerIP    = config.Int("PASSWORD_RESET_MAX_PER_IP", 10)
+)
+
+// loginThrottle counts login attempts per account and per client IP. Attempts
+// are counted before the password is checked, so concurrent guesses can't all
+// slip in under the limit; successful ones are taken off the count again.
+type loginThrottle struct {
+	DB *sql.DB
+}
+
+func accountKey(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// attempt counts a login attempt against both the account and the IP. It
+// returns the account's attempt count, or how long the caller has to wait if
+// either is locked, in which case the password must not be checked. The account
+// key is the submitted username, so nonexistent users lock the same way.
+func (t loginThrottle) attempt(username, ip string) (int, time.Duration, error) {
+	attempts, accountLock, err := t.increment(throttleAccount, accountKey(username), loginMaxAccountFailures)
+	if err != nil || accountLock > 0 {
+		return 0, accountLock, err
+	}
+	_, ipLock, err := t.increment(throttleIP, ip, loginMaxIPFailures)
+	if err != nil {
+		return 0, 0, err
+	}
+	return attempts, ipLock, nil
+}
+
+// resetRequest counts a password reset request against the address and the IP,
+// returning how long the caller has to wait if either has made too many.
+func (t loginThrottle) resetRequest(email, ip string) (time.Duration, error) {
+	_, lockedFor, err := t.increment(throttleResetEmail, email, passwordResetMaxPerEmail)
+	if err != nil || lockedFor > 0 {
+		return lockedFor, err
+	}
+	_, lockedFor, err = t.increment(throttleResetIP, ip, passwordResetMaxPerIP)
+	return lockedFor, err
+}
+
+// failed slows down the next attempt on the account after repeated failures.
+func (t loginThrottle) failed(username string, attempts int) error {
+	delay := failureDelay(attempts)
+	if delay == 0 {
+		return nil
+	}
+	_, err := t.DB.Exec(`UPDATE login_throttles SET locked_until = GREATEST(locked_until, $1) WHERE scope = $2 AND key = $3`,
+		time.Now().Add(delay), throttleAccount, accountKey(username))
+	return err
+}
+
+// increment atomically counts an attempt against scope and key. Once more than
+// max attempts fall within loginFailureWindow the key is locked for
+// loginLockoutDuration. It returns the attempt count, or how long the key
+// remains locked, without counting the attempt, if it is.
+func (t loginThrottle) increment(scope, key string, max int) (int, time.Duration, error) {
+	// Attempts older than the window no longer count
+	const (
+		locked = `login_throttles.locked_until > NOW()`
+		next   = `CASE WHEN login_throttles.last_failed_at < $3 THEN 1 ELSE login_throttles.failures + 1 END`
+	)
+	var attempts int
+	var lockedUntil *time.Time
+	err := t.DB.QueryRow(`INSERT INTO login_throttles (scope, key, failures, last_failed_at) VALUES ($1, $2, 1, NOW())
+		ON CONFLICT (scope, key) DO UPDATE SET
+			failures = CASE WHEN `+locked+` THEN login_throttles.failures WHEN `+next+` > $4 THEN 0 ELSE `+next+` END,
+			last_failed_at = CASE WHEN `+locked+` THEN login_throttles.last_failed_at ELSE NOW() END,
+			locked_until = CASE WHEN `+locked+` THEN login_throttles.locked_until WHEN `+next+` > $4 THEN $5 ELSE NULL END
+		RETURNING failures, locked_until`,
+		scope, key, time.Now().Add(-loginFailureWindow), max, time.Now().Add(loginLockoutDuration)).Scan(&attempts, &lockedUntil)
+	if err != nil {
+		return 0, 0, err
+	}
+	if lockedUntil != nil && time.Until(*lockedUntil) > 0 {
+		return attempts, time.Until(*lockedUntil), nil
+	}
+	return attempts, 0, nil
+}
+
+// succeeded clears the account counter after a successful login and takes the
+// attempt off the IP count. The IP counter is otherwise left to expire so an
+// attacker can't clear it by logging into their own account.
+func (t loginThrottle) succeeded(username, ip string) error {
+	if _, err := t.DB.Exec(`DELETE FROM login_throttles WHERE scope = $1 AND key = $2`, throttleAccount, accountKey(username)); err != nil {
+		return err
+	}
+	_, err := t.DB.Exec(`UPDATE login_throttles SET failures = GREATEST(failures - 1, 0) WHERE scope = $1 AND key = $2`, throttleIP, ip)
+	return err
+}
+
+// retryAfter sets the Retry-After header for a locked account or IP
+func retryAfter(w http.ResponseWriter, lockedFor time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(lockedFor.Seconds())+1))
+}
+
+// failureDelay is how long the next attempt on an account is refused after a
+// failure: nothing for the first two, then doubling from half a second up to
+// eight seconds.
+func failureDelay(failures int) time.Duration {
+	if failures <= 2 {
+		return 0
+	}
+	delay := 500 * time.Millisecond
+	for i := 3; i < failures; i++ {
+		delay *= 2
+		if delay >= 8*time.Second {
+			return 8 * time.Second
+		}
+	}
+	return delay
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"gocommerce/dbtest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFailureDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, 500 * time.Millisecond},
+		{4, time.Second},
+		{6, 4 * time.Second},
+		{7, 8 * time.Second},
+		{50, 8 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := failureDelay(tt.failures); got != tt.want {
+			t.Errorf("failureDelay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	db := dbtest.Open(t)
+	throttle := loginThrottle{DB: db}
+
+	t.Run("concurrent attempts can't exceed the limit", func(t *testing.T) {
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		allowed := 0
+		for i := 0; i < 3*loginMaxAccountFailures; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, lockedFor, err := throttle.attempt("Racer", "192.0.2.1")
+				if err != nil {
+					t.Error(err)
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if lockedFor == 0 {
+					allowed++
+				}
+			}()
+		}
+		wg.Wait()
+		if allowed != loginMaxAccountFailures {
+			t.Errorf("%d concurrent attempts were allowed, want %d", allowed, loginMaxAccountFailures)
+		}
+		if _, lockedFor, _ := throttle.attempt("racer", "192.0.2.99"); lockedFor < loginLockoutDuration-time.Minute {
+			t.Errorf("Account is locked for %s, want the lockout duration", lockedFor)
+		}
+	})
+
+	t.Run("a successful login clears the account", func(t *testing.T) {
+		for i := 1; i < loginMaxAccountFailures; i++ {
+			throttle.attempt("forgetful", "192.0.2.2")
+		}
+		if err := throttle.succeeded("forgetful", "192.0.2.2"); err != nil {
+			t.Fatal(err)
+		}
+		attempts, lockedFor, err := throttle.attempt("forgetful", "192.0.2.2")
+		if err != nil || attempts != 1 || lockedFor != 0 {
+			t.Errorf("attempt() after a success = %d, %s, %v, want a fresh count", attempts, lockedFor, err)
+		}
+	})
+
+	t.Run("repeated failures are answered with Retry-After", func(t *testing.T) {
+		h := &AuthenticationHandler{DB: db}
+		login := func() *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(`{"username":"guesser","password":"wrong-password"}`))
+			r.Header.Set("Content-Type", "application/json")
+			h.Login(w, r)
+			return w
+		}
+
+		for i := 1; i <= 2; i++ {
+			if w := login(); w.Code != http.StatusUnauthorized {
+				t.Fatalf("Attempt %d answered %d, want 401", i, w.Code)
+			}
+		}
+		// The third failure refuses the next attempt for a moment instead of stalling the response
+		if w := login(); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Third attempt answered %d, want 401", w.Code)
+		}
+		w := login()
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+			t.Errorf("Attempt during the delay answered %d with Retry-After %q, want 429 and 1", w.Code, w.Header().Get("Retry-After"))
+		}
+	})
+}
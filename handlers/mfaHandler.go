@@ -0,0 +1,511 @@
+// mfaHandler.go
+
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gocommerce/apperrors"
+	"gocommerce/config"
+	"gocommerce/constants"
+	"gocommerce/metrics"
+	"gocommerce/tokens"
+	"gocommerce/totp"
+	"gocommerce/validation"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// throttleMFA counts wrong MFA codes per user
+const throttleMFA = "mfa"
+
+const recoveryCodeCount = 10
+
+var (
+	mfaTokenTTL        = config.Duration("MFA_TOKEN_TTL", 5*time.Minute)
+	mfaIssuer          = config.String("MFA_ISSUER", "gocommerce")
+	mfaMaxFailures     = config.Int("MFA_MAX_FAILURES", 5)
+	mfaRequiredByRoles = strings.Split(config.String("MFA_REQUIRED_ROLES", RoleStaff+","+RoleAdmin), ",")
+)
+
+var errInvalidMFACode = errors.New("invalid authentication code")
+
+// mfaRequiredForRole reports whether the MFA policy applies to role.
+func mfaRequiredForRole(role string) bool {
+	for _, r := range mfaRequiredByRoles {
+		if strings.TrimSpace(r) == role {
+			return true
+		}
+	}
+	return false
+}
+
+func issueMFAToken(userID int, tokenType string) (string, error) {
+	claims, err := tokens.NewClaims(userID, tokenType, mfaTokenTTL)
+	if err != nil {
+		return "", err
+	}
+	return tokens.Sign(claims)
+}
+
+// parseMFAToken validates a pending MFA token of the given type and returns its user ID.
+func parseMFAToken(tokenString, tokenType string) (int, error) {
+	claims, err := tokens.ParseClaims(tokenString, tokenType)
+	if err != nil {
+		return 0, err
+	}
+	return claims.UserID()
+}
+
+// requireSecondFactor is called after a correct password. If the account has
+// MFA, or its role requires MFA, it writes a pending token response and returns true.
+func (h *AuthenticationHandler) requireSecondFactor(w http.ResponseWriter, r *http.Request, userID int) bool {
+	challenge, err := h.secondFactorChallenge(r, userID)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return true
+	}
+	if challenge == nil {
+		return false
+	}
+
+	json.NewEncoder(w).Encode(challenge)
+	return true
+}
+
+// secondFactorChallenge returns the challenge a login by userID has to answer
+// before it gets tokens, or nil if the account doesn't need a second factor.
+func (h *AuthenticationHandler) secondFactorChallenge(r *http.Request, userID int) (*mfaChallenge, error) {
+	var role string
+	var mfaEnabled bool
+	err := h.DB.QueryRowContext(r.Context(), "SELECT role, mfa_enabled_at IS NOT NULL FROM users WHERE id = $1", userID).Scan(&role, &mfaEnabled)
+	if err != nil {
+		return nil, apperrors.Internal("Error loading MFA status", err)
+	}
+
+	switch {
+	case mfaEnabled:
+		token, err := issueMFAToken(userID, tokens.TypeMFAPending)
+		if err != nil {
+			return nil, apperrors.Internal("Error generating MFA token", err)
+		}
+		return &mfaChallenge{MFARequired: true, MFAToken: token}, nil
+	case mfaRequiredForRole(role):
+		token, err := issueMFAToken(userID, tokens.TypeMFAEnroll)
+		if err != nil {
+			return nil, apperrors.Internal("Error generating MFA token", err)
+		}
+		return &mfaChallenge{MFAEnrollmentRequired: true, MFAToken: token}, nil
+	}
+	return nil, nil
+}
+
+// mfaChallenge is returned instead of tokens when a login needs a second factor.
+// The MFA token is exchanged at /api/v1/auth/mfa/verify, or /api/v1/auth/mfa/enroll when
+// the user has to set MFA up first.
+type mfaChallenge struct {
+	MFARequired           bool   `json:"mfa_required,omitempty"`
+	MFAEnrollmentRequired bool   `json:"mfa_enrollment_required,omitempty"`
+	MFAToken              string `json:"mfa_token"`
+}
+
+// mfaEnrollment is the secret for an authenticator app, also as an otpauth:// URI for QR codes
+type mfaEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// mfaRequest is the body of every MFA endpoint. Each uses the fields it needs.
+type mfaRequest struct {
+	MFAToken     string `json:"mfa_token" validate:"max=4096"`
+	Code         string `json:"code" validate:"max=10"`
+	RecoveryCode string `json:"recovery_code" validate:"max=20"`
+}
+
+// VerifyMFA completes a login by exchanging a pending MFA token and a TOTP or
+// recovery code for real tokens.
+func (h *AuthenticationHandler) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var req mfaRequest
+	if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	userID, err := parseMFAToken(req.MFAToken, tokens.TypeMFAPending)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("Invalid or expired MFA token"))
+		return
+	}
+
+	if !h.checkMFAThrottle(w, r, userID) {
+		return
+	}
+
+	if req.RecoveryCode != "" {
+		err = h.useRecoveryCode(r.Context(), userID, req.RecoveryCode)
+	} else {
+		err = h.verifyTOTP(r.Context(), userID, req.Code)
+	}
+	if !h.handleMFAResult(w, r, userID, err) {
+		return
+	}
+
+	h.respondWithTokens(w, r, userID, nil)
+}
+
+// EnrollMFAForLogin starts enrollment for a user whose role requires MFA, using the
+// enrollment token returned by Login.
+func (h *AuthenticationHandler) EnrollMFAForLogin(w http.ResponseWriter, r *http.Request) {
+	var req mfaRequest
+	if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	userID, err := parseMFAToken(req.MFAToken, tokens.TypeMFAEnroll)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("Invalid or expired MFA token"))
+		return
+	}
+
+	h.startMFAEnrollment(w, r, userID)
+}
+
+// ConfirmMFAForLogin finishes enrollment started with EnrollMFAForLogin and
+// returns the recovery codes together with real tokens.
+func (h *AuthenticationHandler) ConfirmMFAForLogin(w http.ResponseWriter, r *http.Request) {
+	var req mfaRequest
+	if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	userID, err := parseMFAToken(req.MFAToken, tokens.TypeMFAEnroll)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("Invalid or expired MFA token"))
+		return
+	}
+
+	if !h.checkMFAThrottle(w, r, userID) {
+		return
+	}
+
+	codes, err := h.confirmMFAEnrollment(r.Context(), userID, req.Code)
+	if !h.handleMFAResult(w, r, userID, err) {
+		return
+	}
+
+	h.respondWithTokens(w, r, userID, codes)
+}
+
+// EnrollMFA starts TOTP enrollment for the current user.
+func (h *AuthenticationHandler) EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(constants.UserIDKey).(int)
+	if !ok {
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
+		return
+	}
+
+	h.startMFAEnrollment(w, r, userID)
+}
+
+// ConfirmMFA enables MFA for the current user once they prove their app
+// produces valid codes, and returns their recovery codes.
+func (h *AuthenticationHandler) ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(constants.UserIDKey).(int)
+	if !ok {
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
+		return
+	}
+
+	var req mfaRequest
+	if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	if !h.checkMFAThrottle(w, r, userID) {
+		return
+	}
+
+	codes, err := h.confirmMFAEnrollment(r.Context(), userID, req.Code)
+	if !h.handleMFAResult(w, r, userID, err) {
+		return
+	}
+
+	json.NewEncoder(w).Encode(recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableMFA turns MFA off for the current user. Roles covered by the MFA policy can't opt out.
+func (h *AuthenticationHandler) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(constants.UserIDKey).(int)
+	if !ok {
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
+		return
+	}
+
+	var req mfaRequest
+	if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	role, err := userRole(h.DB, r)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error loading role", err))
+		return
+	}
+	if mfaRequiredForRole(role) {
+		apperrors.Write(w, r, apperrors.Forbidden("MFA is required for your role").WithCode("mfa_required"))
+		return
+	}
+
+	if !h.checkMFAThrottle(w, r, userID) {
+		return
+	}
+	if !h.handleMFAResult(w, r, userID, h.verifyTOTP(r.Context(), userID, req.Code)) {
+		return
+	}
+
+	tx, err := h.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error starting transaction", err))
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(r.Context(), "UPDATE users SET totp_secret = NULL, mfa_enabled_at = NULL, totp_last_step = NULL WHERE id = $1", userID); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error disabling MFA", err))
+		return
+	}
+	if _, err := tx.ExecContext(r.Context(), "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error deleting recovery codes", err))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error committing transaction", err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "MFA disabled"})
+}
+
+// RegenerateRecoveryCodes replaces the current user's recovery codes.
+func (h *AuthenticationHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(constants.UserIDKey).(int)
+	if !ok {
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
+		return
+	}
+
+	var req mfaRequest
+	if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	if !h.checkMFAThrottle(w, r, userID) {
+		return
+	}
+	if !h.handleMFAResult(w, r, userID, h.verifyTOTP(r.Context(), userID, req.Code)) {
+		return
+	}
+
+	tx, err := h.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error starting transaction", err))
+		return
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(r.Context(), tx, userID)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error generating recovery codes", err))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error committing transaction", err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// startMFAEnrollment generates a new secret and returns it with its provisioning URI.
+func (h *AuthenticationHandler) startMFAEnrollment(w http.ResponseWriter, r *http.Request, userID int) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error generating TOTP secret", err))
+		return
+	}
+
+	var email string
+	err = h.DB.QueryRowContext(r.Context(), `UPDATE users SET totp_secret = $1 WHERE id = $2 AND mfa_enabled_at IS NULL AND deleted_at IS NULL
+		RETURNING email`, secret, userID).Scan(&email)
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.Conflict("MFA is already enabled"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error saving TOTP secret", err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(mfaEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(mfaIssuer, email, secret),
+	})
+}
+
+// confirmMFAEnrollment enables MFA if code matches the pending secret and returns new recovery codes.
+func (h *AuthenticationHandler) confirmMFAEnrollment(ctx context.Context, userID int, code string) ([]string, error) {
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var secret *string
+	var enabled bool
+	err = tx.QueryRowContext(ctx, "SELECT totp_secret, mfa_enabled_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&secret, &enabled)
+	if err != nil {
+		return nil, err
+	}
+	if enabled || secret == nil {
+		return nil, errInvalidMFACode
+	}
+
+	step, ok := totp.Validate(*secret, code, time.Now())
+	if !ok {
+		return nil, errInvalidMFACode
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET mfa_enabled_at = NOW(), totp_last_step = $1 WHERE id = $2", step, userID); err != nil {
+		return nil, err
+	}
+
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// verifyTOTP checks a code for a user with MFA enabled. A code is accepted once:
+// its time step must be later than the last accepted one.
+func (h *AuthenticationHandler) verifyTOTP(ctx context.Context, userID int, code string) error {
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var secret *string
+	var lastStep *int64
+	err = tx.QueryRowContext(ctx, "SELECT totp_secret, totp_last_step FROM users WHERE id = $1 AND mfa_enabled_at IS NOT NULL FOR UPDATE", userID).
+		Scan(&secret, &lastStep)
+	if err == sql.ErrNoRows || (err == nil && secret == nil) {
+		return errInvalidMFACode
+	} else if err != nil {
+		return err
+	}
+
+	step, ok := totp.Validate(*secret, code, time.Now())
+	if !ok || (lastStep != nil && step <= *lastStep) {
+		return errInvalidMFACode
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET totp_last_step = $1 WHERE id = $2", step, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// useRecoveryCode consumes one of the user's unused recovery codes.
+func (h *AuthenticationHandler) useRecoveryCode(ctx context.Context, userID int, code string) error {
+	result, err := h.DB.ExecContext(ctx, `UPDATE mfa_recovery_codes SET used_at = NOW()
+		WHERE id = (SELECT id FROM mfa_recovery_codes WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL LIMIT 1)`,
+		userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errInvalidMFACode
+	}
+	return nil
+}
+
+// checkMFAThrottle counts an attempt at a code before it is checked. It writes a
+// 429 response and returns false if the user has entered too many wrong codes recently.
+func (h *AuthenticationHandler) checkMFAThrottle(w http.ResponseWriter, r *http.Request, userID int) bool {
+	_, lockedFor, err := loginThrottle{DB: h.DB}.increment(throttleMFA, strconv.Itoa(userID), mfaMaxFailures)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error checking MFA throttle", err))
+		return false
+	}
+	if lockedFor == 0 {
+		return true
+	}
+
+	retryAfter(w, lockedFor)
+	apperrors.Write(w, r, apperrors.TooManyRequests("Too many invalid codes, try again later"))
+	return false
+}
+
+// handleMFAResult writes the response for a failed code check and returns
+// whether the request may continue. The attempt was already counted by
+// checkMFAThrottle; a valid code clears the count.
+func (h *AuthenticationHandler) handleMFAResult(w http.ResponseWriter, r *http.Request, userID int, err error) bool {
+	if err == errInvalidMFACode {
+		metrics.LoginFailures.WithLabelValues(metrics.LoginInvalidMFACode).Inc()
+		apperrors.Write(w, r, apperrors.Unauthorized("Invalid authentication code").WithCode("invalid_mfa_code"))
+		return false
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error verifying MFA code", err))
+		return false
+	}
+
+	if _, err := h.DB.ExecContext(r.Context(), "DELETE FROM login_throttles WHERE scope = $1 AND key = $2", throttleMFA, strconv.Itoa(userID)); err != nil {
+		logger(r).Error("Error resetting MFA throttle", "error", err)
+	}
+	return true
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and stores a new set,
+// returning the plain codes to show once.
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+		codes[i] = fmt.Sprintf("%s-%s", raw[:5], raw[5:])
+
+		if _, err := tx.ExecContext(ctx, "INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+			userID, hashToken(normalizeRecoveryCode(codes[i]))); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode makes recovery codes case and dash insensitive.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
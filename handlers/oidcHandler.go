@@ -0,0 +1,311 @@
+// oidcHandler.go
+
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"gocommerce/apperrors"
+	"gocommerce/config"
+	"gocommerce/cookies"
+	"gocommerce/events"
+	"gocommerce/oidclogin"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// oidcStateCookie binds a login to the browser that started it, which stops an
+	// attacker from completing their own login in someone else's browser
+	oidcStateCookie = "oidc_state"
+)
+
+// oidcCookiePaths scope the state cookie to the OIDC endpoints, under both the
+// versioned paths and the unversioned aliases kept for the transition
+var oidcCookiePaths = []string{"/api/v1/auth/oidc", "/api/auth/oidc"}
+
+var (
+	// oidcLoginTTL is how long a user has to finish signing in at the provider
+	oidcLoginTTL = config.Duration("OIDC_LOGIN_TTL", 10*time.Minute)
+	// oidcReturnURL is the front end page a finished login is sent back to
+	oidcReturnURL = config.String("OIDC_RETURN_URL", config.String("APP_BASE_URL", "http://localhost:3000")+"/login/callback")
+)
+
+var (
+	errUnverifiedEmail   = errors.New("the provider has not verified this email address")
+	errUnverifiedAccount = errors.New("the account with this email address has not verified it")
+)
+
+// OIDCLogin starts an authorization code login by redirecting to the provider.
+func (h *AuthenticationHandler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.OIDCProviders[mux.Vars(r)["provider"]]
+	if !ok {
+		apperrors.Write(w, r, apperrors.NotFound("Unknown login provider"))
+		return
+	}
+
+	state, stateHash, err := generateOpaqueToken()
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error generating OIDC state", err))
+		return
+	}
+	nonceBytes := make([]byte, 16)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error generating OIDC nonce", err))
+		return
+	}
+	nonce := hex.EncodeToString(nonceBytes)
+	codeVerifier := oidclogin.GenerateVerifier()
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, codeVerifier)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.BadGateway("Login provider is unavailable", "Error starting OIDC login", err))
+		return
+	}
+
+	// Clear out abandoned logins while we're here
+	if _, err := h.DB.ExecContext(r.Context(), "DELETE FROM oidc_login_states WHERE expires_at < NOW()"); err != nil {
+		logger(r).Error("Error deleting expired OIDC login states", "error", err)
+	}
+	_, err = h.DB.ExecContext(r.Context(), `INSERT INTO oidc_login_states (state_hash, provider, code_verifier, nonce, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`, stateHash, provider.Name, codeVerifier, nonce, time.Now().Add(oidcLoginTTL))
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error saving OIDC login state", err))
+		return
+	}
+
+	// Lax rather than Strict, since the provider's redirect back is a cross-site navigation
+	for _, path := range oidcCookiePaths {
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcStateCookie,
+			Value:    state,
+			Path:     path,
+			MaxAge:   int(oidcLoginTTL.Seconds()),
+			Secure:   r.TLS != nil || strings.HasPrefix(apiBaseURL, "https://"),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback finishes a login when the provider redirects back. The external identity
+// is linked to an account by verified email, or a new account is created. The browser
+// is then sent back to the front end, see finishOIDCLogin.
+func (h *AuthenticationHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.OIDCProviders[mux.Vars(r)["provider"]]
+	if !ok {
+		finishOIDCLogin(w, r, url.Values{"error": {"unknown_provider"}})
+		return
+	}
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		logger(r).Info("OIDC login was cancelled or denied", "provider", provider.Name, "error", errCode)
+		finishOIDCLogin(w, r, url.Values{"error": {"login_denied"}})
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if state == "" || err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		finishOIDCLogin(w, r, url.Values{"error": {"invalid_state"}})
+		return
+	}
+	for _, path := range oidcCookiePaths {
+		http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: path, MaxAge: -1})
+	}
+
+	// Deleting the row makes the state single-use
+	var codeVerifier, nonce string
+	err = h.DB.QueryRowContext(r.Context(), `DELETE FROM oidc_login_states WHERE state_hash = $1 AND provider = $2 AND expires_at > NOW()
+		RETURNING code_verifier, nonce`, hashToken(state), provider.Name).Scan(&codeVerifier, &nonce)
+	if err == sql.ErrNoRows {
+		finishOIDCLogin(w, r, url.Values{"error": {"invalid_state"}})
+		return
+	} else if err != nil {
+		logger(r).Error("Error loading OIDC login state", "error", err)
+		finishOIDCLogin(w, r, url.Values{"error": {"server_error"}})
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), query.Get("code"), codeVerifier, nonce)
+	if err != nil {
+		logger(r).Error("Error completing OIDC login", "provider", provider.Name, "error", err)
+		finishOIDCLogin(w, r, url.Values{"error": {"provider_error"}})
+		return
+	}
+
+	userID, err := h.userForIdentity(identity)
+	if err == errUnverifiedEmail {
+		finishOIDCLogin(w, r, url.Values{"error": {"email_not_verified"}})
+		return
+	} else if err == errUnverifiedAccount {
+		logger(r).Warn("Refused to link OIDC identity to an unverified account", "provider", provider.Name)
+		finishOIDCLogin(w, r, url.Values{"error": {"account_not_verified"}})
+		return
+	} else if err != nil {
+		logger(r).Error("Error linking OIDC identity", "error", err)
+		finishOIDCLogin(w, r, url.Values{"error": {"server_error"}})
+		return
+	}
+
+	// Social logins are subject to the same MFA policy as passwords
+	challenge, err := h.secondFactorChallenge(r, userID)
+	if err != nil {
+		logger(r).Error("Error checking MFA for OIDC login", "error", err)
+		finishOIDCLogin(w, r, url.Values{"error": {"server_error"}})
+		return
+	}
+	if challenge != nil {
+		outcome := url.Values{"mfa_token": {challenge.MFAToken}}
+		if challenge.MFARequired {
+			outcome.Set("mfa_required", "true")
+		} else {
+			outcome.Set("mfa_enrollment_required", "true")
+		}
+		finishOIDCLogin(w, r, outcome)
+		return
+	}
+
+	response, err := h.issueTokens(w, r, userID)
+	if err != nil {
+		logger(r).Error("Error issuing tokens for OIDC login", "error", err)
+		finishOIDCLogin(w, r, url.Values{"error": {"server_error"}})
+		return
+	}
+	outcome := url.Values{}
+	if cookies.Enabled {
+		// The session cookies are already set
+		outcome.Set("csrf_token", response.CSRFToken)
+	} else {
+		outcome.Set("token", response.Token)
+		outcome.Set("refresh_token", response.RefreshToken)
+	}
+	finishOIDCLogin(w, r, outcome)
+}
+
+// finishOIDCLogin redirects the browser to the front end's login callback page with
+// the outcome of the login in the URL fragment: an error code, an MFA challenge, or
+// in bearer token mode the tokens. Browsers never send the fragment to a server, so
+// the tokens stay out of access logs and Referer headers.
+func finishOIDCLogin(w http.ResponseWriter, r *http.Request, outcome url.Values) {
+	http.Redirect(w, r, oidcReturnURL+"#"+outcome.Encode(), http.StatusFound)
+}
+
+// userForIdentity returns the local account for an external identity. Known identities
+// map to their account; otherwise the identity is linked to the account with the same
+// verified email, or a new account is created for it.
+func (h *AuthenticationHandler) userForIdentity(identity *oidclogin.Identity) (int, error) {
+	email := normalizeEmail(identity.Email)
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow(`SELECT i.user_id FROM user_identities i JOIN users u ON u.id = i.user_id
+		WHERE i.provider = $1 AND i.subject = $2 AND u.deleted_at IS NULL`, identity.Provider, identity.Subject).Scan(&userID)
+	if err == nil {
+		if _, err := tx.Exec("UPDATE user_identities SET email = $1, last_login_at = NOW() WHERE provider = $2 AND subject = $3",
+			email, identity.Provider, identity.Subject); err != nil {
+			return 0, err
+		}
+		return userID, tx.Commit()
+	} else if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	// Linking by email is only safe when the provider vouches for the address,
+	// otherwise anyone could take over an account by claiming its email
+	if email == "" || !identity.EmailVerified {
+		return 0, errUnverifiedEmail
+	}
+
+	var accountVerified bool
+	err = tx.QueryRow("SELECT id, email_verified_at IS NOT NULL FROM users WHERE LOWER(email) = $1 AND deleted_at IS NULL",
+		email).Scan(&userID, &accountVerified)
+	if err == sql.ErrNoRows {
+		userID, err = createUserForIdentity(tx, identity, email)
+		if err != nil {
+			return 0, err
+		}
+	} else if err != nil {
+		return 0, err
+	} else if !accountVerified {
+		// Whoever registered the account never proved they own the address, and may
+		// not be the person signing in now. Linking would let them keep a password
+		// to the account of whoever does.
+		return 0, errUnverifiedAccount
+	}
+
+	_, err = tx.Exec("INSERT INTO user_identities (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)",
+		userID, identity.Provider, identity.Subject, email)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit()
+}
+
+var usernameDisallowedChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// createUserForIdentity creates an account for a first-time social login. The account
+// has no usable password; the user can set one through the password reset flow.
+func createUserForIdentity(tx *sql.Tx, identity *oidclogin.Identity, email string) (int, error) {
+	base := identity.PreferredUsername
+	if base == "" {
+		base = strings.SplitN(email, "@", 2)[0]
+	}
+	base = usernameDisallowedChars.ReplaceAllString(base, "")
+	if base == "" {
+		base = "user"
+	}
+
+	// Pick the first free username among base, base2, base3...
+	username := base
+	for i := 2; ; i++ {
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)", username).Scan(&exists); err != nil {
+			return 0, err
+		}
+		if !exists {
+			break
+		}
+		username = base + strconv.Itoa(i)
+	}
+
+	// A random password that nobody knows
+	password, _, err := generateOpaqueToken()
+	if err != nil {
+		return 0, err
+	}
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+
+	var userID int
+	err = tx.QueryRow(`INSERT INTO users (username, email, password_hash, email_verified_at)
+		VALUES ($1, $2, $3, NOW()) RETURNING id`, username, email, hashedPassword).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = events.Record(tx, events.UserRegistered, userID, events.UserRegisteredPayload{
+		UserID:   userID,
+		Username: username,
+		Email:    email,
+	})
+	return userID, err
+}
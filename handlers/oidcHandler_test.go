@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"database/sql"
+	"gocommerce/dbtest"
+	"gocommerce/oidclogin"
+	"gocommerce/oidclogin/oidctest"
+	"gocommerce/tokens"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// oidcHandler returns a handler with a provider named mock signing in at issuer
+func oidcHandler(t *testing.T, db *sql.DB, issuer *oidctest.Provider) *AuthenticationHandler {
+	t.Setenv("OIDC_PROVIDERS", "mock")
+	t.Setenv("OIDC_MOCK_ISSUER", issuer.URL)
+	t.Setenv("OIDC_MOCK_CLIENT_ID", issuer.ClientID)
+	t.Setenv("OIDC_MOCK_CLIENT_SECRET", issuer.ClientSecret)
+	return &AuthenticationHandler{DB: db, OIDCProviders: oidclogin.LoadProviders(apiBaseURL + "/api/v1/auth/oidc")}
+}
+
+// oidcOutcome reads the outcome of a login from the redirect to the front end
+func oidcOutcome(t *testing.T, w *httptest.ResponseRecorder) url.Values {
+	t.Helper()
+	location := w.Header().Get("Location")
+	returnURL, fragment, _ := strings.Cut(location, "#")
+	if w.Code != http.StatusFound || returnURL != oidcReturnURL {
+		t.Fatalf("Callback answered %d with Location %q, want a redirect to %s", w.Code, location, oidcReturnURL)
+	}
+	outcome, err := url.ParseQuery(fragment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return outcome
+}
+
+func callbackRequest(target string, cookies ...*http.Cookie) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	return mux.SetURLVars(r, map[string]string{"provider": "mock"})
+}
+
+// TestOIDCCallbackState checks the callback refuses to finish a login the browser
+// didn't start. These are rejected before the database is touched.
+func TestOIDCCallbackState(t *testing.T) {
+	h := oidcHandler(t, nil, oidctest.NewProvider(t))
+	callback := "/api/v1/auth/oidc/mock/callback?code=abc&state="
+
+	tests := []struct {
+		name   string
+		state  string
+		cookie string
+	}{
+		{"no state cookie", "state-a", ""},
+		{"state mismatch", "state-a", "state-b"},
+		{"no state", "", "state-a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cookies []*http.Cookie
+			if tt.cookie != "" {
+				cookies = append(cookies, &http.Cookie{Name: oidcStateCookie, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			h.OIDCCallback(w, callbackRequest(callback+tt.state, cookies...))
+			if outcome := oidcOutcome(t, w); outcome.Get("error") != "invalid_state" || outcome.Get("token") != "" {
+				t.Errorf("Outcome = %v, want error invalid_state", outcome)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	h.OIDCCallback(w, mux.SetURLVars(httptest.NewRequest(http.MethodGet, callback, nil), map[string]string{"provider": "other"}))
+	if outcome := oidcOutcome(t, w); outcome.Get("error") != "unknown_provider" {
+		t.Errorf("Outcome = %v, want error unknown_provider", outcome)
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	db := dbtest.Open(t)
+	t.Setenv("JWT_KEYS_DIR", t.TempDir())
+	if err := tokens.Init(); err != nil {
+		t.Fatal(err)
+	}
+	issuer := oidctest.NewProvider(t)
+	h := oidcHandler(t, db, issuer)
+
+	// login runs a whole login as a browser would and returns the callback request,
+	// so it can be replayed
+	login := func(t *testing.T, user oidctest.User) (url.Values, *http.Request) {
+		t.Helper()
+		issuer.User = user
+		w := httptest.NewRecorder()
+		h.OIDCLogin(w, mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/mock/login", nil), map[string]string{"provider": "mock"}))
+		if w.Code != http.StatusFound || len(w.Result().Cookies()) != len(oidcCookiePaths) {
+			t.Fatalf("Login answered %d: %s", w.Code, w.Body)
+		}
+		callback, err := issuer.Authorize(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := callbackRequest(callback, w.Result().Cookies()[0])
+		w = httptest.NewRecorder()
+		h.OIDCCallback(w, r)
+		return oidcOutcome(t, w), r
+	}
+	linkedUser := func(t *testing.T, subject string) (id int, email string, verified bool) {
+		t.Helper()
+		err := db.QueryRow(`SELECT u.id, u.email, u.email_verified_at IS NOT NULL FROM user_identities i
+			JOIN users u ON u.id = i.user_id WHERE i.provider = 'mock' AND i.subject = $1`, subject).Scan(&id, &email, &verified)
+		if err == sql.ErrNoRows {
+			return 0, "", false
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return id, email, verified
+	}
+	createUser := func(t *testing.T, username, email string, verified bool) int {
+		t.Helper()
+		var id int
+		if err := db.QueryRow(`INSERT INTO users (username, email, password_hash, email_verified_at)
+			VALUES ($1, $2, 'x', CASE WHEN $3 THEN NOW() END) RETURNING id`, username, email, verified).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	t.Run("first login creates an account", func(t *testing.T) {
+		outcome, callback := login(t, oidctest.User{Subject: "new", Email: " New.Person@Example.COM", EmailVerified: true})
+		if outcome.Get("token") == "" || outcome.Get("refresh_token") == "" || outcome.Get("error") != "" {
+			t.Fatalf("Outcome = %v, want tokens", outcome)
+		}
+		id, email, verified := linkedUser(t, "new")
+		if id == 0 || email != "new.person@example.com" || !verified {
+			t.Errorf("Created user %d with email %q, verified %v", id, email, verified)
+		}
+
+		// The same identity signs in to the same account
+		again, _ := login(t, oidctest.User{Subject: "new", Email: "new.person@example.com", EmailVerified: true})
+		if again.Get("token") == "" {
+			t.Errorf("Second login outcome = %v", again)
+		}
+		var accounts int
+		db.QueryRow("SELECT COUNT(*) FROM users WHERE email = 'new.person@example.com'").Scan(&accounts)
+		if accounts != 1 {
+			t.Errorf("%d accounts for one identity", accounts)
+		}
+
+		// States are single use
+		w := httptest.NewRecorder()
+		h.OIDCCallback(w, callback)
+		if replayed := oidcOutcome(t, w); replayed.Get("error") != "invalid_state" {
+			t.Errorf("Replayed callback outcome = %v, want error invalid_state", replayed)
+		}
+	})
+
+	t.Run("links to a verified account", func(t *testing.T) {
+		userID := createUser(t, "verified", "verified@example.com", true)
+		outcome, _ := login(t, oidctest.User{Subject: "verified", Email: "Verified@Example.com", EmailVerified: true})
+		if outcome.Get("token") == "" {
+			t.Fatalf("Outcome = %v, want tokens", outcome)
+		}
+		if id, _, _ := linkedUser(t, "verified"); id != userID {
+			t.Errorf("Identity linked to user %d, want %d", id, userID)
+		}
+	})
+
+	t.Run("refuses to link to an unverified account", func(t *testing.T) {
+		userID := createUser(t, "squatter", "victim@example.com", false)
+		outcome, _ := login(t, oidctest.User{Subject: "victim", Email: "victim@example.com", EmailVerified: true})
+		if outcome.Get("error") != "account_not_verified" || outcome.Get("token") != "" {
+			t.Errorf("Outcome = %v, want error account_not_verified", outcome)
+		}
+		if id, _, _ := linkedUser(t, "victim"); id != 0 {
+			t.Errorf("Identity was linked to user %d", id)
+		}
+		var verified bool
+		db.QueryRow("SELECT email_verified_at IS NOT NULL FROM users WHERE id = $1", userID).Scan(&verified)
+		if verified {
+			t.Error("Unverified account was marked verified")
+		}
+	})
+
+	t.Run("refuses an address the provider hasn't verified", func(t *testing.T) {
+		outcome, _ := login(t, oidctest.User{Subject: "unverified", Email: "someone@example.com", EmailVerified: false})
+		if outcome.Get("error") != "email_not_verified" {
+			t.Errorf("Outcome = %v, want error email_not_verified", outcome)
+		}
+	})
+}
@@ -3,8 +3,12 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"gocommerce/apperrors"
+	"gocommerce/constants"
+	"gocommerce/events"
+	"gocommerce/metrics"
 	"gocommerce/models"
-	"log"
+	"gocommerce/validation"
 	"net/http"
 	"strconv"
 
@@ -15,14 +19,35 @@ type OrderHandler struct {
 	DB *sql.DB
 }
 
+// createOrderRequest is the body for placing an order. Status defaults to pending.
+type createOrderRequest struct {
+	CustomerID int     `json:"customer_id" validate:"required,min=1"`
+	TotalPrice float64 `json:"total_price" validate:"min=0,max=99999999.99"`
+	Status     string  `json:"status" validate:"oneof=pending paid shipped delivered cancelled"`
+}
+
+// updateOrderRequest is the body for replacing an order
+type updateOrderRequest struct {
+	CustomerID int     `json:"customer_id" validate:"required,min=1"`
+	TotalPrice float64 `json:"total_price" validate:"min=0,max=99999999.99"`
+	Status     string  `json:"status" validate:"required,oneof=pending paid shipped delivered cancelled"`
+}
+
 func (h *OrderHandler) GetOrders(w http.ResponseWriter, r *http.Request) {
-	log.Println("GetOrders: Starting to retrieve orders")
+	logger(r).Debug("GetOrders: Starting to retrieve orders")
+
+	withDeleted, ok := includeDeleted(h.DB, w, r)
+	if !ok {
+		return
+	}
 
-	query := "SELECT id, customer_id, total_price, status FROM orders"
-	rows, err := h.DB.Query(query)
+	query := "SELECT id, customer_id, total_price, status, deleted_at FROM orders"
+	if !withDeleted {
+		query += " WHERE deleted_at IS NULL"
+	}
+	rows, err := h.DB.QueryContext(r.Context(), query)
 	if err != nil {
-		log.Printf("GetOrders: Error executing query: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("GetOrders: Error executing query", err))
 		return
 	}
 	defer rows.Close()
@@ -30,21 +55,19 @@ func (h *OrderHandler) GetOrders(w http.ResponseWriter, r *http.Request) {
 	var orders []models.Order
 	for rows.Next() {
 		var o models.Order
-		if err := rows.Scan(&o.ID, &o.CustomerID, &o.TotalPrice, &o.Status); err != nil {
-			log.Printf("GetOrders: Error scanning row: %v\n", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err := rows.Scan(&o.ID, &o.CustomerID, &o.TotalPrice, &o.Status, &o.DeletedAt); err != nil {
+			apperrors.Write(w, r, apperrors.Internal("GetOrders: Error scanning row", err))
 			return
 		}
 		orders = append(orders, o)
 	}
 
 	if err := rows.Err(); err != nil {
-		log.Printf("GetOrders: Error iterating over rows: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("GetOrders: Error iterating over rows", err))
 		return
 	}
 
-	log.Println("GetOrders: Successfully retrieved orders")
+	logger(r).Debug("GetOrders: Successfully retrieved orders")
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(orders)
 }
@@ -55,19 +78,29 @@ func (h *OrderHandler) GetOrder(w http.ResponseWriter, r *http.Request) {
 
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid order ID"))
+		return
+	}
+
+	withDeleted, ok := includeDeleted(h.DB, w, r)
+	if !ok {
 		return
 	}
 
+	query := "SELECT id, customer_id, total_price, status, deleted_at FROM orders WHERE id = $1"
+	if !withDeleted {
+		query += " AND deleted_at IS NULL"
+	}
+
 	var order models.Order
-	row := h.DB.QueryRow("SELECT id, customer_id, total_price, status FROM orders WHERE id = $1", id)
+	row := h.DB.QueryRowContext(r.Context(), query, id)
 
-	err = row.Scan(&order.ID, &order.CustomerID, &order.TotalPrice, &order.Status)
+	err = row.Scan(&order.ID, &order.CustomerID, &order.TotalPrice, &order.Status, &order.DeletedAt)
 	if err == sql.ErrNoRows {
-		http.NotFound(w, r)
+		apperrors.Write(w, r, apperrors.NotFound("Order not found"))
 		return
 	} else if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("Error fetching order", err))
 		return
 	}
 
@@ -75,45 +108,141 @@ func (h *OrderHandler) GetOrder(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *OrderHandler) CreateOrder(w http.ResponseWriter, r *http.Request) {
-	var order models.Order
-	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var req createOrderRequest
+	if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	order := models.Order{CustomerID: req.CustomerID, TotalPrice: req.TotalPrice, Status: req.Status}
+	if order.Status == "" {
+		order.Status = "pending"
+	}
+
+	if requireVerifiedEmailForCheckout {
+		// An integration placing an order with an API key does so for the customer in
+		// the body, so it is that customer's address that has to be verified
+		customerID, ok := r.Context().Value(constants.UserIDKey).(int)
+		if _, isKey := r.Context().Value(constants.APIKeyIDKey).(int); isKey {
+			customerID, ok = order.CustomerID, true
+		}
+		if !ok {
+			apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
+			return
+		}
+		verified, err := emailVerified(h.DB, customerID)
+		if err == sql.ErrNoRows {
+			apperrors.Write(w, r, apperrors.BadRequest("Unknown customer"))
+			return
+		} else if err != nil {
+			apperrors.Write(w, r, apperrors.Internal("Error checking email verification", err))
+			return
+		}
+		if !verified {
+			apperrors.Write(w, r, apperrors.Forbidden("Please verify your email address before checking out").WithCode("email_not_verified"))
+			return
+		}
+	}
+
+	tx, err := h.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error starting transaction", err))
 		return
 	}
+	defer tx.Rollback()
 
 	// Create an SQL INSERT statement
 	sqlStatement := `INSERT INTO orders (customer_id, total_price, status) VALUES ($1, $2, $3) RETURNING id`
 
 	// Execute the SQL statement
-	err := h.DB.QueryRow(sqlStatement, order.CustomerID, order.TotalPrice, order.Status).Scan(&order.ID)
+	err = tx.QueryRowContext(r.Context(), sqlStatement, order.CustomerID, order.TotalPrice, order.Status).Scan(&order.ID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("Error creating order", err))
 		return
 	}
 
+	err = events.Record(tx, events.OrderPlaced, order.ID, events.OrderPlacedPayload{
+		OrderID:    order.ID,
+		CustomerID: order.CustomerID,
+		TotalPrice: order.TotalPrice,
+		Status:     order.Status,
+	})
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error recording order event", err))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error committing transaction", err))
+		return
+	}
+	metrics.OrdersPlaced.Inc()
+	metrics.Revenue.Add(order.TotalPrice)
+
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(order)
 }
 
 func (h *OrderHandler) UpdateOrder(w http.ResponseWriter, r *http.Request) {
+	// Integrations such as fulfilment move orders along with an orders:write key
+	if !requireAdminOrKey(h.DB, w, r) {
+		return
+	}
+
 	vars := mux.Vars(r)
 	idStr := vars["id"]
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid order ID"))
+		return
+	}
+
+	var req updateOrderRequest
+	if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	updated := models.Order{ID: id, CustomerID: req.CustomerID, TotalPrice: req.TotalPrice, Status: req.Status}
+
+	tx, err := h.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error starting transaction", err))
 		return
 	}
+	defer tx.Rollback()
 
-	var updated models.Order
-	if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	// Lock the row so the status change event reflects what was actually overwritten
+	var oldStatus string
+	err = tx.QueryRowContext(r.Context(), `SELECT status FROM orders WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(&oldStatus)
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.NotFound("Order not found"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error locking order", err))
 		return
 	}
 
-	sqlStatement := `UPDATE orders SET customer_id = $1, total_price = $2, status = $3 WHERE id = $4`
-	_, err = h.DB.Exec(sqlStatement, updated.CustomerID, updated.TotalPrice, updated.Status, id)
+	sqlStatement := `UPDATE orders SET customer_id = $1, total_price = $2, status = $3 WHERE id = $4 AND deleted_at IS NULL`
+	_, err = tx.ExecContext(r.Context(), sqlStatement, updated.CustomerID, updated.TotalPrice, updated.Status, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("Error updating order", err))
+		return
+	}
+
+	if updated.Status != oldStatus {
+		err = events.Record(tx, events.OrderStatusChanged, id, events.OrderStatusChangedPayload{
+			OrderID:    id,
+			CustomerID: updated.CustomerID,
+			OldStatus:  oldStatus,
+			NewStatus:  updated.Status,
+		})
+		if err != nil {
+			apperrors.Write(w, r, apperrors.Internal("Error recording order event", err))
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error committing transaction", err))
 		return
 	}
 
@@ -122,20 +251,74 @@ func (h *OrderHandler) UpdateOrder(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *OrderHandler) DeleteOrder(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(h.DB, w, r) {
+		return
+	}
+
 	vars := mux.Vars(r)
 	idStr := vars["id"]
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid order ID"))
+		return
+	}
+
+	tx, err := h.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error starting transaction", err))
 		return
 	}
+	defer tx.Rollback()
 
-	sqlStatement := `DELETE FROM orders WHERE id = $1`
-	_, err = h.DB.Exec(sqlStatement, id)
+	// Soft delete so the order stays available for accounting
+	sqlStatement := `UPDATE orders SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`
+	result, err := tx.ExecContext(r.Context(), sqlStatement, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("Error deleting order", err))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apperrors.Write(w, r, apperrors.NotFound("Order not found"))
+		return
+	}
+
+	if err := events.Record(tx, events.OrderDeleted, id, events.OrderDeletedPayload{OrderID: id}); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error recording order event", err))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error committing transaction", err))
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (h *OrderHandler) RestoreOrder(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(h.DB, w, r) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid order ID"))
+		return
+	}
+
+	var order models.Order
+	sqlStatement := `UPDATE orders SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL RETURNING id, customer_id, total_price, status`
+	err = h.DB.QueryRowContext(r.Context(), sqlStatement, id).Scan(&order.ID, &order.CustomerID, &order.TotalPrice, &order.Status)
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.NotFound("Order not found"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error restoring order", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(order)
+}
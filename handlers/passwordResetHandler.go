@@ -0,0 +1,163 @@
+// passwordResetHandler.go
+
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"gocommerce/apperrors"
+	"gocommerce/config"
+	"gocommerce/notifications"
+	"gocommerce/validation"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// passwordResetTTL is how long a password reset link stays valid
+var passwordResetTTL = config.Duration("PASSWORD_RESET_TTL", time.Hour)
+
+type forgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=8,max=72"`
+}
+
+// forgotPasswordResponse is returned whether or not the account exists, so the
+// endpoint can't be used to discover registered emails
+var forgotPasswordResponse = map[string]string{
+	"message": "If an account exists for that email, a password reset link has been sent",
+}
+
+func (h *AuthenticationHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req forgotPasswordRequest
+	if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	// Counted whether or not the account exists, so the limit gives nothing away
+	email := normalizeEmail(req.Email)
+	lockedFor, err := loginThrottle{DB: h.DB}.resetRequest(email, clientIP(r))
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error checking password reset throttle", err))
+		return
+	}
+	if lockedFor > 0 {
+		retryAfter(w, lockedFor)
+		apperrors.Write(w, r, apperrors.TooManyRequests("Too many password reset requests, try again later"))
+		return
+	}
+
+	if err := h.sendPasswordReset(email); err != nil {
+		// Logged only: the response must look the same as for an unknown email
+		logger(r).Error("Error sending password reset", "error", err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(forgotPasswordResponse)
+}
+
+// sendPasswordReset issues a reset token for the account with the given email
+// and emails the link. An unknown email is not an error.
+func (h *AuthenticationHandler) sendPasswordReset(email string) error {
+	var userID int
+	var username string
+	err := h.DB.QueryRow("SELECT id, username FROM users WHERE email = $1 AND deleted_at IS NULL", email).Scan(&userID, &username)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	token, tokenHash, err := generateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Only the most recent link works
+	if _, err := tx.Exec("UPDATE password_reset_tokens SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL", userID); err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)",
+		userID, tokenHash, time.Now().Add(passwordResetTTL))
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return h.Notifier.Send(notifications.TemplatePasswordReset, email, map[string]interface{}{
+		"Username":  username,
+		"Link":      h.Notifier.BaseURL + "/reset-password?token=" + url.QueryEscape(token),
+		"ExpiresIn": passwordResetTTL.String(),
+	})
+}
+
+func (h *AuthenticationHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordRequest
+	if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	hashedPassword, err := hashPassword(req.Password)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error hashing password", err))
+		return
+	}
+
+	tx, err := h.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error starting transaction", err))
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the token so two concurrent requests can't both use it
+	var tokenID, userID int
+	err = tx.QueryRowContext(r.Context(), `SELECT id, user_id FROM password_reset_tokens
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW() FOR UPDATE`, hashToken(req.Token)).Scan(&tokenID, &userID)
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid or expired reset token"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error looking up reset token", err))
+		return
+	}
+
+	if _, err := tx.ExecContext(r.Context(), "UPDATE password_reset_tokens SET used_at = NOW() WHERE id = $1", tokenID); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error consuming reset token", err))
+		return
+	}
+
+	// Bumping token_version signs the user out everywhere
+	_, err = tx.ExecContext(r.Context(), `UPDATE users SET password_hash = $1, token_version = token_version + 1, updated_at = NOW()
+		WHERE id = $2 AND deleted_at IS NULL`, hashedPassword, userID)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error updating password", err))
+		return
+	}
+
+	if err := revokeSessions(tx, userID); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error revoking sessions", err))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error committing transaction", err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password has been reset"})
+}
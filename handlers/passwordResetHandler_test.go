@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"gocommerce/dbtest"
+	"gocommerce/notifications"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestForgotPassword(t *testing.T) {
+	db := dbtest.Open(t)
+	h := &AuthenticationHandler{DB: db, Notifier: notifications.NewNotifier(db, notifications.NewQueue(&notifications.MemoryMailer{}, 100))}
+
+	var userID int
+	if err := db.QueryRow(`INSERT INTO users (username, email, password_hash) VALUES ('ada', 'ada@example.com', 'x') RETURNING id`).Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	forgot := func(email, ip string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/forgot-password", strings.NewReader(`{"email":"`+email+`"}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.RemoteAddr = ip + ":1234"
+		h.ForgotPassword(w, r)
+		return w
+	}
+
+	// The address is matched however it was typed
+	if w := forgot(" Ada@Example.COM", "192.0.2.1"); w.Code != http.StatusAccepted {
+		t.Fatalf("ForgotPassword answered %d: %s", w.Code, w.Body)
+	}
+	var links int
+	db.QueryRow(`SELECT COUNT(*) FROM password_reset_tokens WHERE user_id = $1`, userID).Scan(&links)
+	if links != 1 {
+		t.Errorf("%d reset links issued, want 1", links)
+	}
+
+	t.Run("per address", func(t *testing.T) {
+		// Requests from different IPs still count against the one address
+		for i := 1; i < passwordResetMaxPerEmail; i++ {
+			if w := forgot("ada@example.com", "192.0.2.10"); w.Code != http.StatusAccepted {
+				t.Fatalf("Request %d answered %d", i+1, w.Code)
+			}
+		}
+		w := forgot("ADA@example.com", "192.0.2.11")
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+			t.Errorf("Request over the limit answered %d with Retry-After %q, want 429", w.Code, w.Header().Get("Retry-After"))
+		}
+	})
+
+	t.Run("per IP", func(t *testing.T) {
+		// Unknown addresses count the same, so the limit says nothing about which exist
+		for i := 0; i < passwordResetMaxPerIP; i++ {
+			if w := forgot("nobody"+strings.Repeat("x", i)+"@example.com", "192.0.2.20"); w.Code != http.StatusAccepted {
+				t.Fatalf("Request %d answered %d", i+1, w.Code)
+			}
+		}
+		if w := forgot("someone@example.com", "192.0.2.20"); w.Code != http.StatusTooManyRequests {
+			t.Errorf("Request over the IP limit answered %d, want 429", w.Code)
+		}
+		if w := forgot("someone@example.com", "192.0.2.21"); w.Code != http.StatusAccepted {
+			t.Errorf("Request from another IP answered %d, want 202", w.Code)
+		}
+	})
+}
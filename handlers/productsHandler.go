@@ -5,10 +5,13 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"gocommerce/apperrors"
+	"gocommerce/events"
 	"gocommerce/models"
-	"log"
+	"gocommerce/validation"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
@@ -17,15 +20,28 @@ type ProductHandler struct {
 	DB *sql.DB
 }
 
+// productRequest is the body for creating or replacing a product
+type productRequest struct {
+	Name  string   `json:"name" validate:"required,max=255"`
+	Price *float64 `json:"price" validate:"required,min=0,max=99999999.99"` // NUMERIC(10, 2)
+}
+
 func (h *ProductHandler) GetProducts(w http.ResponseWriter, r *http.Request) {
-	log.Println("GetProducts: Starting to retrieve products")
+	logger(r).Debug("GetProducts: Starting to retrieve products")
+
+	withDeleted, ok := includeDeleted(h.DB, w, r)
+	if !ok {
+		return
+	}
 
 	// Prepare SQL query
-	query := "SELECT id, name, price FROM products"
-	rows, err := h.DB.Query(query)
+	query := "SELECT id, name, price, deleted_at FROM products"
+	if !withDeleted {
+		query += " WHERE deleted_at IS NULL"
+	}
+	rows, err := h.DB.QueryContext(r.Context(), query)
 	if err != nil {
-		log.Printf("GetProducts: Error executing query: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("GetProducts: Error executing query", err))
 		return
 	}
 	defer rows.Close()
@@ -33,21 +49,19 @@ func (h *ProductHandler) GetProducts(w http.ResponseWriter, r *http.Request) {
 	var products []models.Product
 	for rows.Next() {
 		var p models.Product
-		if err := rows.Scan(&p.ID, &p.Name, &p.Price); err != nil {
-			log.Printf("GetProducts: Error scanning row: %v\n", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err := rows.Scan(&p.ID, &p.Name, &p.Price, &p.DeletedAt); err != nil {
+			apperrors.Write(w, r, apperrors.Internal("GetProducts: Error scanning row", err))
 			return
 		}
 		products = append(products, p)
 	}
 
 	if err := rows.Err(); err != nil {
-		log.Printf("GetProducts: Error iterating over rows: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("GetProducts: Error iterating over rows", err))
 		return
 	}
 
-	log.Println("GetProducts: Successfully retrieved products")
+	logger(r).Debug("GetProducts: Successfully retrieved products")
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(products)
 }
@@ -58,19 +72,29 @@ func (h *ProductHandler) GetProduct(w http.ResponseWriter, r *http.Request) {
 
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid product ID"))
 		return
 	}
 
+	withDeleted, ok := includeDeleted(h.DB, w, r)
+	if !ok {
+		return
+	}
+
+	query := "SELECT id, name, price, deleted_at FROM products WHERE id = $1"
+	if !withDeleted {
+		query += " AND deleted_at IS NULL"
+	}
+
 	var product models.Product
-	row := h.DB.QueryRow("SELECT id, name, price FROM products WHERE id = $1", id)
+	row := h.DB.QueryRowContext(r.Context(), query, id)
 
-	err = row.Scan(&product.ID, &product.Name, &product.Price)
+	err = row.Scan(&product.ID, &product.Name, &product.Price, &product.DeletedAt)
 	if err == sql.ErrNoRows {
-		http.NotFound(w, r)
+		apperrors.Write(w, r, apperrors.NotFound("Product not found"))
 		return
 	} else if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("Error fetching product", err))
 		return
 	}
 
@@ -78,19 +102,46 @@ func (h *ProductHandler) GetProduct(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *ProductHandler) CreateProduct(w http.ResponseWriter, r *http.Request) {
-	var product models.Product
-	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !requireStaffOrKey(h.DB, w, r) {
+		return
+	}
+
+	var req productRequest
+	if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	product := models.Product{Name: strings.TrimSpace(req.Name), Price: *req.Price}
+
+	tx, err := h.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error starting transaction", err))
 		return
 	}
+	defer tx.Rollback()
 
 	// Create an SQL INSERT statement
 	sqlStatement := `INSERT INTO products (name, price) VALUES ($1, $2) RETURNING id`
 
 	// Execute the SQL statement
-	err := h.DB.QueryRow(sqlStatement, product.Name, product.Price).Scan(&product.ID)
+	err = tx.QueryRowContext(r.Context(), sqlStatement, product.Name, product.Price).Scan(&product.ID)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error creating product", err))
+		return
+	}
+
+	err = events.Record(tx, events.ProductCreated, product.ID, events.ProductCreatedPayload{
+		ProductID: product.ID,
+		Name:      product.Name,
+		Price:     product.Price,
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("Error recording product event", err))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error committing transaction", err))
 		return
 	}
 
@@ -99,26 +150,63 @@ func (h *ProductHandler) CreateProduct(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
-	// Authentication and admin check should be implemented here
+	if !requireStaffOrKey(h.DB, w, r) {
+		return
+	}
 
 	vars := mux.Vars(r)
 	idStr := vars["id"]
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid product ID"))
 		return
 	}
 
-	var updated models.Product
-	if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var req productRequest
+	if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
 		return
 	}
+	updated := models.Product{ID: id, Name: strings.TrimSpace(req.Name), Price: *req.Price}
 
-	sqlStatement := `UPDATE products SET name = $1, price = $2 WHERE id = $3`
-	_, err = h.DB.Exec(sqlStatement, updated.Name, updated.Price, id)
+	tx, err := h.DB.BeginTx(r.Context(), nil)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("Error starting transaction", err))
+		return
+	}
+	defer tx.Rollback()
+
+	var oldPrice float64
+	err = tx.QueryRowContext(r.Context(), `SELECT price FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(&oldPrice)
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.NotFound("Product not found"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error locking product", err))
+		return
+	}
+
+	sqlStatement := `UPDATE products SET name = $1, price = $2 WHERE id = $3 AND deleted_at IS NULL`
+	_, err = tx.ExecContext(r.Context(), sqlStatement, updated.Name, updated.Price, id)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error updating product", err))
+		return
+	}
+
+	if updated.Price != oldPrice {
+		err = events.Record(tx, events.ProductPriceChanged, id, events.ProductPriceChangedPayload{
+			ProductID: id,
+			OldPrice:  oldPrice,
+			NewPrice:  updated.Price,
+		})
+		if err != nil {
+			apperrors.Write(w, r, apperrors.Internal("Error recording product event", err))
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error committing transaction", err))
 		return
 	}
 
@@ -127,22 +215,79 @@ func (h *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *ProductHandler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
-	// Authentication and admin check should be implemented here
+	if !requireAdmin(h.DB, w, r) {
+		return
+	}
 
 	vars := mux.Vars(r)
 	idStr := vars["id"]
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid product ID"))
 		return
 	}
 
-	sqlStatement := `DELETE FROM products WHERE id = $1`
-	_, err = h.DB.Exec(sqlStatement, id)
+	tx, err := h.DB.BeginTx(r.Context(), nil)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("Error starting transaction", err))
+		return
+	}
+	defer tx.Rollback()
+
+	// Soft delete so existing orders keep their history
+	result, err := tx.ExecContext(r.Context(), `UPDATE products SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`, id)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error deleting product", err))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apperrors.Write(w, r, apperrors.NotFound("Product not found"))
+		return
+	}
+
+	// A deleted product can no longer be bought, so pull it from every active cart
+	if _, err := tx.ExecContext(r.Context(), `DELETE FROM cart_items WHERE product_id = $1`, id); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error removing deleted product from carts", err))
+		return
+	}
+
+	if err := events.Record(tx, events.ProductDeleted, id, events.ProductDeletedPayload{ProductID: id}); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error recording product event", err))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error committing transaction", err))
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (h *ProductHandler) RestoreProduct(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(h.DB, w, r) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid product ID"))
+		return
+	}
+
+	var product models.Product
+	sqlStatement := `UPDATE products SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL RETURNING id, name, price`
+	err = h.DB.QueryRowContext(r.Context(), sqlStatement, id).Scan(&product.ID, &product.Name, &product.Price)
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.NotFound("Product not found"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error restoring product", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(product)
+}
@@ -0,0 +1,317 @@
+// profileHandler.go
+
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"gocommerce/apperrors"
+	"gocommerce/constants"
+	"gocommerce/cookies"
+	"gocommerce/validation"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Profile is the current user's own view of their account
+type Profile struct {
+	ID            int       `json:"id"`
+	Username      string    `json:"username"`
+	Email         string    `json:"email"`
+	PendingEmail  *string   `json:"pending_email,omitempty"` // New address waiting for verification
+	EmailVerified bool      `json:"email_verified"`
+	Role          string    `json:"role"`
+	MFAEnabled    bool      `json:"mfa_enabled"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type updateProfileRequest struct {
+	Username        *string `json:"username" validate:"max=50,username"`
+	Email           *string `json:"email" validate:"email,max=255"`
+	CurrentPassword string  `json:"current_password"` // Required to change the email address
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=8,max=72"`
+}
+
+type deleteAccountRequest struct {
+	Password string `json:"password" validate:"required"`
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint violation.
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
+
+// loadProfile returns the profile of a user that hasn't been deleted.
+func (h *AuthenticationHandler) loadProfile(userID int) (*Profile, error) {
+	var p Profile
+	var verifiedAt, mfaEnabledAt *time.Time
+	err := h.DB.QueryRow(`SELECT id, username, email, pending_email, email_verified_at, role, mfa_enabled_at, created_at
+		FROM users WHERE id = $1 AND deleted_at IS NULL`, userID).
+		Scan(&p.ID, &p.Username, &p.Email, &p.PendingEmail, &verifiedAt, &p.Role, &mfaEnabledAt, &p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	p.EmailVerified = verifiedAt != nil
+	p.MFAEnabled = mfaEnabledAt != nil
+	return &p, nil
+}
+
+// checkCurrentPassword writes an error response and returns false unless password is
+// the user's password. Wrong guesses count towards the same lockout as failed logins.
+func checkCurrentPassword(db *sql.DB, w http.ResponseWriter, r *http.Request, userID int, password string) bool {
+	var username, passwordHash string
+	err := db.QueryRowContext(r.Context(), "SELECT username, password_hash FROM users WHERE id = $1 AND deleted_at IS NULL", userID).
+		Scan(&username, &passwordHash)
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
+		return false
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error loading user", err))
+		return false
+	}
+
+	throttle := loginThrottle{DB: db}
+	ip := clientIP(r)
+	attempts, lockedFor, err := throttle.attempt(username, ip)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error checking login throttle", err))
+		return false
+	}
+	if lockedFor > 0 {
+		retryAfter(w, lockedFor)
+		apperrors.Write(w, r, apperrors.TooManyRequests("Too many failed attempts, try again later"))
+		return false
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) != nil {
+		if err := throttle.failed(username, attempts); err != nil {
+			logger(r).Error("Error recording failed password check", "error", err)
+		}
+		apperrors.Write(w, r, apperrors.Forbidden("Current password is incorrect").WithCode("incorrect_password"))
+		return false
+	}
+
+	if err := throttle.succeeded(username, ip); err != nil {
+		logger(r).Error("Error resetting login throttle", "error", err)
+	}
+	return true
+}
+
+// GetMe returns the current user's profile.
+func (h *AuthenticationHandler) GetMe(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(constants.UserIDKey).(int)
+	if !ok {
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
+		return
+	}
+
+	profile, err := h.loadProfile(userID)
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error loading profile", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+// UpdateMe changes the current user's username and/or email address. A new email
+// address only replaces the current one once it has been verified.
+func (h *AuthenticationHandler) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(constants.UserIDKey).(int)
+	if !ok {
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
+		return
+	}
+
+	var req updateProfileRequest
+	if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	profile, err := h.loadProfile(userID)
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error loading profile", err))
+		return
+	}
+
+	username := profile.Username
+	if req.Username != nil {
+		username = strings.TrimSpace(*req.Username)
+	}
+	var newEmail string
+	if req.Email != nil && !strings.EqualFold(normalizeEmail(*req.Email), profile.Email) {
+		newEmail = normalizeEmail(*req.Email)
+		// Whoever controls the email address can reset the password, so changing it
+		// needs the password. It is checked before anything is written.
+		if !checkCurrentPassword(h.DB, w, r, userID, req.CurrentPassword) {
+			return
+		}
+	}
+	if username == profile.Username && newEmail == "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(profile)
+		return
+	}
+
+	tx, err := h.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error starting transaction", err))
+		return
+	}
+	defer tx.Rollback()
+
+	if username != profile.Username {
+		_, err := tx.ExecContext(r.Context(), "UPDATE users SET username = $1, updated_at = NOW() WHERE id = $2", username, userID)
+		if isUniqueViolation(err) {
+			apperrors.Write(w, r, apperrors.Conflict("username already in use"))
+			return
+		} else if err != nil {
+			apperrors.Write(w, r, apperrors.Internal("Error updating username", err))
+			return
+		}
+		profile.Username = username
+	}
+
+	var verificationToken string
+	if newEmail != "" {
+		verificationToken, err = requestEmailChange(tx, userID, newEmail)
+		if err == errEmailTaken {
+			apperrors.Write(w, r, apperrors.Conflict("email already in use"))
+			return
+		} else if err != nil {
+			apperrors.Write(w, r, apperrors.Internal("Error saving pending email", err))
+			return
+		}
+		profile.PendingEmail = &newEmail
+	}
+
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error committing transaction", err))
+		return
+	}
+
+	emailChanged := newEmail != ""
+	if emailChanged {
+		if err := sendVerificationLink(h.Notifier, profile.Username, newEmail, verificationToken); err != nil {
+			apperrors.Write(w, r, apperrors.Internal("Error sending verification email", err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if emailChanged {
+		// The new address isn't in effect until the link we sent to it is followed
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(profile)
+}
+
+// ChangePassword sets a new password after checking the current one. Every other
+// session is signed out.
+func (h *AuthenticationHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(constants.UserIDKey).(int)
+	if !ok {
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
+		return
+	}
+	sessionID, _ := r.Context().Value(constants.SessionIDKey).(int)
+
+	var req changePasswordRequest
+	if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	if !checkCurrentPassword(h.DB, w, r, userID, req.CurrentPassword) {
+		return
+	}
+
+	hashedPassword, err := hashPassword(req.NewPassword)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error hashing password", err))
+		return
+	}
+
+	tx, err := h.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error starting transaction", err))
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(r.Context(), "UPDATE users SET password_hash = $1, updated_at = NOW() WHERE id = $2", hashedPassword, userID); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error updating password", err))
+		return
+	}
+
+	// Keep the session making the request so the user stays signed in here
+	_, err = tx.ExecContext(r.Context(), "UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL",
+		userID, sessionID)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error revoking sessions", err))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error committing transaction", err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password has been changed"})
+}
+
+// DeleteMe deletes the current user's account after checking their password.
+func (h *AuthenticationHandler) DeleteMe(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(constants.UserIDKey).(int)
+	if !ok {
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
+		return
+	}
+
+	var req deleteAccountRequest
+	if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	if !checkCurrentPassword(h.DB, w, r, userID, req.Password) {
+		return
+	}
+
+	tx, err := h.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error starting transaction", err))
+		return
+	}
+	defer tx.Rollback()
+
+	if err := softDeleteUser(tx, userID); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error deleting user", err))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error committing transaction", err))
+		return
+	}
+
+	cookies.Clear(w)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"gocommerce/constants"
+	"gocommerce/dbtest"
+	"gocommerce/notifications"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// createTestUser inserts a verified user with the given role and password
+func createTestUser(t *testing.T, db *sql.DB, username, role, password string) int {
+	t.Helper()
+	hash, err := hashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id int
+	err = db.QueryRow(`INSERT INTO users (username, email, password_hash, role, email_verified_at)
+		VALUES ($1, $1 || '@example.com', $2, $3, NOW()) RETURNING id`, username, hash, role).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// asUser returns a JSON request made by the signed-in user
+func asUser(userID int, method, target, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r.WithContext(context.WithValue(r.Context(), constants.UserIDKey, userID))
+}
+
+type accountState struct {
+	Username, Email string
+	PendingEmail    sql.NullString
+	Verified        bool
+}
+
+func loadAccount(t *testing.T, db *sql.DB, userID int) accountState {
+	t.Helper()
+	var a accountState
+	err := db.QueryRow(`SELECT username, email, pending_email, email_verified_at IS NOT NULL FROM users WHERE id = $1`, userID).
+		Scan(&a.Username, &a.Email, &a.PendingEmail, &a.Verified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestUpdateMe(t *testing.T) {
+	db := dbtest.Open(t)
+	h := &AuthenticationHandler{DB: db, Notifier: notifications.NewNotifier(db, notifications.NewQueue(&notifications.MemoryMailer{}, 10))}
+	userID := createTestUser(t, db, "grace", RoleCustomer, "correct-password")
+
+	// A wrong password changes nothing, not even the username sent with it
+	w := httptest.NewRecorder()
+	h.UpdateMe(w, asUser(userID, http.MethodPatch, "/api/v1/me", `{"username":"hopper","email":"new@example.com","current_password":"wrong-password"}`))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("UpdateMe with a wrong password answered %d, want 403", w.Code)
+	}
+	if got := loadAccount(t, db, userID); got.Username != "grace" || got.PendingEmail.Valid {
+		t.Errorf("Account after a refused update = %+v, want it unchanged", got)
+	}
+
+	w = httptest.NewRecorder()
+	h.UpdateMe(w, asUser(userID, http.MethodPatch, "/api/v1/me", `{"username":"hopper","email":"New@Example.com","current_password":"correct-password"}`))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("UpdateMe answered %d: %s", w.Code, w.Body)
+	}
+	got := loadAccount(t, db, userID)
+	if got.Username != "hopper" || got.Email != "grace@example.com" || got.PendingEmail.String != "new@example.com" || !got.Verified {
+		t.Errorf("Account after the update = %+v, want the new username and the new address pending", got)
+	}
+}
+
+func TestUpdateUserEmail(t *testing.T) {
+	db := dbtest.Open(t)
+	h := &UserHandler{DB: db, Notifier: notifications.NewNotifier(db, notifications.NewQueue(&notifications.MemoryMailer{}, 10))}
+	adminID := createTestUser(t, db, "admin", RoleAdmin, "admin-password")
+	userID := createTestUser(t, db, "linus", RoleCustomer, "user-password")
+	createTestUser(t, db, "taken", RoleCustomer, "user-password")
+
+	update := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := asUser(adminID, http.MethodPut, "/api/v1/users/"+strconv.Itoa(userID), body)
+		h.UpdateUser(w, mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(userID)}))
+		return w
+	}
+
+	if w := update(`{"username":"linus","email":"taken@example.com"}`); w.Code != http.StatusConflict {
+		t.Errorf("Changing to another account's address answered %d, want 409", w.Code)
+	}
+
+	// The address only changes once the user proves they own it
+	w := update(`{"username":"linus","email":"Linus@New.Example"}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("UpdateUser answered %d: %s", w.Code, w.Body)
+	}
+	got := loadAccount(t, db, userID)
+	if got.Email != "linus@example.com" || got.PendingEmail.String != "linus@new.example" || !got.Verified {
+		t.Errorf("Account after the update = %+v, want the old verified address kept and the new one pending", got)
+	}
+	var tokens int
+	db.QueryRow(`SELECT COUNT(*) FROM email_verification_tokens WHERE user_id = $1 AND email = 'linus@new.example'`, userID).Scan(&tokens)
+	if tokens != 1 {
+		t.Errorf("%d verification tokens issued for the new address, want 1", tokens)
+	}
+
+	// Saving the same details again doesn't send another link
+	if w := update(`{"username":"linus","email":"linus@new.example"}`); w.Code != http.StatusOK {
+		t.Errorf("Repeating the update answered %d, want 200", w.Code)
+	}
+}
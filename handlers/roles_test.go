@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"gocommerce/constants"
+	"gocommerce/dbtest"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// API keys must never pass for admins: scopes cover whole resources, and some
+// admin endpoints under them, such as changing a user's role, are admin only
+func TestAPIKeysAreNotAdmins(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPut, "/api/v1/users/1", nil)
+	r = r.WithContext(context.WithValue(r.Context(), constants.APIKeyIDKey, 7))
+
+	if isAdmin(nil, r) {
+		t.Error("isAdmin() = true for an API key")
+	}
+	w := httptest.NewRecorder()
+	if requireAdmin(nil, w, r) || w.Code != http.StatusForbidden {
+		t.Errorf("requireAdmin() let an API key through with %d", w.Code)
+	}
+	if !requireAdminOrKey(nil, httptest.NewRecorder(), r) {
+		t.Error("requireAdminOrKey() rejected an API key")
+	}
+}
+
+func TestDeleteProductIsAdminOnly(t *testing.T) {
+	h := &ProductHandler{}
+	anonymous := httptest.NewRequest(http.MethodDelete, "/api/v1/products/1", nil)
+	withKey := withAPIKey(anonymous)
+
+	for _, r := range []*http.Request{anonymous, withKey} {
+		w := httptest.NewRecorder()
+		h.DeleteProduct(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("DeleteProduct() answered %d, want 403", w.Code)
+		}
+	}
+}
+
+// withAPIKey returns r as made with an API key the middleware has already checked
+func withAPIKey(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), constants.APIKeyIDKey, 7))
+}
+
+// TestCatalogueChangesNeedStaff checks who may create and replace products. API
+// keys pass the role check, so their invalid body is what gets rejected.
+// TestAPIKeysCantSeeDeletedUsers checks listing and fetching users agree that
+// only admins see soft-deleted accounts
+func TestAPIKeysCantSeeDeletedUsers(t *testing.T) {
+	h := &UserHandler{}
+	r := withAPIKey(httptest.NewRequest(http.MethodGet, "/api/v1/users?include_deleted=true", nil))
+	for name, handle := range map[string]http.HandlerFunc{"GetUsers": h.GetUsers, "GetUser": h.GetUser} {
+		w := httptest.NewRecorder()
+		handle(w, mux.SetURLVars(r, map[string]string{"id": "1"}))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s() with include_deleted answered %d, want 403", name, w.Code)
+		}
+	}
+}
+
+func TestCatalogueChangesNeedStaff(t *testing.T) {
+	h := &ProductHandler{}
+	routes := []struct {
+		name   string
+		handle http.HandlerFunc
+		method string
+	}{
+		{"CreateProduct", h.CreateProduct, http.MethodPost},
+		{"UpdateProduct", h.UpdateProduct, http.MethodPut},
+	}
+	for _, route := range routes {
+		t.Run(route.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			route.handle(w, httptest.NewRequest(route.method, "/api/v1/products/1", strings.NewReader(`{}`)))
+			if w.Code != http.StatusForbidden {
+				t.Errorf("Anonymous caller answered %d, want 403", w.Code)
+			}
+
+			w = httptest.NewRecorder()
+			r := withAPIKey(httptest.NewRequest(route.method, "/api/v1/products/1", strings.NewReader(`{}`)))
+			route.handle(w, mux.SetURLVars(r, map[string]string{"id": "1"}))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("API key answered %d, want 400 for the empty body", w.Code)
+			}
+		})
+	}
+}
+
+func TestOrderChangesNeedAdmin(t *testing.T) {
+	h := &OrderHandler{}
+	w := httptest.NewRecorder()
+	h.DeleteOrder(w, withAPIKey(httptest.NewRequest(http.MethodDelete, "/api/v1/orders/1", nil)))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("DeleteOrder() with an API key answered %d, want 403", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.UpdateOrder(w, httptest.NewRequest(http.MethodPut, "/api/v1/orders/1", strings.NewReader(`{}`)))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("UpdateOrder() without a caller answered %d, want 403", w.Code)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	db := dbtest.Open(t)
+	customerID := createTestUser(t, db, "customer", RoleCustomer, "customer-password")
+	staffID := createTestUser(t, db, "staff", RoleStaff, "staff-password")
+	adminID := createTestUser(t, db, "admin", RoleAdmin, "admin-password")
+	products := &ProductHandler{DB: db}
+	orders := &OrderHandler{DB: db}
+
+	var productID, orderID int
+	db.QueryRow(`INSERT INTO products (name, price) VALUES ('Widget', 10) RETURNING id`).Scan(&productID)
+	db.QueryRow(`INSERT INTO orders (customer_id, total_price, status) VALUES ($1, 10, 'pending') RETURNING id`, customerID).Scan(&orderID)
+	product, order := strconv.Itoa(productID), strconv.Itoa(orderID)
+
+	tests := []struct {
+		name   string
+		handle http.HandlerFunc
+		userID int
+		method string
+		id     string
+		body   string
+		want   int
+	}{
+		{"customer creating a product", products.CreateProduct, customerID, http.MethodPost, "", `{"name":"Cheap","price":0}`, http.StatusForbidden},
+		{"customer repricing a product", products.UpdateProduct, customerID, http.MethodPut, product, `{"name":"Widget","price":0}`, http.StatusForbidden},
+		{"staff creating a product", products.CreateProduct, staffID, http.MethodPost, "", `{"name":"Gadget","price":5}`, http.StatusCreated},
+		{"staff repricing a product", products.UpdateProduct, staffID, http.MethodPut, product, `{"name":"Widget","price":12}`, http.StatusOK},
+		{"customer rewriting their order", orders.UpdateOrder, customerID, http.MethodPut, order, `{"customer_id":1,"total_price":0,"status":"delivered"}`, http.StatusForbidden},
+		{"customer deleting their order", orders.DeleteOrder, customerID, http.MethodDelete, order, ``, http.StatusForbidden},
+		{"staff deleting an order", orders.DeleteOrder, staffID, http.MethodDelete, order, ``, http.StatusForbidden},
+		{"admin changing an order's status", orders.UpdateOrder, adminID, http.MethodPut, order, `{"customer_id":` + strconv.Itoa(customerID) + `,"total_price":10,"status":"paid"}`, http.StatusOK},
+		{"admin deleting an order", orders.DeleteOrder, adminID, http.MethodDelete, order, ``, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handle(w, mux.SetURLVars(asUser(tt.userID, tt.method, "/", tt.body), map[string]string{"id": tt.id}))
+			if w.Code != tt.want {
+				t.Errorf("Answered %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+
+	var price float64
+	db.QueryRow(`SELECT price FROM products WHERE id = $1`, productID).Scan(&price)
+	if price != 12 {
+		t.Errorf("Product price = %v, want only the staff change applied", price)
+	}
+}
@@ -0,0 +1,166 @@
+// sessionsHandler.go
+
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"gocommerce/apperrors"
+	"gocommerce/constants"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Session is a login as shown to the user who owns it
+type Session struct {
+	ID         int       `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"` // The session making this request
+}
+
+// describeDevice turns a user agent into a short label such as "Firefox on Windows".
+func describeDevice(userAgent string) string {
+	browser := "Unknown browser"
+	// Order matters: Edge and Chrome both claim to be Safari, and Edge claims to be Chrome
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	platform := "unknown OS"
+	for _, p := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, p.token) {
+			platform = p.name
+			break
+		}
+	}
+
+	return browser + " on " + platform
+}
+
+// createSession records a new login for userID and returns its ID.
+func (h *AuthenticationHandler) createSession(r *http.Request, userID int) (int, error) {
+	userAgent := r.UserAgent()
+	var sessionID int
+	err := h.DB.QueryRowContext(r.Context(), `INSERT INTO sessions (user_id, device, user_agent, ip_address, expires_at)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		userID, describeDevice(userAgent), userAgent, clientIP(r), time.Now().Add(refreshTokenTTL)).Scan(&sessionID)
+	return sessionID, err
+}
+
+// revokeSessions signs a user out of every session as part of tx. Access tokens
+// stop working immediately because the middleware checks their session.
+func revokeSessions(tx *sql.Tx, userID int) error {
+	_, err := tx.Exec("UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	return err
+}
+
+// GetSessions lists the current user's active sessions.
+func (h *AuthenticationHandler) GetSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(constants.UserIDKey).(int)
+	if !ok {
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
+		return
+	}
+	currentSessionID, _ := r.Context().Value(constants.SessionIDKey).(int)
+
+	rows, err := h.DB.QueryContext(r.Context(), `SELECT id, device, user_agent, ip_address, created_at, last_used_at
+		FROM sessions WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC`, userID)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error querying sessions", err))
+		return
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.Device, &s.UserAgent, &s.IPAddress, &s.CreatedAt, &s.LastUsedAt); err != nil {
+			apperrors.Write(w, r, apperrors.Internal("Error scanning session", err))
+			return
+		}
+		s.Current = s.ID == currentSessionID
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error iterating sessions", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// RevokeSession signs the current user out of one of their sessions.
+func (h *AuthenticationHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(constants.UserIDKey).(int)
+	if !ok {
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
+		return
+	}
+
+	sessionID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid session ID"))
+		return
+	}
+
+	// Scoping by user_id means another user's session looks the same as a missing one
+	result, err := h.DB.ExecContext(r.Context(), "UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL",
+		sessionID, userID)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error revoking session", err))
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		apperrors.Write(w, r, apperrors.NotFound("Session not found"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeUserSessions signs a user out everywhere (admin only).
+func (h *AuthenticationHandler) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(h.DB, w, r) {
+		return
+	}
+
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid user ID"))
+		return
+	}
+
+	_, err = h.DB.ExecContext(r.Context(), "UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error revoking sessions", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
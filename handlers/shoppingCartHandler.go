@@ -5,11 +5,13 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"gocommerce/apperrors"
 	"gocommerce/constants"
+	"gocommerce/events"
+	"gocommerce/metrics"
 	"gocommerce/models"
-	"log"
+	"gocommerce/validation"
 	"net/http"
 	"strconv"
 
@@ -20,21 +22,34 @@ type ShoppingCartHandler struct {
 	DB *sql.DB
 }
 
+// maxCartQuantity caps how many of one product a cart can hold
+const maxCartQuantity = 1000
+
+// addCartItemRequest is the body for putting a product in the cart
+type addCartItemRequest struct {
+	ProductID int `json:"product_id" validate:"required,min=1"`
+	Quantity  int `json:"quantity" validate:"min=1,max=1000"`
+}
+
+// updateCartItemRequest is the body for changing a cart item's quantity
+type updateCartItemRequest struct {
+	Quantity int `json:"quantity" validate:"min=1,max=1000"`
+}
+
 func (h *ShoppingCartHandler) GetCart(w http.ResponseWriter, r *http.Request) {
 	// Extract the user ID from the context set by the AuthenticationMiddleware
 	ctx := r.Context()
 	userID, ok := ctx.Value(constants.UserIDKey).(int)
 	if !ok {
 		// Handle the case where the user ID is not set or is of the wrong type
-		http.Error(w, "Unauthorized or invalid user ID", http.StatusUnauthorized)
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
 		return
 	}
 
 	var cartItems []models.CartItem
-	rows, err := h.DB.Query("SELECT id, product_id, user_id, quantity FROM cart_items WHERE user_id = $1", userID)
+	rows, err := h.DB.QueryContext(r.Context(), "SELECT id, product_id, user_id, quantity FROM cart_items WHERE user_id = $1", userID)
 	if err != nil {
-		http.Error(w, "Server error", http.StatusInternalServerError)
-		log.Printf("Error fetching cart items: %v", err)
+		apperrors.Write(w, r, apperrors.Internal("Error fetching cart items", err))
 		return
 	}
 	defer rows.Close()
@@ -42,8 +57,7 @@ func (h *ShoppingCartHandler) GetCart(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var item models.CartItem
 		if err := rows.Scan(&item.ID, &item.ProductID, &item.UserID, &item.Quantity); err != nil {
-			http.Error(w, "Server error", http.StatusInternalServerError)
-			log.Printf("Error reading cart item: %v", err)
+			apperrors.Write(w, r, apperrors.Internal("Error reading cart item", err))
 			return
 		}
 		cartItems = append(cartItems, item)
@@ -51,8 +65,7 @@ func (h *ShoppingCartHandler) GetCart(w http.ResponseWriter, r *http.Request) {
 
 	// Check for any error encountered during iteration
 	if err = rows.Err(); err != nil {
-		http.Error(w, "Server error", http.StatusInternalServerError)
-		log.Printf("Error iterating cart items: %v", err)
+		apperrors.Write(w, r, apperrors.Internal("Error iterating cart items", err))
 		return
 	}
 
@@ -65,70 +78,97 @@ func (h *ShoppingCartHandler) AddItemToCart(w http.ResponseWriter, r *http.Reque
 	ctx := r.Context()
 	userID, ok := ctx.Value(constants.UserIDKey).(int)
 	if !ok {
-		http.Error(w, "Unauthorized or invalid user ID", http.StatusUnauthorized)
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
 		return
 	}
 
 	// Decode the request body to get cart item details
-	var item models.CartItem
-	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var item addCartItemRequest
+	if err := validation.Decode(w, r, &item); err != nil {
+		apperrors.Write(w, r, err)
 		return
 	}
 
-	// Validate the cart item
-	if err := h.validateCartItemDetails(item); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	// Check the product can be bought
+	exists, err := h.productExists(item.ProductID)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error checking product existence", err))
+		return
+	}
+	if !exists {
+		apperrors.Write(w, r, apperrors.InvalidField("product_id", "product does not exist"))
 		return
 	}
-	tx, err := h.DB.Begin()
+	tx, err := h.DB.BeginTx(r.Context(), nil)
 	if err != nil {
-		http.Error(w, "Server error", http.StatusInternalServerError)
-		log.Printf("Error starting transaction: %v", err)
+		apperrors.Write(w, r, apperrors.Internal("Error starting transaction", err))
 		return
 	}
 	defer tx.Rollback()
 
 	// Check if the item already exists in the cart
 	var existingQuantity int
-	err = tx.QueryRow("SELECT quantity FROM cart_items WHERE user_id = $1 AND product_id = $2", userID, item.ProductID).Scan(&existingQuantity)
+	err = tx.QueryRowContext(r.Context(), "SELECT quantity FROM cart_items WHERE user_id = $1 AND product_id = $2", userID, item.ProductID).Scan(&existingQuantity)
 
 	if err != nil && err != sql.ErrNoRows {
-		http.Error(w, "Server error", http.StatusInternalServerError)
-		log.Printf("Error checking for existing cart item: %v", err)
+		apperrors.Write(w, r, apperrors.Internal("Error checking for existing cart item", err))
 		return
 	}
 
+	var itemID int
+	newQuantity := item.Quantity
+	cartCreated := false
 	if err == sql.ErrNoRows {
+		// An item going into an empty cart starts a new cart
+		if err := tx.QueryRowContext(r.Context(), "SELECT NOT EXISTS(SELECT 1 FROM cart_items WHERE user_id = $1)", userID).Scan(&cartCreated); err != nil {
+			apperrors.Write(w, r, apperrors.Internal("Error checking cart", err))
+			return
+		}
+
 		// Item does not exist, insert a new one
-		_, err = tx.Exec("INSERT INTO cart_items (user_id, product_id, quantity) VALUES ($1, $2, $3)", userID, item.ProductID, item.Quantity)
+		err = tx.QueryRowContext(r.Context(), "INSERT INTO cart_items (user_id, product_id, quantity) VALUES ($1, $2, $3) RETURNING id", userID, item.ProductID, item.Quantity).Scan(&itemID)
 		if err != nil {
-			http.Error(w, "Server error", http.StatusInternalServerError)
-			log.Printf("Error adding item to cart: %v", err)
+			apperrors.Write(w, r, apperrors.Internal("Error adding item to cart", err))
 			return
 		}
 	} else {
 		// Item exists, update its quantity
-		newQuantity := existingQuantity + item.Quantity
-		_, err = tx.Exec("UPDATE cart_items SET quantity = $1 WHERE user_id = $2 AND product_id = $3", newQuantity, userID, item.ProductID)
+		newQuantity = existingQuantity + item.Quantity
+		if newQuantity > maxCartQuantity {
+			apperrors.Write(w, r, apperrors.InvalidField("quantity", fmt.Sprintf("cart can hold at most %d of a product", maxCartQuantity)))
+			return
+		}
+		err = tx.QueryRowContext(r.Context(), "UPDATE cart_items SET quantity = $1 WHERE user_id = $2 AND product_id = $3 RETURNING id", newQuantity, userID, item.ProductID).Scan(&itemID)
 		if err != nil {
-			http.Error(w, "Server error", http.StatusInternalServerError)
-			log.Printf("Error updating cart item: %v", err)
+			apperrors.Write(w, r, apperrors.Internal("Error updating cart item", err))
 			return
 		}
 	}
 
+	err = events.Record(tx, events.CartItemAdded, userID, events.CartItemPayload{
+		UserID:    userID,
+		ProductID: item.ProductID,
+		ItemID:    itemID,
+		Quantity:  newQuantity,
+	})
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error recording cart event", err))
+		return
+	}
+
 	if err := tx.Commit(); err != nil {
-		http.Error(w, "Server error", http.StatusInternalServerError)
-		log.Printf("Error committing transaction: %v", err)
+		apperrors.Write(w, r, apperrors.Internal("Error committing transaction", err))
 		return
 	}
+	if cartCreated {
+		metrics.CartsCreated.Inc()
+	}
+
 	// Query the updated cart
 	var updatedCartItems []models.CartItem
-	rows, err := h.DB.Query("SELECT product_id, quantity FROM cart_items WHERE user_id = $1", userID)
+	rows, err := h.DB.QueryContext(r.Context(), "SELECT product_id, quantity FROM cart_items WHERE user_id = $1", userID)
 	if err != nil {
-		http.Error(w, "Server error", http.StatusInternalServerError)
-		log.Printf("Error retrieving updated cart: %v", err)
+		apperrors.Write(w, r, apperrors.Internal("Error retrieving updated cart", err))
 		return
 	}
 	defer rows.Close()
@@ -136,8 +176,7 @@ func (h *ShoppingCartHandler) AddItemToCart(w http.ResponseWriter, r *http.Reque
 	for rows.Next() {
 		var cartItem models.CartItem
 		if err := rows.Scan(&cartItem.ProductID, &cartItem.Quantity); err != nil {
-			http.Error(w, "Server error", http.StatusInternalServerError)
-			log.Printf("Error scanning cart items: %v", err)
+			apperrors.Write(w, r, apperrors.Internal("Error scanning cart items", err))
 			return
 		}
 		updatedCartItems = append(updatedCartItems, cartItem)
@@ -145,8 +184,7 @@ func (h *ShoppingCartHandler) AddItemToCart(w http.ResponseWriter, r *http.Reque
 
 	// Check for errors from iterating over rows
 	if err := rows.Err(); err != nil {
-		http.Error(w, "Server error", http.StatusInternalServerError)
-		log.Printf("Error iterating over cart items: %v", err)
+		apperrors.Write(w, r, apperrors.Internal("Error iterating over cart items", err))
 		return
 	}
 
@@ -155,64 +193,64 @@ func (h *ShoppingCartHandler) AddItemToCart(w http.ResponseWriter, r *http.Reque
 	json.NewEncoder(w).Encode(updatedCartItems)
 }
 
-// validateCartItemDetails validates the details of a cart item.
-func (h *ShoppingCartHandler) validateCartItemDetails(item models.CartItem) error {
-	// Check if the product exists
-	exists, err := h.productExists(item.ProductID)
-	if err != nil {
-		return fmt.Errorf("error checking product existence: %w", err)
-	}
-	if !exists {
-		return errors.New("product does not exist")
-	}
-
-	// Validate the quantity
-	if !isValidQuantity(item.Quantity) {
-		return errors.New("invalid quantity")
-	}
-
-	// Add any additional validation rules as needed
-
-	return nil
-}
-
 // productExists checks if a product with the given ID exists in the database.
 func (h *ShoppingCartHandler) productExists(productID int) (bool, error) {
 	var exists bool
-	err := h.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM products WHERE id = $1)", productID).Scan(&exists)
+	err := h.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM products WHERE id = $1 AND deleted_at IS NULL)", productID).Scan(&exists)
 	return exists, err
 }
 
-// isValidQuantity checks if the provided quantity is positive and within acceptable limits.
-func isValidQuantity(quantity int) bool {
-	return quantity > 0 // Add more complex logic as needed, such as maximum limit checks
-}
-
 func (h *ShoppingCartHandler) UpdateCartItem(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	userID, ok := ctx.Value(constants.UserIDKey).(int)
 	if !ok {
 		// Handle the case where the user ID is not set or is of the wrong type
-		http.Error(w, "Unauthorized or invalid user ID", http.StatusUnauthorized)
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
 		return
 	}
 	vars := mux.Vars(r)
 	itemID, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid item ID"))
+		return
+	}
+
+	var item updateCartItemRequest
+	if err := validation.Decode(w, r, &item); err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	tx, err := h.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error starting transaction", err))
 		return
 	}
+	defer tx.Rollback()
 
-	var item models.CartItem
-	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var productID int
+	err = tx.QueryRowContext(r.Context(), "UPDATE cart_items SET quantity = $1 WHERE id = $2 AND user_id = $3 RETURNING product_id", item.Quantity, itemID, userID).Scan(&productID)
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.NotFound("Cart item not found"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error updating cart item", err))
 		return
 	}
 
-	_, err = h.DB.Exec("UPDATE cart_items SET quantity = $1 WHERE id = $2 AND user_id = $3", item.Quantity, itemID, userID)
+	err = events.Record(tx, events.CartItemUpdated, userID, events.CartItemPayload{
+		UserID:    userID,
+		ProductID: productID,
+		ItemID:    itemID,
+		Quantity:  item.Quantity,
+	})
 	if err != nil {
-		http.Error(w, "Server error", http.StatusInternalServerError)
-		log.Printf("Error updating cart item: %v", err)
+		apperrors.Write(w, r, apperrors.Internal("Error recording cart event", err))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error committing transaction", err))
 		return
 	}
 
@@ -226,7 +264,7 @@ func (h *ShoppingCartHandler) RemoveItemFromCart(w http.ResponseWriter, r *http.
 	ctx := r.Context()
 	userID, ok := ctx.Value(constants.UserIDKey).(int)
 	if !ok {
-		http.Error(w, "Unauthorized or invalid user ID", http.StatusUnauthorized)
+		apperrors.Write(w, r, apperrors.Unauthorized("Unauthorized or invalid user ID"))
 		return
 	}
 
@@ -234,15 +272,40 @@ func (h *ShoppingCartHandler) RemoveItemFromCart(w http.ResponseWriter, r *http.
 	vars := mux.Vars(r)
 	itemID, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid item ID"))
+		return
+	}
+
+	tx, err := h.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error starting transaction", err))
 		return
 	}
+	defer tx.Rollback()
 
 	// Delete the item from the database
-	_, err = h.DB.Exec("DELETE FROM cart_items WHERE id = $1 AND user_id = $2", itemID, userID)
+	var productID int
+	err = tx.QueryRowContext(r.Context(), "DELETE FROM cart_items WHERE id = $1 AND user_id = $2 RETURNING product_id", itemID, userID).Scan(&productID)
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.NotFound("Cart item not found"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error deleting cart item", err))
+		return
+	}
+
+	err = events.Record(tx, events.CartItemRemoved, userID, events.CartItemPayload{
+		UserID:    userID,
+		ProductID: productID,
+		ItemID:    itemID,
+	})
 	if err != nil {
-		http.Error(w, "Server error", http.StatusInternalServerError)
-		log.Printf("Error deleting cart item: %v", err)
+		apperrors.Write(w, r, apperrors.Internal("Error recording cart event", err))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error committing transaction", err))
 		return
 	}
 
@@ -3,30 +3,91 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"gocommerce/apperrors"
 	"gocommerce/models"
+	"gocommerce/notifications"
+	"gocommerce/validation"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
 
+// UserHandler manages any user by ID and is admin only. Users manage their own
+// account through the /api/me endpoints instead.
 type UserHandler struct {
-	DB *sql.DB
+	DB       *sql.DB
+	Notifier *notifications.Notifier
+}
+
+// createUserRequest is the body for an admin creating an account. Role defaults to customer.
+type createUserRequest struct {
+	Username string `json:"username" validate:"required,min=3,max=50,username"`
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Password string `json:"password" validate:"required,min=8,max=72"`
+	Role     string `json:"role" validate:"oneof=customer staff admin"`
+}
+
+// updateUserRequest is the body for an admin replacing an account's details. An
+// empty role leaves the role unchanged. A new email address goes through the
+// same verification as one the user sets themselves.
+type updateUserRequest struct {
+	Username string `json:"username" validate:"required,max=50,username"`
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Role     string `json:"role" validate:"oneof=customer staff admin"`
+}
+
+// updatedUser is an account as changed by an admin
+type updatedUser struct {
+	models.User
+	PendingEmail *string `json:"pending_email,omitempty"` // New address waiting for verification
+}
+
+// softDeleteUser marks a user deleted and signs them out everywhere as part of tx.
+// It returns sql.ErrNoRows if there is no such user or they are already deleted.
+func softDeleteUser(tx *sql.Tx, userID int) error {
+	// Soft delete so the user's orders keep a valid customer reference
+	result, err := tx.Exec(`UPDATE users SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return revokeSessions(tx, userID)
 }
 
 func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
-	var user models.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !requireAdmin(h.DB, w, r) {
 		return
 	}
 
-	// Insert the new user into the database.
-	// Here, you should also handle password hashing
-	sqlStatement := `INSERT INTO users (username, email, passwordhash) VALUES ($1, $2, $3) RETURNING id`
-	err := h.DB.QueryRow(sqlStatement, user.Username, user.Email, user.PasswordHash).Scan(&user.ID)
+	var req createUserRequest
+	if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	user := models.User{Username: strings.TrimSpace(req.Username), Email: normalizeEmail(req.Email), Role: req.Role}
+	if user.Role == "" {
+		user.Role = RoleCustomer
+	}
+
+	hashedPassword, err := hashPassword(req.Password)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("Error hashing password", err))
+		return
+	}
+
+	// Insert the new user into the database
+	sqlStatement := `INSERT INTO users (username, email, password_hash, role) VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at`
+	err = h.DB.QueryRowContext(r.Context(), sqlStatement, user.Username, user.Email, hashedPassword, user.Role).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	if isUniqueViolation(err) {
+		apperrors.Write(w, r, apperrors.Conflict("username or email already in use"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error creating user", err))
 		return
 	}
 
@@ -35,24 +96,38 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminOrKey(h.DB, w, r) {
+		return
+	}
+
 	vars := mux.Vars(r)
 	idStr := vars["id"]
 
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid user ID"))
 		return
 	}
 
+	withDeleted, ok := includeDeleted(h.DB, w, r)
+	if !ok {
+		return
+	}
+
+	query := "SELECT id, username, email, role, deleted_at FROM users WHERE id = $1"
+	if !withDeleted {
+		query += " AND deleted_at IS NULL"
+	}
+
 	var user models.User
-	row := h.DB.QueryRow("SELECT id, username, email FROM users WHERE id = $1", id)
+	row := h.DB.QueryRowContext(r.Context(), query, id)
 
-	err = row.Scan(&user.ID, &user.Username, &user.Email)
+	err = row.Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.DeletedAt)
 	if err == sql.ErrNoRows {
-		http.NotFound(w, r)
+		apperrors.Write(w, r, apperrors.NotFound("User not found"))
 		return
 	} else if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("Error fetching user", err))
 		return
 	}
 
@@ -60,48 +135,184 @@ func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(h.DB, w, r) {
+		return
+	}
+
 	vars := mux.Vars(r)
 	idStr := vars["id"]
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid user ID"))
 		return
 	}
 
-	var updated models.User
-	if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var req updateUserRequest
+	if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
 		return
 	}
+	email := normalizeEmail(req.Email)
 
-	// Here, add logic to handle password updates if necessary (e.g., hashing)
-
-	sqlStatement := `UPDATE users SET username = $1, email = $2, passwordhash = $3 WHERE id = $4`
-	_, err = h.DB.Exec(sqlStatement, updated.Username, updated.Email, updated.PasswordHash, id)
+	tx, err := h.DB.BeginTx(r.Context(), nil)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("Error starting transaction", err))
+		return
+	}
+	defer tx.Rollback()
+
+	// Passwords aren't changed here; users change their own through /api/me/password
+	// or the reset flow, so an admin never needs to know one. Neither is the email,
+	// which only changes once the new address is verified.
+	var updated updatedUser
+	sqlStatement := `UPDATE users SET username = $1, role = COALESCE(NULLIF($2, ''), role), updated_at = NOW()
+		WHERE id = $3 AND deleted_at IS NULL
+		RETURNING id, username, email, pending_email, role, created_at, updated_at`
+	err = tx.QueryRowContext(r.Context(), sqlStatement, strings.TrimSpace(req.Username), req.Role, id).
+		Scan(&updated.ID, &updated.Username, &updated.Email, &updated.PendingEmail, &updated.Role, &updated.CreatedAt, &updated.UpdatedAt)
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.NotFound("User not found"))
+		return
+	} else if isUniqueViolation(err) {
+		apperrors.Write(w, r, apperrors.Conflict("username already in use"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error updating user", err))
+		return
+	}
+
+	var verificationToken string
+	emailChanged := email != updated.Email && (updated.PendingEmail == nil || email != *updated.PendingEmail)
+	if emailChanged {
+		verificationToken, err = requestEmailChange(tx, id, email)
+		if err == errEmailTaken {
+			apperrors.Write(w, r, apperrors.Conflict("email already in use"))
+			return
+		} else if err != nil {
+			apperrors.Write(w, r, apperrors.Internal("Error saving pending email", err))
+			return
+		}
+		updated.PendingEmail = &email
+	}
+
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error committing transaction", err))
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	if emailChanged {
+		if err := sendVerificationLink(h.Notifier, updated.Username, email, verificationToken); err != nil {
+			apperrors.Write(w, r, apperrors.Internal("Error sending verification email", err))
+			return
+		}
+		// The new address isn't in effect until the link sent to it is followed
+		w.WriteHeader(http.StatusAccepted)
+	}
 	json.NewEncoder(w).Encode(updated)
 }
 
 func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(h.DB, w, r) {
+		return
+	}
+
 	vars := mux.Vars(r)
 	idStr := vars["id"]
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid user ID"))
 		return
 	}
 
-	sqlStatement := `DELETE FROM users WHERE id = $1`
-	_, err = h.DB.Exec(sqlStatement, id)
+	tx, err := h.DB.BeginTx(r.Context(), nil)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("Error starting transaction", err))
+		return
+	}
+	defer tx.Rollback()
+
+	err = softDeleteUser(tx, id)
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.NotFound("User not found"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error deleting user", err))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error committing transaction", err))
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (h *UserHandler) GetUsers(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminOrKey(h.DB, w, r) {
+		return
+	}
+
+	withDeleted, ok := includeDeleted(h.DB, w, r)
+	if !ok {
+		return
+	}
+
+	query := "SELECT id, username, email, role, deleted_at FROM users"
+	if !withDeleted {
+		query += " WHERE deleted_at IS NULL"
+	}
+	rows, err := h.DB.QueryContext(r.Context(), query+" ORDER BY id")
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("GetUsers: Error executing query", err))
+		return
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.Role, &u.DeletedAt); err != nil {
+			apperrors.Write(w, r, apperrors.Internal("GetUsers: Error scanning row", err))
+			return
+		}
+		users = append(users, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("GetUsers: Error iterating over rows", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+func (h *UserHandler) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(h.DB, w, r) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid user ID"))
+		return
+	}
+
+	var user models.User
+	sqlStatement := `UPDATE users SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL RETURNING id, username, email, role`
+	err = h.DB.QueryRowContext(r.Context(), sqlStatement, id).Scan(&user.ID, &user.Username, &user.Email, &user.Role)
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.NotFound("User not found"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error restoring user", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
@@ -0,0 +1,357 @@
+// webhooksHandler.go
+
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"gocommerce/apperrors"
+	"gocommerce/events"
+	"gocommerce/models"
+	"gocommerce/validation"
+	"gocommerce/webhooks"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// WebhookHandler manages webhook subscriptions. Every endpoint is admin only.
+type WebhookHandler struct {
+	DB *sql.DB
+}
+
+// webhookRequest is the body for creating or replacing a subscription. Active
+// defaults to true. A missing secret is generated on create and left alone on update.
+type webhookRequest struct {
+	URL        string   `json:"url" validate:"required,url,max=2048"`
+	Secret     string   `json:"secret" validate:"min=16,max=255"`
+	EventTypes []string `json:"event_types" validate:"required,max=50"`
+	Active     *bool    `json:"active"`
+}
+
+// Validate checks the URL may receive deliveries and each event type is a known
+// type or "*" for all of them.
+func (req *webhookRequest) Validate() []apperrors.FieldError {
+	var errs []apperrors.FieldError
+	if err := webhooks.ValidateURL(req.URL); err != nil {
+		errs = append(errs, apperrors.FieldError{Field: "url", Message: "must be an https URL on a public host"})
+	}
+	for i, eventType := range req.EventTypes {
+		if eventType != "*" && !slices.Contains(events.Types, eventType) {
+			errs = append(errs, apperrors.FieldError{
+				Field:   fmt.Sprintf("event_types[%d]", i),
+				Message: "must be \"*\" or one of: " + strings.Join(events.Types, ", "),
+			})
+		}
+	}
+	return errs
+}
+
+// webhookDeliveryDetail is a delivery together with every attempt made to send it
+type webhookDeliveryDetail struct {
+	Delivery models.WebhookDelivery          `json:"delivery"`
+	Attempts []models.WebhookDeliveryAttempt `json:"attempts"`
+}
+
+// subscription returns the subscription the request describes
+func (req *webhookRequest) subscription() models.WebhookSubscription {
+	sub := models.WebhookSubscription{URL: req.URL, Secret: req.Secret, EventTypes: req.EventTypes, Active: true}
+	if req.Active != nil {
+		sub.Active = *req.Active
+	}
+	return sub
+}
+
+// generateWebhookSecret returns a random secret used to sign deliveries.
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+func (h *WebhookHandler) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminOrKey(h.DB, w, r) {
+		return
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(), "SELECT id, url, event_types, active, created_at, updated_at FROM webhook_subscriptions ORDER BY id")
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error fetching webhooks", err))
+		return
+	}
+	defer rows.Close()
+
+	subs := []models.WebhookSubscription{}
+	for rows.Next() {
+		var sub models.WebhookSubscription
+		if err := rows.Scan(&sub.ID, &sub.URL, pq.Array(&sub.EventTypes), &sub.Active, &sub.CreatedAt, &sub.UpdatedAt); err != nil {
+			apperrors.Write(w, r, apperrors.Internal("Error reading webhook", err))
+			return
+		}
+		subs = append(subs, sub)
+	}
+
+	if err := rows.Err(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error iterating webhooks", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subs)
+}
+
+func (h *WebhookHandler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminOrKey(h.DB, w, r) {
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid webhook ID"))
+		return
+	}
+
+	var sub models.WebhookSubscription
+	err = h.DB.QueryRowContext(r.Context(), "SELECT id, url, event_types, active, created_at, updated_at FROM webhook_subscriptions WHERE id = $1", id).
+		Scan(&sub.ID, &sub.URL, pq.Array(&sub.EventTypes), &sub.Active, &sub.CreatedAt, &sub.UpdatedAt)
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.NotFound("Webhook not found"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error fetching webhook", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sub)
+}
+
+func (h *WebhookHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminOrKey(h.DB, w, r) {
+		return
+	}
+
+	var req webhookRequest
+	if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	sub := req.subscription()
+
+	if sub.Secret == "" {
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			apperrors.Write(w, r, apperrors.Internal("Error generating webhook secret", err))
+			return
+		}
+		sub.Secret = secret
+	}
+
+	sqlStatement := `INSERT INTO webhook_subscriptions (url, secret, event_types, active) VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at`
+	err := h.DB.QueryRowContext(r.Context(), sqlStatement, sub.URL, sub.Secret, pq.Array(sub.EventTypes), sub.Active).Scan(&sub.ID, &sub.CreatedAt, &sub.UpdatedAt)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error creating webhook", err))
+		return
+	}
+
+	// The secret is only ever shown here so the receiver can be configured
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sub)
+}
+
+func (h *WebhookHandler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminOrKey(h.DB, w, r) {
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid webhook ID"))
+		return
+	}
+
+	var req webhookRequest
+	if err := validation.Decode(w, r, &req); err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	updated := req.subscription()
+
+	// An empty secret keeps the current one
+	sqlStatement := `UPDATE webhook_subscriptions SET url = $1, event_types = $2, active = $3,
+		secret = COALESCE(NULLIF($4, ''), secret), updated_at = NOW() WHERE id = $5
+		RETURNING id, created_at, updated_at`
+	err = h.DB.QueryRowContext(r.Context(), sqlStatement, updated.URL, pq.Array(updated.EventTypes), updated.Active, updated.Secret, id).
+		Scan(&updated.ID, &updated.CreatedAt, &updated.UpdatedAt)
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.NotFound("Webhook not found"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error updating webhook", err))
+		return
+	}
+
+	updated.Secret = ""
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+func (h *WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminOrKey(h.DB, w, r) {
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid webhook ID"))
+		return
+	}
+
+	result, err := h.DB.ExecContext(r.Context(), "DELETE FROM webhook_subscriptions WHERE id = $1", id)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error deleting webhook", err))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apperrors.Write(w, r, apperrors.NotFound("Webhook not found"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetWebhookDeliveries returns the delivery log of a subscription, newest first.
+// ?status=pending|succeeded|dead filters the list.
+func (h *WebhookHandler) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminOrKey(h.DB, w, r) {
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid webhook ID"))
+		return
+	}
+
+	query := `SELECT id, subscription_id, event_id, event_type, status, attempts, next_attempt_at,
+		last_response_code, last_error, created_at, delivered_at
+		FROM webhook_deliveries WHERE subscription_id = $1`
+	args := []interface{}{id}
+	if status := r.URL.Query().Get("status"); status != "" {
+		query += " AND status = $2"
+		args = append(args, status)
+	}
+	query += " ORDER BY id DESC LIMIT 100"
+
+	rows, err := h.DB.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error fetching webhook deliveries", err))
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		var d models.WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &d.Status, &d.Attempts, &d.NextAttemptAt,
+			&d.LastResponseCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt); err != nil {
+			apperrors.Write(w, r, apperrors.Internal("Error reading webhook delivery", err))
+			return
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error iterating webhook deliveries", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// GetWebhookDelivery returns a single delivery together with every attempt made for it.
+func (h *WebhookHandler) GetWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminOrKey(h.DB, w, r) {
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid delivery ID"))
+		return
+	}
+
+	var d models.WebhookDelivery
+	err = h.DB.QueryRowContext(r.Context(), `SELECT id, subscription_id, event_id, event_type, status, attempts, next_attempt_at,
+		last_response_code, last_error, created_at, delivered_at FROM webhook_deliveries WHERE id = $1`, id).
+		Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &d.Status, &d.Attempts, &d.NextAttemptAt,
+			&d.LastResponseCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+	if err == sql.ErrNoRows {
+		apperrors.Write(w, r, apperrors.NotFound("Webhook not found"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error fetching webhook delivery", err))
+		return
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(), `SELECT attempt, response_code, error, duration_ms, attempted_at
+		FROM webhook_delivery_attempts WHERE delivery_id = $1 ORDER BY id`, id)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error fetching webhook delivery attempts", err))
+		return
+	}
+	defer rows.Close()
+
+	attempts := []models.WebhookDeliveryAttempt{}
+	for rows.Next() {
+		var a models.WebhookDeliveryAttempt
+		if err := rows.Scan(&a.Attempt, &a.ResponseCode, &a.Error, &a.DurationMs, &a.AttemptedAt); err != nil {
+			apperrors.Write(w, r, apperrors.Internal("Error reading webhook delivery attempt", err))
+			return
+		}
+		attempts = append(attempts, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error iterating webhook delivery attempts", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhookDeliveryDetail{Delivery: d, Attempts: attempts})
+}
+
+// RedeliverWebhook queues a delivery to be sent again, including dead ones.
+func (h *WebhookHandler) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminOrKey(h.DB, w, r) {
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.BadRequest("Invalid delivery ID"))
+		return
+	}
+
+	if err := webhooks.Redeliver(h.DB, id); err == webhooks.ErrDeliveryNotFound {
+		apperrors.Write(w, r, apperrors.NotFound("Webhook not found"))
+		return
+	} else if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error redelivering webhook", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Delivery queued"})
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestCreateWebhookTargets checks API keys, which may manage webhooks, can't
+// point deliveries at internal services. These are rejected before the database
+// is touched.
+func TestCreateWebhookTargets(t *testing.T) {
+	h := &WebhookHandler{}
+	for _, target := range []string{
+		"http://hooks.example.com/gocommerce",
+		"https://localhost/hook",
+		"https://10.0.0.5/hook",
+		"https://169.254.169.254/latest/meta-data/",
+	} {
+		w := httptest.NewRecorder()
+		body := `{"url":"` + target + `","event_types":["*"]}`
+		h.CreateWebhook(w, withAPIKey(httptest.NewRequest(http.MethodPost, "/api/v1/webhooks", strings.NewReader(body))))
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"field":"url"`) {
+			t.Errorf("Creating a webhook for %s answered %d: %s", target, w.Code, w.Body)
+		}
+	}
+}
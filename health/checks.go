@@ -0,0 +1,82 @@
+// health/checks.go
+
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"gocommerce/config"
+	"time"
+)
+
+// SchemaVersion is the latest init-db migration this code expects. Bump it
+// together with each new migration.
+const SchemaVersion = 16
+
+// outboxMaxLag is how long a due outbox event may wait before the API reports
+// itself unready
+var outboxMaxLag = config.Duration("OUTBOX_MAX_LAG", 5*time.Minute)
+
+// NewChecker returns the readiness checks for the API.
+func NewChecker(db *sql.DB, workers *Workers) *Checker {
+	return &Checker{
+		Checks: []Check{
+			{Name: "database", Run: DatabaseCheck(db)},
+			{Name: "migrations", Run: MigrationCheck(db, SchemaVersion)},
+			{Name: "workers", Run: workers.Check},
+			{Name: "outbox", Run: OutboxCheck(db, outboxMaxLag)},
+		},
+		Timeout: config.Duration("READINESS_TIMEOUT", 2*time.Second),
+	}
+}
+
+// DatabaseCheck pings the database and reports connection pool usage.
+func DatabaseCheck(db *sql.DB) func(ctx context.Context) (map[string]interface{}, error) {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		stats := db.Stats()
+		details := map[string]interface{}{
+			"open_connections": stats.OpenConnections,
+			"in_use":           stats.InUse,
+		}
+		return details, db.PingContext(ctx)
+	}
+}
+
+// MigrationCheck fails unless the latest applied migration is at least expected.
+func MigrationCheck(db *sql.DB, expected int) func(ctx context.Context) (map[string]interface{}, error) {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		var version int
+		if err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+			return nil, err
+		}
+		details := map[string]interface{}{"version": version, "expected": expected}
+		if version < expected {
+			return details, fmt.Errorf("schema version %d is behind %d", version, expected)
+		}
+		return details, nil
+	}
+}
+
+// OutboxCheck fails when the oldest due outbox event has been waiting longer than
+// maxLag, which means events aren't being dispatched.
+func OutboxCheck(db *sql.DB, maxLag time.Duration) func(ctx context.Context) (map[string]interface{}, error) {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		var pending, failed int
+		var lagSeconds float64
+		err := db.QueryRowContext(ctx, `SELECT
+				COUNT(*) FILTER (WHERE dispatched_at IS NULL AND failed_at IS NULL),
+				COUNT(*) FILTER (WHERE failed_at IS NOT NULL),
+				COALESCE(EXTRACT(EPOCH FROM NOW() - MIN(next_attempt_at)
+					FILTER (WHERE dispatched_at IS NULL AND failed_at IS NULL AND next_attempt_at <= NOW())), 0)
+			FROM outbox_events`).Scan(&pending, &failed, &lagSeconds)
+		if err != nil {
+			return nil, err
+		}
+		details := map[string]interface{}{"pending": pending, "failed": failed, "lag_seconds": lagSeconds}
+		if lag := time.Duration(lagSeconds * float64(time.Second)); lag > maxLag {
+			return details, fmt.Errorf("outbox is %v behind", lag.Round(time.Second))
+		}
+		return details, nil
+	}
+}
@@ -0,0 +1,47 @@
+package health
+
+import (
+	"context"
+	"gocommerce/dbtest"
+	"testing"
+	"time"
+)
+
+func TestMigrationCheck(t *testing.T) {
+	db := dbtest.Open(t)
+
+	if details, err := MigrationCheck(db, SchemaVersion)(context.Background()); err != nil || details["version"] != SchemaVersion {
+		t.Errorf("MigrationCheck(%d) = %v, %v; want init-db to be up to date", SchemaVersion, details, err)
+	}
+	if _, err := MigrationCheck(db, SchemaVersion+1)(context.Background()); err == nil {
+		t.Error("MigrationCheck passes with a missing migration")
+	}
+}
+
+func TestOutboxCheck(t *testing.T) {
+	db := dbtest.Open(t)
+	check := OutboxCheck(db, time.Minute)
+
+	if _, err := check(context.Background()); err != nil {
+		t.Errorf("Empty outbox fails: %v", err)
+	}
+
+	// Events that are dispatched, failed or not yet due don't count as lag
+	_, err := db.Exec(`INSERT INTO outbox_events (event_type, aggregate_id, payload, next_attempt_at, dispatched_at, failed_at) VALUES
+		('order.created', 1, '{}', NOW() - INTERVAL '1 hour', NOW(), NULL),
+		('order.created', 2, '{}', NOW() - INTERVAL '1 hour', NULL, NOW()),
+		('order.created', 3, '{}', NOW() + INTERVAL '1 hour', NULL, NULL)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details, err := check(context.Background()); err != nil || details["pending"] != 1 || details["failed"] != 1 {
+		t.Errorf("OutboxCheck() = %v, %v; want healthy with one pending and one failed", details, err)
+	}
+
+	if _, err := db.Exec(`INSERT INTO outbox_events (event_type, aggregate_id, payload, next_attempt_at) VALUES ('order.created', 4, '{}', NOW() - INTERVAL '5 minutes')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := check(context.Background()); err == nil {
+		t.Error("OutboxCheck passes with an event five minutes overdue")
+	}
+}
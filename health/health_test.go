@@ -0,0 +1,66 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func check(name string, details map[string]interface{}, err error) Check {
+	return Check{Name: name, Run: func(context.Context) (map[string]interface{}, error) { return details, err }}
+}
+
+func TestCheckerRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		checks     []Check
+		wantStatus string
+	}{
+		{"no checks", nil, StatusOK},
+		{"all pass", []Check{check("a", nil, nil), check("b", map[string]interface{}{"n": 1}, nil)}, StatusOK},
+		{"one fails", []Check{check("a", nil, nil), check("b", nil, errors.New("down"))}, StatusFail},
+		{"all fail", []Check{check("a", nil, errors.New("down")), check("b", nil, errors.New("down"))}, StatusFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := (&Checker{Checks: tt.checks, Timeout: time.Second}).Run(context.Background())
+			if report.Status != tt.wantStatus || len(report.Checks) != len(tt.checks) {
+				t.Fatalf("Report = %+v, want %s with %d checks", report, tt.wantStatus, len(tt.checks))
+			}
+			for _, c := range tt.checks {
+				details, err := c.Run(context.Background())
+				result := report.Checks[c.Name]
+				wantStatus, wantError := StatusOK, ""
+				if err != nil {
+					wantStatus, wantError = StatusFail, err.Error()
+				}
+				if result.Status != wantStatus || result.Error != wantError || len(result.Details) != len(details) {
+					t.Errorf("Check %s = %+v, want %s %q with %v", c.Name, result, wantStatus, wantError, details)
+				}
+			}
+		})
+	}
+}
+
+// TestCheckerTimeout checks a hung dependency fails its check instead of hanging
+// the probe, and doesn't hold up the other checks
+func TestCheckerTimeout(t *testing.T) {
+	hung := Check{Name: "hung", Run: func(ctx context.Context) (map[string]interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}
+	checker := &Checker{Checks: []Check{hung, check("fast", nil, nil)}, Timeout: 50 * time.Millisecond}
+
+	start := time.Now()
+	report := checker.Run(context.Background())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Run() took %v with a %v timeout", elapsed, checker.Timeout)
+	}
+	if report.Status != StatusFail || report.Checks["hung"].Status != StatusFail || report.Checks["fast"].Status != StatusOK {
+		t.Errorf("Report = %+v, want only the hung check failed", report)
+	}
+	if report.Checks["hung"].Error != context.DeadlineExceeded.Error() {
+		t.Errorf("Hung check error = %q", report.Checks["hung"].Error)
+	}
+}
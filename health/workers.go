@@ -0,0 +1,89 @@
+// health/workers.go
+
+package health
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Workers tracks background workers. A worker is healthy while it is running and,
+// if it has a heartbeat, has beaten recently.
+type Workers struct {
+	mu      sync.Mutex
+	workers map[string]*workerState
+}
+
+type workerState struct {
+	running    bool
+	maxSilence time.Duration // 0 means the worker has no heartbeat
+	lastBeat   time.Time
+}
+
+func NewWorkers() *Workers {
+	return &Workers{workers: make(map[string]*workerState)}
+}
+
+// Started marks a worker as running. A worker that polls should call Beat at
+// least every maxSilence; pass 0 for workers that only wait on a channel.
+func (w *Workers) Started(name string, maxSilence time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.workers[name] = &workerState{running: true, maxSilence: maxSilence, lastBeat: time.Now()}
+}
+
+// Stopped marks a worker as no longer running.
+func (w *Workers) Stopped(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if state, ok := w.workers[name]; ok {
+		state.running = false
+	}
+}
+
+// Beat records that a worker is still making progress.
+func (w *Workers) Beat(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if state, ok := w.workers[name]; ok {
+		state.lastBeat = time.Now()
+	}
+}
+
+// Heartbeat returns a function that beats for name, for a worker's Heartbeat field.
+func (w *Workers) Heartbeat(name string) func() {
+	return func() { w.Beat(name) }
+}
+
+// Check fails if any worker has stopped or gone quiet. The details give each
+// worker's state and seconds since its last heartbeat.
+func (w *Workers) Check(ctx context.Context) (map[string]interface{}, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	details := make(map[string]interface{}, len(w.workers))
+	var unhealthy []string
+	for name, state := range w.workers {
+		status := StatusOK
+		silence := time.Since(state.lastBeat)
+		if !state.running || (state.maxSilence > 0 && silence > state.maxSilence) {
+			status = StatusFail
+			unhealthy = append(unhealthy, name)
+		}
+		worker := map[string]interface{}{"status": status, "running": state.running}
+		if state.maxSilence > 0 {
+			worker["seconds_since_heartbeat"] = silence.Round(time.Millisecond).Seconds()
+		}
+		details[name] = worker
+	}
+
+	if len(unhealthy) > 0 {
+		sort.Strings(unhealthy)
+		return details, fmt.Errorf("unhealthy workers: %s", strings.Join(unhealthy, ", "))
+	}
+	return details, nil
+}
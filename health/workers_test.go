@@ -0,0 +1,60 @@
+package health
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWorkersCheck(t *testing.T) {
+	workers := NewWorkers()
+	workers.Started("outbox", time.Minute)
+	workers.Started("webhooks", time.Minute)
+	workers.Started("events", 0)
+
+	if details, err := workers.Check(context.Background()); err != nil || len(details) != 3 {
+		t.Fatalf("Check() = %v, %v; want all healthy", details, err)
+	}
+	if _, ok := workerDetails(t, workers, "events")["seconds_since_heartbeat"]; ok {
+		t.Error("A worker without a heartbeat reports seconds_since_heartbeat")
+	}
+
+	// Both workers with a heartbeat go quiet; only a beat brings one back
+	workers.mu.Lock()
+	for _, name := range []string{"outbox", "webhooks"} {
+		workers.workers[name].lastBeat = time.Now().Add(-2 * time.Minute)
+	}
+	workers.mu.Unlock()
+	workers.Heartbeat("outbox")()
+
+	_, err := workers.Check(context.Background())
+	if err == nil || err.Error() != "unhealthy workers: webhooks" {
+		t.Errorf("Check() error = %v, want the stale worker", err)
+	}
+	if worker := workerDetails(t, workers, "webhooks"); worker["status"] != StatusFail || worker["seconds_since_heartbeat"].(float64) < 120 {
+		t.Errorf("Stale worker details = %v", worker)
+	}
+	if worker := workerDetails(t, workers, "outbox"); worker["status"] != StatusOK {
+		t.Errorf("Worker that beat has details %v", worker)
+	}
+
+	// Stopping a worker fails the check even without a heartbeat, and names come back sorted
+	workers.Stopped("events")
+	_, err = workers.Check(context.Background())
+	if err == nil || err.Error() != "unhealthy workers: events, webhooks" {
+		t.Errorf("Check() error = %v, want both unhealthy workers", err)
+	}
+	if worker := workerDetails(t, workers, "events"); worker["status"] != StatusFail || worker["running"] != false {
+		t.Errorf("Stopped worker details = %v", worker)
+	}
+}
+
+func workerDetails(t *testing.T, workers *Workers, name string) map[string]interface{} {
+	t.Helper()
+	details, _ := workers.Check(context.Background())
+	worker, ok := details[name].(map[string]interface{})
+	if !ok {
+		t.Fatalf("No details for %s in %v", name, details)
+	}
+	return worker
+}
@@ -0,0 +1,12 @@
+-- 03_soft_deletes.sql
+ALTER TABLE products ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE orders ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+-- Roles gate admin-only operations such as listing deleted rows and restoring them
+ALTER TABLE users ADD COLUMN role VARCHAR(32) NOT NULL DEFAULT 'customer'
+    CHECK (role IN ('customer', 'staff', 'admin'));
+
+CREATE INDEX idx_products_deleted_at ON products (deleted_at);
+CREATE INDEX idx_users_deleted_at ON users (deleted_at);
+CREATE INDEX idx_orders_deleted_at ON orders (deleted_at);
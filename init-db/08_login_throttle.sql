@@ -0,0 +1,11 @@
+-- 08_login_throttle.sql
+-- Failed login counters. scope is 'account' (keyed by lowercased username,
+-- whether or not it exists) or 'ip' (keyed by client address).
+CREATE TABLE login_throttles (
+    scope VARCHAR(16) NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    failures INT NOT NULL DEFAULT 0,
+    last_failed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (scope, key)
+);
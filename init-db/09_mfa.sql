@@ -0,0 +1,15 @@
+-- 09_mfa.sql
+ALTER TABLE users ADD COLUMN totp_secret VARCHAR(64);                 -- Set at enrollment, kept while MFA is enabled
+ALTER TABLE users ADD COLUMN mfa_enabled_at TIMESTAMP WITH TIME ZONE; -- NULL until enrollment is confirmed
+ALTER TABLE users ADD COLUMN totp_last_step BIGINT;                   -- Last accepted time step, so a code can't be replayed
+
+-- Only the SHA-256 hash of each single-use recovery code is stored
+CREATE TABLE mfa_recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id),
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_mfa_recovery_codes_user_id ON mfa_recovery_codes (user_id);
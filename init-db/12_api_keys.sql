@@ -0,0 +1,14 @@
+-- 12_api_keys.sql
+-- Scoped keys for server-to-server integrations. Only the SHA-256 hash of a key is stored.
+CREATE TABLE api_keys (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    prefix VARCHAR(16) NOT NULL, -- Start of the key, shown in listings
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    created_by INT REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE, -- Set on rotation so the old key keeps working for a grace period
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
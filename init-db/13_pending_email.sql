@@ -0,0 +1,3 @@
+-- 13_pending_email.sql
+-- A new email address requested through PATCH /api/me. It replaces users.email once verified.
+ALTER TABLE users ADD COLUMN pending_email VARCHAR(255);
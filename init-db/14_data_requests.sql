@@ -0,0 +1,25 @@
+-- 14_data_requests.sql
+-- Data export and erasure requests, processed in the background. status moves
+-- pending -> processing -> completed or failed; completed exports become expired
+-- once their download is removed.
+CREATE TABLE data_requests (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id),
+    request_type VARCHAR(20) NOT NULL CHECK (request_type IN ('export', 'erasure')),
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'processing', 'completed', 'failed', 'expired')),
+    requested_by INT REFERENCES users(id), -- The admin who filed it, NULL when the user asked themselves
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    file_name VARCHAR(255),                 -- Export bundle under EXPORT_DIR
+    requested_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP WITH TIME ZONE,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE    -- When an export's download is removed
+);
+
+CREATE INDEX idx_data_requests_status ON data_requests (status, requested_at);
+CREATE INDEX idx_data_requests_user_id ON data_requests (user_id);
+
+-- Erased accounts keep their row so orders still point at a customer, but hold no personal data
+ALTER TABLE users ADD COLUMN anonymized_at TIMESTAMP WITH TIME ZONE;
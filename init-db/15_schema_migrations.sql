@@ -0,0 +1,26 @@
+-- 15_schema_migrations.sql
+-- Records which init-db migrations have been applied, so the readiness check can
+-- tell whether the database matches the code. Every later migration ends by
+-- inserting its own row.
+CREATE TABLE schema_migrations (
+    version INT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    applied_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO schema_migrations (version, name) VALUES
+    (1, '01_schema'),
+    (2, '02_seed'),
+    (3, '03_soft_deletes'),
+    (4, '04_outbox'),
+    (5, '05_webhooks'),
+    (6, '06_password_reset'),
+    (7, '07_email_verification'),
+    (8, '08_login_throttle'),
+    (9, '09_mfa'),
+    (10, '10_sessions'),
+    (11, '11_oidc'),
+    (12, '12_api_keys'),
+    (13, '13_pending_email'),
+    (14, '14_data_requests'),
+    (15, '15_schema_migrations');
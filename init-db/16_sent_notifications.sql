@@ -0,0 +1,11 @@
+-- 16_sent_notifications.sql
+-- One row per email sent for an outbox event. The bus re-runs every subscriber
+-- when any one of them fails, so the notifier checks here before sending again.
+CREATE TABLE sent_notifications (
+    event_id BIGINT NOT NULL REFERENCES outbox_events(id),
+    template VARCHAR(50) NOT NULL,
+    sent_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (event_id, template)
+);
+
+INSERT INTO schema_migrations (version, name) VALUES (16, '16_sent_notifications');
@@ -0,0 +1,52 @@
+// logging/logging.go
+
+// Package logging sets up the application's structured logger. Output is JSON by
+// default, the level comes from LOG_LEVEL, and tokens, passwords and email
+// addresses are redacted before anything is written.
+package logging
+
+import (
+	"context"
+	"gocommerce/config"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+type contextKey struct{}
+
+// New returns a logger writing to w, configured from LOG_LEVEL (debug, info, warn
+// or error) and LOG_FORMAT (json or text).
+func New(w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{
+		Level:       parseLevel(config.String("LOG_LEVEL", "info")),
+		ReplaceAttr: redactAttr,
+	}
+	if strings.EqualFold(config.String("LOG_FORMAT", "json"), "text") {
+		return slog.New(slog.NewTextHandler(w, opts))
+	}
+	return slog.New(slog.NewJSONHandler(w, opts))
+}
+
+func parseLevel(s string) slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		slog.Warn("Invalid LOG_LEVEL, using info", "value", s)
+		return slog.LevelInfo
+	}
+	return level
+}
+
+// NewContext returns a copy of ctx carrying logger.
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger stored in ctx by the request middleware, which
+// tags every line with the request ID, or the default logger outside a request.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,62 @@
+// logging/redact.go
+
+package logging
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeyParts mark attributes whose value is never logged, wherever they
+// appear in the key (e.g. "password", "new_password", "refresh_token")
+var sensitiveKeyParts = []string{"password", "token", "secret", "authorization", "cookie", "api_key", "email"}
+
+// sensitiveValues catches secrets inside free text such as messages and errors:
+// JWTs, API keys, bearer credentials and email addresses
+var sensitiveValues = regexp.MustCompile(`eyJ[\w-]+\.[\w-]+\.[\w-]*` +
+	`|gck_[\w-]+` +
+	`|(?i:bearer|apikey)\s+\S+` +
+	`|[\w.+-]+@[\w-]+(\.[\w-]+)+`)
+
+// Redact replaces secrets and email addresses in s.
+func Redact(s string) string {
+	return sensitiveValues.ReplaceAllString(s, redacted)
+}
+
+func sensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	// One-time MFA and recovery codes
+	if key == "code" {
+		return true
+	}
+	for _, part := range sensitiveKeyParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactAttr is the handlers' ReplaceAttr hook. It runs on every attribute,
+// including the message.
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if a.Key != slog.MessageKey && sensitiveKey(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		if s := a.Value.String(); sensitiveValues.MatchString(s) {
+			return slog.String(a.Key, Redact(s))
+		}
+	case slog.KindAny:
+		// Errors often wrap the input that caused them
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, Redact(err.Error()))
+		}
+	}
+	return a
+}
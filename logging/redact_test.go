@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedactAttr(t *testing.T) {
+	tests := []struct {
+		name string
+		attr slog.Attr
+		want string
+	}{
+		{"password", slog.String("password", "hunter2"), redacted},
+		{"key containing a sensitive part", slog.String("New_Password", "hunter2"), redacted},
+		{"refresh token", slog.String("refresh_token", "abc"), redacted},
+		{"non-string sensitive value", slog.Int("api_key_id", 7), redacted},
+		{"MFA code", slog.String("code", "123456"), redacted},
+		{"key merely containing code", slog.String("status_code", "200"), "200"},
+		{"email in a value", slog.String("detail", "sent to ada@example.com"), "sent to " + redacted},
+		{"JWT in a value", slog.String("header", "eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiIxIn0.c2ln"), redacted},
+		{"bearer credential", slog.String("auth_header", "Bearer abc.def"), redacted},
+		{"API key in a value", slog.String("arg", "key gck_live_1234"), "key " + redacted},
+		{"error wrapping input", slog.Any("error", fmt.Errorf("no user %s: %w", "ada@example.com", errors.New("not found"))), "no user " + redacted + ": not found"},
+		{"plain value", slog.String("path", "/api/v1/products"), "/api/v1/products"},
+		{"number", slog.Int("status", 404), "404"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := redactAttr(nil, tt.attr)
+			if got.Key != tt.attr.Key || got.Value.String() != tt.want {
+				t.Errorf("redactAttr(%s) = %s, want %s=%s", tt.attr, got, tt.attr.Key, tt.want)
+			}
+		})
+	}
+
+	// The message is scanned for secrets, but its key isn't treated as one
+	msg := redactAttr(nil, slog.String(slog.MessageKey, "Reset link sent to ada@example.com"))
+	if msg.Value.String() != "Reset link sent to "+redacted {
+		t.Errorf("Message = %q", msg.Value)
+	}
+}
+
+func TestNewRedacts(t *testing.T) {
+	for _, format := range []string{"json", "text"} {
+		t.Run(format, func(t *testing.T) {
+			t.Setenv("LOG_FORMAT", format)
+			var buf bytes.Buffer
+			logger := New(&buf)
+			logger.Info("Login for ada@example.com", "password", "hunter2", slog.Group("request", "token", "abc123", "path", "/login"))
+
+			out := buf.String()
+			for _, secret := range []string{"ada@example.com", "hunter2", "abc123"} {
+				if strings.Contains(out, secret) {
+					t.Errorf("Log line leaks %q: %s", secret, out)
+				}
+			}
+			if !strings.Contains(out, "/login") {
+				t.Errorf("Log line lost a plain attribute: %s", out)
+			}
+		})
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := map[string]slog.Level{
+		"debug": slog.LevelDebug,
+		"WARN":  slog.LevelWarn,
+		"error": slog.LevelError,
+		"loud":  slog.LevelInfo,
+	}
+	for s, want := range tests {
+		if got := parseLevel(s); got != want {
+			t.Errorf("parseLevel(%q) = %s, want %s", s, got, want)
+		}
+	}
+}
@@ -1,88 +1,230 @@
-package main
-
-import (
-	"database/sql"
-	"fmt"
-	"gocommerce/handlers"
-	"gocommerce/middleware"
-	"gocommerce/routes"
-	"log"
-	"net/http"
-	"time"
-
-	"github.com/gorilla/mux"
-	_ "github.com/lib/pq"
-)
-
-// Assuming you have set the connection string as an environment variable or in your code
-const (
-	host     = "postgres"
-	port     = 5432 // Default port for PostgreSQL
-	user     = "mydbuser"
-	password = "supersecret"
-	dbname   = "gocommerce"
-)
-
-var db *sql.DB
-
-func connectToDatabase(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("postgres", dsn)
-	if err != nil {
-		return nil, err
-	}
-	err = db.Ping()
-	if err != nil {
-		return nil, err
-	}
-	return db, nil
-}
-
-func initializeDatabase(maxRetries int, delay time.Duration, dsn string) *sql.DB {
-	var err error
-
-	for i := 0; i < maxRetries; i++ {
-		db, err = connectToDatabase(dsn)
-		if err == nil {
-			log.Println("Successfully connected to the database.")
-			return db
-		}
-
-		log.Printf("Failed to connect to database: %v. Retrying in %v...\n", err, delay)
-		time.Sleep(delay)
-	}
-
-	log.Fatalf("Could not connect to the database after %d attempts: %v", maxRetries, err)
-	return nil
-}
-
-func recoverHandler(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		defer func() {
-			if err := recover(); err != nil {
-				log.Printf("Recovered from panic: %s", err)
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			}
-		}()
-		next.ServeHTTP(w, r)
-	})
-}
-
-func main() {
-
-	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		host, port, user, password, dbname)
-	db = initializeDatabase(5, 10*time.Second, dsn)
-	defer db.Close()
-
-	r := mux.NewRouter()
-	r.Use(recoverHandler)
-	r.Use(middleware.AuthenticationMiddleware)
-
-	allHandlers := handlers.NewHandlers(db)
-	routes.RegisterAll(r, allHandlers)
-
-	// Start server
-	http.ListenAndServe(":8088", r)
-	log.Println("Server starting on :8088...")
-	log.Fatal(http.ListenAndServe(":8088", nil))
-}
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"gocommerce/apiversion"
+	"gocommerce/apperrors"
+	"gocommerce/events"
+	"gocommerce/handlers"
+	"gocommerce/health"
+	"gocommerce/logging"
+	"gocommerce/metrics"
+	"gocommerce/middleware"
+	"gocommerce/notifications"
+	"gocommerce/openapi"
+	"gocommerce/privacy"
+	"gocommerce/routes"
+	"gocommerce/tokens"
+	"gocommerce/tracing"
+	"gocommerce/webhooks"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Assuming you have set the connection string as an environment variable or in your code
+const (
+	host     = "postgres"
+	port     = 5432 // Default port for PostgreSQL
+	user     = "mydbuser"
+	password = "supersecret"
+	dbname   = "gocommerce"
+)
+
+var db *sql.DB
+
+// tracedDriverName is the Postgres driver wrapped so every query gets a span
+const tracedDriverName = "postgres+otel"
+
+func init() {
+	tracing.RegisterDriver(tracedDriverName, &pq.Driver{})
+}
+
+func connectToDatabase(dsn string) (*sql.DB, error) {
+	db, err := sql.Open(tracedDriverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Ping()
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func initializeDatabase(maxRetries int, delay time.Duration, dsn string) *sql.DB {
+	var err error
+
+	for i := 0; i < maxRetries; i++ {
+		db, err = connectToDatabase(dsn)
+		if err == nil {
+			slog.Info("Successfully connected to the database")
+			return db
+		}
+
+		slog.Warn("Failed to connect to database, retrying", "error", err, "retry_in", delay)
+		time.Sleep(delay)
+	}
+
+	slog.Error("Could not connect to the database", "attempts", maxRetries, "error", err)
+	os.Exit(1)
+	return nil
+}
+
+func recoverHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				apperrors.Write(w, r, apperrors.Internal("Recovered from panic", fmt.Errorf("%v\n%s", err, debug.Stack())))
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// runWorker starts run in a goroutine tracked by wg and reports it to the readiness
+// check under name. maxSilence is how long the worker may go between heartbeats.
+func runWorker(ctx context.Context, wg *sync.WaitGroup, workerHealth *health.Workers, name string, maxSilence time.Duration, run func(context.Context)) {
+	wg.Add(1)
+	workerHealth.Started(name, maxSilence)
+	go func() {
+		defer wg.Done()
+		defer workerHealth.Stopped(name)
+		run(ctx)
+	}()
+}
+
+// waitTimeout waits for wg and reports whether it finished within timeout.
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func main() {
+	// Everything, including the standard log package, goes through the structured logger
+	slog.SetDefault(logging.New(os.Stdout))
+
+	shutdownTracing, err := tracing.Init(context.Background())
+	if err != nil {
+		slog.Error("Could not set up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		host, port, user, password, dbname)
+	db = initializeDatabase(5, 10*time.Second, dsn)
+	metrics.RegisterDB(db)
+
+	// Cancelled on SIGINT or SIGTERM to start the shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Workers get their own contexts so they keep running while the server drains,
+	// and pick up whatever the last requests recorded. Mail stops last because the
+	// other workers queue it.
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	mailCtx, stopMail := context.WithCancel(context.Background())
+	var workers, mailWorkers sync.WaitGroup
+	workerHealth := health.NewWorkers()
+
+	// Deliver domain events recorded in the outbox to in-process subscribers
+	bus := events.NewBus()
+	dispatcher := events.NewDispatcher(db, bus)
+	dispatcher.Heartbeat = workerHealth.Heartbeat("outbox_dispatcher")
+	runWorker(workerCtx, &workers, workerHealth, "outbox_dispatcher", time.Minute, dispatcher.Run)
+
+	// Fan events out to webhook subscribers and deliver them
+	webhookWorker := webhooks.NewWorker(db)
+	bus.SubscribeAll(webhookWorker.HandleEvent)
+	// A batch of slow subscribers can take several minutes to get through
+	webhookWorker.Heartbeat = workerHealth.Heartbeat("webhooks")
+	runWorker(workerCtx, &workers, workerHealth, "webhooks", 10*time.Minute, webhookWorker.Run)
+
+	// Send transactional emails in the background
+	mailer, err := notifications.NewMailerFromEnv()
+	if err != nil {
+		slog.Error("Could not configure mailer", "error", err)
+		os.Exit(1)
+	}
+	mailQueue := notifications.NewQueue(mailer, 1000)
+	notifier := notifications.NewNotifier(db, mailQueue)
+	bus.SubscribeAll(notifier.HandleEvent)
+	// The mail queue waits on a channel rather than polling, so it has no heartbeat
+	runWorker(mailCtx, &mailWorkers, workerHealth, "mail_queue", 0, mailQueue.Run)
+
+	// Process data export and erasure requests
+	privacyWorker := privacy.NewWorker(db)
+	privacyWorker.Heartbeat = workerHealth.Heartbeat("data_requests")
+	runWorker(workerCtx, &workers, workerHealth, "data_requests", privacyWorker.Lease, privacyWorker.Run)
+
+	// Load the JWT signing keys shared by the handlers and the authentication middleware
+	if err := tokens.Init(); err != nil {
+		slog.Error("Could not load signing keys", "error", err)
+		os.Exit(1)
+	}
+
+	r := mux.NewRouter()
+	r.NotFoundHandler = apperrors.NotFoundHandler()
+	r.MethodNotAllowedHandler = apperrors.MethodNotAllowedHandler()
+	r.Use(metrics.Middleware)
+	r.Use(tracing.Middleware)
+	r.Use(recoverHandler)
+	r.Use(apiversion.Middleware)
+	r.Use(middleware.AuthenticationMiddleware(db))
+
+	allHandlers := handlers.NewHandlers(db, notifier, workerHealth)
+	routes.RegisterAll(r, allHandlers)
+	if undocumented := openapi.Undocumented(r, handlers.APIDocs); len(undocumented) > 0 {
+		slog.Warn("Routes missing from the OpenAPI document", "routes", undocumented)
+	}
+
+	// Start server and block until it is told to stop or fails. Request IDs wrap the
+	// whole router so requests that match no route are tagged and logged too, and
+	// unversioned paths are rewritten to v1 before the router sees them.
+	serverConfig := loadServerConfig()
+	serveErr := serve(ctx, newServer(serverConfig, middleware.RequestID(apiversion.Aliases(r))), serverConfig)
+	if serveErr != nil {
+		slog.Error("Server error", "error", serveErr)
+	}
+
+	// Let the workers finish what they are doing before the database goes away
+	stopWorkers()
+	if !waitTimeout(&workers, serverConfig.ShutdownTimeout) {
+		slog.Warn("Timed out waiting for background workers")
+	}
+	stopMail()
+	if !waitTimeout(&mailWorkers, serverConfig.ShutdownTimeout) {
+		slog.Warn("Timed out waiting for the mail queue")
+	}
+
+	db.Close()
+	// Flush the spans still buffered for export
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("Error flushing traces", "error", err)
+	}
+	cancel()
+	if serveErr != nil {
+		os.Exit(1)
+	}
+	slog.Info("Shutdown complete")
+}
@@ -0,0 +1,130 @@
+// metrics/metrics.go
+
+// Package metrics defines the Prometheus metrics the API exports on /metrics:
+// HTTP latency and concurrency per route, database pool statistics and business
+// counters.
+package metrics
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"gocommerce/apperrors"
+	"gocommerce/config"
+	"log/slog"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gocommerce"
+
+// Login failure reasons
+const (
+	LoginInvalidCredentials = "invalid_credentials"
+	LoginLockedOut          = "locked_out"
+	LoginInvalidMFACode     = "invalid_mfa_code"
+)
+
+// Registry holds every metric the API exports. It is separate from the global
+// registry so only metrics registered here are exposed.
+var Registry = prometheus.NewRegistry()
+
+var (
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route template, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	requestsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests currently being served, by route template.",
+	}, []string{"route"})
+
+	// OrdersPlaced counts orders created
+	OrdersPlaced = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "orders_placed_total",
+		Help:      "Orders placed.",
+	})
+
+	// Revenue sums the total price of placed orders
+	Revenue = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "revenue_total",
+		Help:      "Total price of placed orders.",
+	})
+
+	// CartsCreated counts items added to an empty cart
+	CartsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "carts_created_total",
+		Help:      "Carts created, counted when an item is added to an empty cart.",
+	})
+
+	// LoginFailures counts rejected logins by reason
+	LoginFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_failures_total",
+		Help:      "Rejected login attempts by reason.",
+	}, []string{"reason"})
+
+	// DeprecatedRequests counts requests to deprecated endpoints, so their remaining
+	// callers can be chased before the sunset
+	DeprecatedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "deprecated_requests_total",
+		Help:      "Requests to deprecated endpoints by route template as called.",
+	}, []string{"route"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requestDuration,
+		requestsInFlight,
+		OrdersPlaced,
+		Revenue,
+		CartsCreated,
+		LoginFailures,
+		DeprecatedRequests,
+	)
+	// Start the labelled series at zero so they show up before the first failure
+	for _, reason := range []string{LoginInvalidCredentials, LoginLockedOut, LoginInvalidMFACode} {
+		LoginFailures.WithLabelValues(reason)
+	}
+}
+
+// RegisterDB exports the connection pool statistics from db.Stats().
+func RegisterDB(db *sql.DB) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, namespace))
+}
+
+// Handler serves the registry in the Prometheus text format. Scrapers must send
+// METRICS_TOKEN as a bearer token. The metrics include revenue, so without a
+// token the endpoint refuses every request unless METRICS_PUBLIC is set, which
+// is meant for local development.
+func Handler() http.Handler {
+	token := config.String("METRICS_TOKEN", "")
+	public := config.Bool("METRICS_PUBLIC", false)
+	if token == "" && !public {
+		slog.Warn("METRICS_TOKEN is not set, /metrics is disabled")
+	}
+	handler := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" && !public {
+			apperrors.Write(w, r, apperrors.Forbidden("Metrics are disabled until METRICS_TOKEN is set"))
+			return
+		}
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			apperrors.Write(w, r, apperrors.Unauthorized("Missing or invalid metrics token"))
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
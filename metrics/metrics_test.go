@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		public        string
+		authorization string
+		wantStatus    int
+	}{
+		{"no token configured", "", "", "", http.StatusForbidden},
+		{"no token configured ignores credentials", "", "", "Bearer anything", http.StatusForbidden},
+		{"public for development", "", "true", "", http.StatusOK},
+		{"right token", "s3cret", "", "Bearer s3cret", http.StatusOK},
+		{"missing token", "s3cret", "", "", http.StatusUnauthorized},
+		{"wrong token", "s3cret", "", "Bearer guess", http.StatusUnauthorized},
+		{"token without the scheme", "s3cret", "", "s3cret", http.StatusUnauthorized},
+		{"token still needed when public", "s3cret", "true", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("METRICS_TOKEN", tt.token)
+			t.Setenv("METRICS_PUBLIC", tt.public)
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			Handler().ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Answered %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if exposed := strings.Contains(w.Body.String(), "gocommerce_revenue_total"); exposed != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("Revenue exposed = %v with status %d", exposed, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestMiddleware(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(Middleware)
+	router.HandleFunc("/api/v1/orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["id"] == "404" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	requestDuration.Reset()
+	for _, path := range []string{"/api/v1/orders/1", "/api/v1/orders/2", "/api/v1/orders/404"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	w := httptest.NewRecorder()
+	promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	scrape := w.Body.String()
+
+	// Every ID shares the template's series; only the status splits them
+	for _, want := range []string{
+		`gocommerce_http_request_duration_seconds_count{method="GET",route="/api/v1/orders/{id}",status="200"} 2`,
+		`gocommerce_http_request_duration_seconds_count{method="GET",route="/api/v1/orders/{id}",status="404"} 1`,
+		`gocommerce_http_requests_in_flight{route="/api/v1/orders/{id}"} 0`,
+	} {
+		if !strings.Contains(scrape, want) {
+			t.Errorf("Scrape is missing %s", want)
+		}
+	}
+	if strings.Contains(scrape, `route="/api/v1/orders/1"`) {
+		t.Error("Requests are labelled with the raw path")
+	}
+}
@@ -2,96 +2,191 @@ package middleware
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"gocommerce/apikeys"
+	"gocommerce/apiversion"
+	"gocommerce/apperrors"
 	"gocommerce/constants"
-	"log"
+	"gocommerce/cookies"
+	"gocommerce/logging"
+	"gocommerce/tokens"
 	"net/http"
-	"os"
 	"strings"
-
-	"github.com/dgrijalva/jwt-go"
+	"time"
 )
 
-var jwtSecretKey = []byte(os.Getenv("JWT_SECRET_KEY"))
+// publicPaths can be called without a token
+var publicPaths = map[string]bool{
+	"/.well-known/jwks.json":       true,
+	"/healthz":                     true,
+	"/metrics":                     true, // Protected by METRICS_TOKEN instead, and closed when none is set
+	"/readyz":                      true,
+	"/api/auth/register":           true,
+	"/api/auth/login":              true,
+	"/api/auth/refresh":            true, // Authenticated by the refresh token in the body or cookie
+	"/api/auth/logout":             true, // Only clears cookies, so it works with an expired access token
+	"/api/auth/forgot-password":    true,
+	"/api/auth/reset-password":     true,
+	"/api/auth/verify":             true,
+	"/api/auth/mfa/verify":         true,
+	"/api/auth/mfa/enroll":         true,
+	"/api/auth/mfa/enroll/confirm": true,
+	"/api/openapi.json":            true,
+	"/api/docs":                    true,
+}
+
+// publicPrefixes are path prefixes that can be called without a token
+var publicPrefixes = []string{
+	"/api/auth/oidc/", // Social login redirects
+}
 
-// getUserIDFromToken decodes the JWT token and extracts the user ID.
-func getUserIDFromToken(tokenString string) (int, error) {
-	// Parse the token
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Validate the signing method is what you expect
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+// IsPublicPath reports whether path can be called without credentials. The rules
+// apply to every version of the API.
+func IsPublicPath(path string) bool {
+	path = apiversion.Unversioned(path)
+	if publicPaths[path] {
+		return true
+	}
+	for _, prefix := range publicPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
 		}
+	}
+	return false
+}
 
-		return jwtSecretKey, nil
-	})
+// getUserIDFromToken decodes the JWT token and extracts the user and session IDs.
+func getUserIDFromToken(tokenString string) (int, int, error) {
+	// Parse the token; signature, issuer, audience and time claims are checked
+	// with some leeway for clock skew, and only access tokens are accepted
+	claims, err := tokens.ParseClaims(tokenString, tokens.TypeAccess)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error parsing token: %w", err)
+	}
 
+	// Extract user ID from the sub claim
+	userID, err := claims.UserID()
 	if err != nil {
-		return 0, fmt.Errorf("error parsing token: %w", err)
+		return 0, 0, fmt.Errorf("error extracting user ID from token: %w", err)
 	}
 
-	// Check if token is valid
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		return 0, fmt.Errorf("invalid token or cannot convert claims")
+	return userID, claims.SessionID, nil
+}
+
+// sessionTouchInterval limits how often a session's last_used_at is written
+const sessionTouchInterval = time.Minute
+
+// checkSession returns an error unless sessionID is a live session of userID,
+// and records that the session was used.
+func checkSession(ctx context.Context, db *sql.DB, userID, sessionID int) error {
+	var lastUsedAt time.Time
+	err := db.QueryRowContext(ctx, `SELECT last_used_at FROM sessions
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()`, sessionID, userID).Scan(&lastUsedAt)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("session %d has been revoked or has expired", sessionID)
+	} else if err != nil {
+		return err
 	}
 
-	// Extract user ID from token claims
-	userID, ok := claims["user_id"].(float64) // JWT stores numbers as float64
-	if !ok {
-		return 0, fmt.Errorf("error extracting user ID from token, type assertion failed: user_id is type %T", claims["user_id"])
+	if time.Since(lastUsedAt) > sessionTouchInterval {
+		if _, err := db.ExecContext(ctx, "UPDATE sessions SET last_used_at = NOW() WHERE id = $1", sessionID); err != nil {
+			logging.FromContext(ctx).Error("Error updating session last used time", "error", err)
+		}
 	}
+	return nil
+}
 
-	return int(userID), nil
+// AuthenticationMiddleware authenticates requests to non-public paths and adds the
+// user and session IDs to the request context.
+func AuthenticationMiddleware(db *sql.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return authenticate(db, next)
+	}
 }
 
-func AuthenticationMiddleware(next http.Handler) http.Handler {
+func authenticate(db *sql.DB, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Log the request path
-		log.Printf("Processing request for %s", r.URL.Path)
+		logger := logging.FromContext(r.Context())
 
 		// Skip middleware for certain routes
-		if r.URL.Path == "/api/auth/register" || r.URL.Path == "/api/auth/login" {
+		if IsPublicPath(r.URL.Path) {
 			next.ServeHTTP(w, r)
 			return
 		}
 
+		// Integrations authenticate with an API key instead of a token
+		if apiKey := apikeys.FromRequest(r); apiKey != "" {
+			authenticateAPIKey(db, w, r, next, apiKey)
+			return
+		}
+
 		// Try to get the token from the Authorization header
 		tokenString := getTokenFromHeader(r)
 
-		// Log if the token was found in the header
-		if tokenString != "" {
-			log.Println("Token found in header")
-		} else {
-			log.Println("Token not found in header, checking cookie")
+		if tokenString == "" {
 			// If not found in the header, try to get it from a cookie
 			tokenString = getTokenFromCookie(r)
 
-			if tokenString != "" {
-				log.Println("Token found in cookie")
-			} else {
-				log.Println("Token not found in cookie")
+			// Browsers attach cookies to cross-site requests, so state-changing
+			// requests must also prove they can read the CSRF cookie
+			if tokenString != "" && !cookies.SafeMethod(r.Method) && !cookies.ValidCSRF(r) {
+				logger.Warn("Missing or invalid CSRF token", "method", r.Method, "path", r.URL.Path)
+				apperrors.Write(w, r, apperrors.Forbidden("Invalid CSRF token").WithCode("invalid_csrf_token"))
+				return
 			}
 		}
 
 		// Decode token and get user ID
-		userID, err := getUserIDFromToken(tokenString)
+		userID, sessionID, err := getUserIDFromToken(tokenString)
 		if err != nil {
-			// Log the error
-			log.Printf("Error decoding token: %v", err)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			logger.Info("Rejected access token", "error", err)
+			apperrors.Write(w, r, apperrors.Unauthorized("Missing or invalid credentials"))
 			return
 		}
 
-		// Log user ID
-		log.Printf("Authenticated user ID: %v", userID)
+		// Access tokens die with their session, even before they expire
+		if err := checkSession(r.Context(), db, userID, sessionID); err != nil {
+			logger.Info("Rejected session", "error", err)
+			apperrors.Write(w, r, apperrors.Unauthorized("Missing or invalid credentials"))
+			return
+		}
 
-		// Add user ID to context and proceed
+		// Add user and session IDs to context, and tag later log lines with the user, and proceed
 		ctx := context.WithValue(r.Context(), constants.UserIDKey, userID)
+		ctx = context.WithValue(ctx, constants.SessionIDKey, sessionID)
+		ctx = logging.NewContext(ctx, logger.With("user_id", userID))
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// authenticateAPIKey serves requests made with an API key whose scopes cover the request.
+func authenticateAPIKey(db *sql.DB, w http.ResponseWriter, r *http.Request, next http.Handler, apiKey string) {
+	logger := logging.FromContext(r.Context())
+	key, err := apikeys.Authenticate(r.Context(), db, apiKey)
+	if err == apikeys.ErrInvalidKey {
+		logger.Info("Rejected API key")
+		apperrors.Write(w, r, apperrors.Unauthorized("Missing or invalid credentials"))
+		return
+	} else if err != nil {
+		logger.Error("Error authenticating API key", "error", err)
+		apperrors.Write(w, r, apperrors.Unauthorized("Missing or invalid credentials"))
+		return
+	}
+
+	scope, ok := apikeys.RequiredScope(r.Method, r.URL.Path)
+	if !ok || !key.Allows(scope) {
+		logger.Warn("API key is not allowed to make this request",
+			"api_key_id", key.ID, "api_key_name", key.Name, "method", r.Method, "path", r.URL.Path)
+		apperrors.Write(w, r, apperrors.Forbidden("API key is not allowed to make this request"))
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), constants.APIKeyIDKey, key.ID)
+	ctx = logging.NewContext(ctx, logger.With("api_key_id", key.ID))
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
 func getTokenFromHeader(r *http.Request) string {
 	bearerToken := r.Header.Get("Authorization")
 	// Format: "Bearer <token>"
@@ -101,11 +196,7 @@ func getTokenFromHeader(r *http.Request) string {
 	return ""
 }
 
-// getTokenFromCookie extracts the token from a specific cookie
+// getTokenFromCookie extracts the token from the access token cookie
 func getTokenFromCookie(r *http.Request) string {
-	cookie, err := r.Cookie("token_cookie_name") // Replace with your token cookie's name
-	if err != nil {
-		return ""
-	}
-	return cookie.Value
+	return cookies.Value(r, cookies.AccessCookieName)
 }
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// DataRequest is a user's request to export or erase their personal data
+type DataRequest struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id"`
+	Type        string     `json:"type"`   // "export" or "erasure"
+	Status      string     `json:"status"` // pending, processing, completed, failed or expired
+	RequestedBy *int       `json:"requested_by,omitempty"`
+	Attempts    int        `json:"attempts"`
+	LastError   *string    `json:"last_error,omitempty"`
+	RequestedAt time.Time  `json:"requested_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"` // When an export's download is removed
+	DownloadURL string     `json:"download_url,omitempty"`
+}
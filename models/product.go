@@ -1,8 +1,11 @@
 package models
 
+import "time"
+
 // Product structure
 type Product struct {
-	ID    int     `json:"id"`
-	Name  string  `json:"name"`
-	Price float64 `json:"price"`
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Price     float64    `json:"price"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"` // Set when the product has been soft deleted
 }
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// WebhookSubscription is an external endpoint that receives signed event deliveries
+type WebhookSubscription struct {
+	ID         int       `json:"id"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"secret,omitempty"` // Only returned when the subscription is created
+	EventTypes []string  `json:"event_types"`      // Event types to deliver, or "*" for all
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// WebhookDelivery tracks sending one event to one subscription
+type WebhookDelivery struct {
+	ID               int64      `json:"id"`
+	SubscriptionID   int        `json:"subscription_id"`
+	EventID          int64      `json:"event_id"`
+	EventType        string     `json:"event_type"`
+	Status           string     `json:"status"`
+	Attempts         int        `json:"attempts"`
+	NextAttemptAt    time.Time  `json:"next_attempt_at"`
+	LastResponseCode *int       `json:"last_response_code,omitempty"`
+	LastError        *string    `json:"last_error,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	DeliveredAt      *time.Time `json:"delivered_at,omitempty"`
+}
+
+// WebhookDeliveryAttempt is one HTTP request made for a delivery
+type WebhookDeliveryAttempt struct {
+	Attempt      int       `json:"attempt"`
+	ResponseCode *int      `json:"response_code,omitempty"`
+	Error        *string   `json:"error,omitempty"`
+	DurationMs   int       `json:"duration_ms"`
+	AttemptedAt  time.Time `json:"attempted_at"`
+}
@@ -0,0 +1,43 @@
+// notifications/mailer.go
+
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"gocommerce/config"
+)
+
+// Message is a rendered email ready to send
+type Message struct {
+	To       string
+	Subject  string
+	TextBody string
+	HTMLBody string
+}
+
+// Mailer sends a single email.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewMailerFromEnv builds the mailer selected by MAILER: "smtp", "file" (the
+// default, writes .eml files to MAIL_DIR) or "memory".
+func NewMailerFromEnv() (Mailer, error) {
+	switch kind := config.String("MAILER", "file"); kind {
+	case "smtp":
+		return &SMTPMailer{
+			Host:     config.String("SMTP_HOST", "localhost"),
+			Port:     config.Int("SMTP_PORT", 587),
+			Username: config.String("SMTP_USERNAME", ""),
+			Password: config.String("SMTP_PASSWORD", ""),
+			From:     config.String("MAIL_FROM", "gocommerce <no-reply@gocommerce.local>"),
+		}, nil
+	case "file":
+		return &FileMailer{Dir: config.String("MAIL_DIR", "mail"), From: config.String("MAIL_FROM", "no-reply@gocommerce.local")}, nil
+	case "memory":
+		return &MemoryMailer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", kind)
+	}
+}
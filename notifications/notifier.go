@@ -0,0 +1,121 @@
+// notifications/notifier.go
+
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"gocommerce/config"
+	"gocommerce/events"
+	"log/slog"
+)
+
+// Notifier renders transactional emails and queues them for sending.
+type Notifier struct {
+	DB      *sql.DB
+	Queue   *Queue
+	BaseURL string // Front end URL used to build links in emails
+}
+
+func NewNotifier(db *sql.DB, queue *Queue) *Notifier {
+	return &Notifier{
+		DB:      db,
+		Queue:   queue,
+		BaseURL: config.String("APP_BASE_URL", "http://localhost:3000"),
+	}
+}
+
+// Send renders the named template and queues it for delivery to `to`.
+func (n *Notifier) Send(template, to string, data map[string]interface{}) error {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	if _, ok := data["BaseURL"]; !ok {
+		data["BaseURL"] = n.BaseURL
+	}
+
+	msg, err := Render(template, to, data)
+	if err != nil {
+		return err
+	}
+	return n.Queue.Enqueue(msg)
+}
+
+// HandleEvent is an events.Handler that sends customer emails for domain events.
+func (n *Notifier) HandleEvent(ctx context.Context, e events.Event) error {
+	switch e.Type {
+	case events.UserRegistered:
+		var p events.UserRegisteredPayload
+		if err := e.Decode(&p); err != nil {
+			return err
+		}
+		return n.sendOnce(ctx, e, TemplateWelcome, p.Email, map[string]interface{}{"Username": p.Username})
+
+	case events.OrderPlaced:
+		var p events.OrderPlacedPayload
+		if err := e.Decode(&p); err != nil {
+			return err
+		}
+		username, email, err := n.lookupUser(ctx, p.CustomerID)
+		if err == sql.ErrNoRows {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return n.sendOnce(ctx, e, TemplateOrderConfirmation, email, map[string]interface{}{
+			"Username":   username,
+			"OrderID":    p.OrderID,
+			"TotalPrice": p.TotalPrice,
+			"Status":     p.Status,
+		})
+
+	case events.OrderStatusChanged:
+		var p events.OrderStatusChangedPayload
+		if err := e.Decode(&p); err != nil {
+			return err
+		}
+		if p.NewStatus != "shipped" {
+			return nil
+		}
+		username, email, err := n.lookupUser(ctx, p.CustomerID)
+		if err == sql.ErrNoRows {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return n.sendOnce(ctx, e, TemplateShipment, email, map[string]interface{}{
+			"Username": username,
+			"OrderID":  p.OrderID,
+		})
+	}
+	return nil
+}
+
+// sendOnce sends the email for event e unless it has been queued already. The
+// event comes back whenever another subscriber fails, so without this a failing
+// webhook would resend the email on every retry.
+func (n *Notifier) sendOnce(ctx context.Context, e events.Event, template, to string, data map[string]interface{}) error {
+	result, err := n.DB.ExecContext(ctx, `INSERT INTO sent_notifications (event_id, template) VALUES ($1, $2)
+		ON CONFLICT (event_id, template) DO NOTHING`, e.ID, template)
+	if err != nil {
+		return err
+	}
+	if sent, _ := result.RowsAffected(); sent == 0 {
+		return nil
+	}
+
+	if err := n.Send(template, to, data); err != nil {
+		// Forget the event so the retry sends it
+		if _, delErr := n.DB.ExecContext(ctx, `DELETE FROM sent_notifications WHERE event_id = $1 AND template = $2`, e.ID, template); delErr != nil {
+			slog.Error("Error forgetting unsent notification", "event_id", e.ID, "template", template, "error", delErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (n *Notifier) lookupUser(ctx context.Context, userID int) (string, string, error) {
+	var username, email string
+	err := n.DB.QueryRowContext(ctx, "SELECT username, email FROM users WHERE id = $1 AND deleted_at IS NULL", userID).Scan(&username, &email)
+	return username, email, err
+}
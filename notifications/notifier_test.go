@@ -0,0 +1,94 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gocommerce/dbtest"
+	"gocommerce/events"
+	"testing"
+)
+
+// recordEvent stores an outbox event and returns it as the dispatcher hands it out
+func recordEvent(t *testing.T, n *Notifier, eventType string, payload interface{}) events.Event {
+	t.Helper()
+	body, _ := json.Marshal(payload)
+	e := events.Event{Type: eventType, Payload: body}
+	err := n.DB.QueryRow(`INSERT INTO outbox_events (event_type, aggregate_id, payload) VALUES ($1, 0, $2) RETURNING id`,
+		eventType, body).Scan(&e.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestHandleEvent(t *testing.T) {
+	db := dbtest.Open(t)
+	mailer := &MemoryMailer{}
+	n := NewNotifier(db, NewQueue(mailer, 10))
+	ctx := context.Background()
+
+	var customerID int
+	if err := db.QueryRow(`INSERT INTO users (username, email, password_hash) VALUES ('ada', 'ada@example.com', 'x') RETURNING id`).Scan(&customerID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		event   events.Event
+		wantSub string
+	}{
+		{"welcome", recordEvent(t, n, events.UserRegistered, events.UserRegisteredPayload{Username: "ada", Email: "ada@example.com"}), subjects[TemplateWelcome]},
+		{"order confirmation", recordEvent(t, n, events.OrderPlaced, events.OrderPlacedPayload{OrderID: 1, CustomerID: customerID, TotalPrice: 10, Status: "pending"}), subjects[TemplateOrderConfirmation]},
+		{"shipment", recordEvent(t, n, events.OrderStatusChanged, events.OrderStatusChangedPayload{OrderID: 1, CustomerID: customerID, OldStatus: "paid", NewStatus: "shipped"}), subjects[TemplateShipment]},
+		{"other status changes", recordEvent(t, n, events.OrderStatusChanged, events.OrderStatusChangedPayload{OrderID: 1, CustomerID: customerID, OldStatus: "pending", NewStatus: "paid"}), ""},
+		{"deleted customer", recordEvent(t, n, events.OrderPlaced, events.OrderPlacedPayload{OrderID: 2, CustomerID: customerID + 1}), ""},
+		{"events without an email", recordEvent(t, n, events.ProductCreated, events.ProductCreatedPayload{ProductID: 1}), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The bus redelivers an event whenever another subscriber fails
+			for i := 0; i < 3; i++ {
+				if err := n.HandleEvent(ctx, tt.event); err != nil {
+					t.Fatal(err)
+				}
+			}
+			n.Queue.drain()
+
+			sent := mailer.Messages()
+			mailer.messages = nil
+			if tt.wantSub == "" {
+				if len(sent) != 0 {
+					t.Errorf("Sent %d emails, want none", len(sent))
+				}
+				return
+			}
+			if len(sent) != 1 || sent[0].Subject != tt.wantSub || sent[0].To != "ada@example.com" {
+				t.Errorf("Sent %+v, want one %q email", sent, tt.wantSub)
+			}
+		})
+	}
+}
+
+// TestHandleEventRetriesUnsent checks an email the queue couldn't take is sent
+// when the event comes back
+func TestHandleEventRetriesUnsent(t *testing.T) {
+	db := dbtest.Open(t)
+	mailer := &MemoryMailer{}
+	n := NewNotifier(db, NewQueue(mailer, 1))
+	n.Queue.Enqueue(Message{To: "someone@example.com"})
+
+	e := recordEvent(t, n, events.UserRegistered, events.UserRegisteredPayload{Username: "ada", Email: "ada@example.com"})
+	if err := n.HandleEvent(context.Background(), e); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("HandleEvent() with a full queue = %v, want ErrQueueFull", err)
+	}
+
+	n.Queue.drain()
+	if err := n.HandleEvent(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	n.Queue.drain()
+	if sent := mailer.Messages(); len(sent) != 2 || sent[1].To != "ada@example.com" {
+		t.Errorf("Sent %+v, want the welcome email after the retry", sent)
+	}
+}
@@ -0,0 +1,92 @@
+// notifications/queue.go
+
+package notifications
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// ErrQueueFull is returned by Enqueue when the send buffer is full
+var ErrQueueFull = errors.New("mail queue is full")
+
+// Queue sends mail in the background so HTTP handlers never wait on SMTP.
+type Queue struct {
+	Mailer      Mailer
+	MaxAttempts int
+	RetryDelay  time.Duration
+	SendTimeout time.Duration
+	jobs        chan Message
+}
+
+func NewQueue(mailer Mailer, size int) *Queue {
+	return &Queue{
+		Mailer:      mailer,
+		MaxAttempts: 3,
+		RetryDelay:  5 * time.Second,
+		SendTimeout: 30 * time.Second,
+		jobs:        make(chan Message, size),
+	}
+}
+
+// Enqueue schedules msg for sending without blocking.
+func (q *Queue) Enqueue(msg Message) error {
+	select {
+	case q.jobs <- msg:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Run sends queued messages until ctx is cancelled, then sends whatever is
+// still buffered before returning.
+func (q *Queue) Run(ctx context.Context) {
+	slog.Info("Mail queue started")
+	for {
+		select {
+		case msg := <-q.jobs:
+			q.send(ctx, msg)
+		case <-ctx.Done():
+			q.drain()
+			slog.Info("Mail queue stopped")
+			return
+		}
+	}
+}
+
+func (q *Queue) drain() {
+	for {
+		select {
+		case msg := <-q.jobs:
+			q.send(context.Background(), msg)
+		default:
+			return
+		}
+	}
+}
+
+// send tries to deliver msg up to MaxAttempts times.
+func (q *Queue) send(ctx context.Context, msg Message) {
+	for attempt := 1; attempt <= q.MaxAttempts; attempt++ {
+		sendCtx, cancel := context.WithTimeout(context.Background(), q.SendTimeout)
+		err := q.Mailer.Send(sendCtx, msg)
+		cancel()
+		if err == nil {
+			return
+		}
+
+		slog.Warn("Mail queue: sending failed", "attempt", attempt, "subject", msg.Subject, "error", err)
+		if attempt == q.MaxAttempts {
+			break
+		}
+		select {
+		case <-time.After(q.RetryDelay):
+		case <-ctx.Done():
+			// Shutting down: make the remaining attempts without waiting
+		}
+	}
+	slog.Error("Mail queue: giving up", "subject", msg.Subject)
+}
@@ -0,0 +1,89 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// flakyMailer fails the first failures sends, then hands messages to MemoryMailer
+type flakyMailer struct {
+	MemoryMailer
+	failures int
+	attempts int
+}
+
+func (m *flakyMailer) Send(ctx context.Context, msg Message) error {
+	m.attempts++
+	if m.attempts <= m.failures {
+		return errors.New("connection refused")
+	}
+	return m.MemoryMailer.Send(ctx, msg)
+}
+
+func TestQueueRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		wantAttempts int
+		wantSent     int
+	}{
+		{"first attempt", 0, 1, 1},
+		{"after a failure", 2, 3, 1},
+		{"gives up", 5, 3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mailer := &flakyMailer{failures: tt.failures}
+			q := NewQueue(mailer, 1)
+			q.RetryDelay = 0
+			q.send(context.Background(), Message{To: "ada@example.com", Subject: "Hi"})
+			if mailer.attempts != tt.wantAttempts || len(mailer.Messages()) != tt.wantSent {
+				t.Errorf("%d attempts, %d sent, want %d and %d", mailer.attempts, len(mailer.Messages()), tt.wantAttempts, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestQueueEnqueue(t *testing.T) {
+	mailer := &MemoryMailer{}
+	q := NewQueue(mailer, 1)
+	if err := q.Enqueue(Message{To: "ada@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	// Handlers never wait for room in the queue
+	if err := q.Enqueue(Message{To: "bob@example.com"}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Enqueue() on a full queue = %v, want ErrQueueFull", err)
+	}
+
+	// Stopping the queue still sends what was buffered
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	q.Run(ctx)
+	if sent := mailer.Messages(); len(sent) != 1 || sent[0].To != "ada@example.com" {
+		t.Errorf("Sent %v, want the buffered message", sent)
+	}
+}
+
+func TestRender(t *testing.T) {
+	msg, err := Render(TemplateOrderConfirmation, "ada@example.com", map[string]interface{}{
+		"Username": "<Ada>", "OrderID": 42, "TotalPrice": 9.5, "Status": "pending", "BaseURL": "http://shop.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.To != "ada@example.com" || msg.Subject != subjects[TemplateOrderConfirmation] {
+		t.Errorf("Message = %+v", msg)
+	}
+	if !strings.Contains(msg.TextBody, "order #42") || !strings.Contains(msg.TextBody, "$9.50") {
+		t.Errorf("Text body = %q", msg.TextBody)
+	}
+	if strings.Contains(msg.HTMLBody, "<Ada>") || !strings.Contains(msg.HTMLBody, "&lt;Ada&gt;") {
+		t.Errorf("HTML body doesn't escape the username: %q", msg.HTMLBody)
+	}
+
+	if _, err := Render("missing", "ada@example.com", nil); err == nil {
+		t.Error("Render() accepted an unknown template")
+	}
+}
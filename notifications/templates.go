@@ -0,0 +1,59 @@
+// notifications/templates.go
+
+package notifications
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+)
+
+// Template names
+const (
+	TemplateWelcome           = "welcome"
+	TemplateOrderConfirmation = "order_confirmation"
+	TemplateShipment          = "shipment"
+	TemplatePasswordReset     = "password_reset"
+	TemplateVerifyEmail       = "verify_email"
+)
+
+var subjects = map[string]string{
+	TemplateWelcome:           "Welcome to gocommerce",
+	TemplateOrderConfirmation: "Your gocommerce order confirmation",
+	TemplateShipment:          "Your gocommerce order has shipped",
+	TemplatePasswordReset:     "Reset your gocommerce password",
+	TemplateVerifyEmail:       "Confirm your gocommerce email address",
+}
+
+//go:embed templates
+var templateFS embed.FS
+
+// Render builds a message addressed to `to` from the named HTML and text templates.
+func Render(name, to string, data interface{}) (Message, error) {
+	subject, ok := subjects[name]
+	if !ok {
+		return Message{}, fmt.Errorf("unknown email template %q", name)
+	}
+
+	htmlTmpl, err := htmltemplate.ParseFS(templateFS, "templates/layout.html", "templates/"+name+".html")
+	if err != nil {
+		return Message{}, err
+	}
+	var html bytes.Buffer
+	if err := htmlTmpl.ExecuteTemplate(&html, "layout", data); err != nil {
+		return Message{}, err
+	}
+
+	textTmpl, err := texttemplate.ParseFS(templateFS, "templates/"+name+".txt")
+	if err != nil {
+		return Message{}, err
+	}
+	var text bytes.Buffer
+	if err := textTmpl.Execute(&text, data); err != nil {
+		return Message{}, err
+	}
+
+	return Message{To: to, Subject: subject, TextBody: text.String(), HTMLBody: html.String()}, nil
+}
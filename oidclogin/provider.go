@@ -0,0 +1,155 @@
+// oidclogin/provider.go
+
+// Package oidclogin signs users in with external OpenID Connect providers using the
+// authorization code flow with PKCE.
+package oidclogin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gocommerce/config"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Identity is what a provider tells us about the user who signed in
+type Identity struct {
+	Provider          string
+	Subject           string // Stable ID of the user at the provider
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+// Provider is one configured OpenID Connect provider. Discovery happens on first
+// use, so a provider that is down doesn't stop the API from starting.
+type Provider struct {
+	Name         string
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+
+	mu       sync.Mutex
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// LoadProviders reads the providers named in OIDC_PROVIDERS (e.g. "google,okta").
+// Each one is configured with OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID,
+// OIDC_<NAME>_CLIENT_SECRET and optionally OIDC_<NAME>_SCOPES. Its callback is
+// callbackBase + "/" + name + "/callback".
+func LoadProviders(callbackBase string) map[string]*Provider {
+	providers := map[string]*Provider{}
+	for _, name := range strings.Split(config.String("OIDC_PROVIDERS", ""), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		p := &Provider{
+			Name:         name,
+			issuer:       config.String(prefix+"ISSUER", ""),
+			clientID:     config.String(prefix+"CLIENT_ID", ""),
+			clientSecret: config.String(prefix+"CLIENT_SECRET", ""),
+			redirectURL:  callbackBase + "/" + name + "/callback",
+			scopes:       strings.Fields(config.String(prefix+"SCOPES", "openid email profile")),
+		}
+		if p.issuer == "" || p.clientID == "" {
+			slog.Warn("OIDC provider is missing its issuer or client ID, skipping it", "provider", name, "prefix", prefix)
+			continue
+		}
+		providers[name] = p
+	}
+	return providers
+}
+
+// discover fetches the provider's metadata and signing keys the first time they're needed.
+func (p *Provider) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.oauth == nil {
+		provider, err := oidc.NewProvider(ctx, p.issuer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error discovering OIDC provider %s: %w", p.Name, err)
+		}
+		p.oauth = &oauth2.Config{
+			ClientID:     p.clientID,
+			ClientSecret: p.clientSecret,
+			RedirectURL:  p.redirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       p.scopes,
+		}
+		p.verifier = provider.Verifier(&oidc.Config{ClientID: p.clientID})
+	}
+	return p.oauth, p.verifier, nil
+}
+
+// AuthCodeURL returns the provider URL that starts a login. state and nonce tie the
+// callback and ID token to this login; the PKCE verifier must be kept for Exchange.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	oauth, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return oauth.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(codeVerifier)), nil
+}
+
+// Exchange redeems an authorization code and returns the verified identity from the ID token.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error) {
+	oauth, verifier, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := oauth.Exchange(ctx, code, oauth2.VerifierOption(codeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("error exchanging authorization code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response has no id_token")
+	}
+	// Checks the signature, issuer, audience and expiry
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("error verifying ID token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		Name              string `json:"name"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("error reading ID token claims: %w", err)
+	}
+
+	return &Identity{
+		Provider:          p.Name,
+		Subject:           idToken.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		Name:              claims.Name,
+		PreferredUsername: claims.PreferredUsername,
+	}, nil
+}
+
+// GenerateVerifier returns a new random PKCE code verifier.
+func GenerateVerifier() string {
+	return oauth2.GenerateVerifier()
+}
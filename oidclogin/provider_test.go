@@ -0,0 +1,118 @@
+package oidclogin_test
+
+import (
+	"context"
+	"gocommerce/oidclogin"
+	"gocommerce/oidclogin/oidctest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const callbackBase = "http://api.test/api/v1/auth/oidc"
+
+// newProvider configures a provider named mock against a running mock issuer
+func newProvider(t *testing.T, issuer *oidctest.Provider) *oidclogin.Provider {
+	t.Setenv("OIDC_PROVIDERS", "mock")
+	t.Setenv("OIDC_MOCK_ISSUER", issuer.URL)
+	t.Setenv("OIDC_MOCK_CLIENT_ID", issuer.ClientID)
+	t.Setenv("OIDC_MOCK_CLIENT_SECRET", issuer.ClientSecret)
+	provider, ok := oidclogin.LoadProviders(callbackBase)["mock"]
+	if !ok {
+		t.Fatal("Provider was not loaded")
+	}
+	return provider
+}
+
+// authorize starts a login and returns the code the provider redirected back with
+func authorize(t *testing.T, issuer *oidctest.Provider, provider *oidclogin.Provider, verifier, nonce string) string {
+	t.Helper()
+	authURL, err := provider.AuthCodeURL(context.Background(), "the-state", nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback, err := issuer.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _ := url.Parse(callback)
+	if !strings.HasPrefix(callback, callbackBase+"/mock/callback?") || parsed.Query().Get("state") != "the-state" {
+		t.Fatalf("Provider redirected to %s", callback)
+	}
+	return parsed.Query().Get("code")
+}
+
+func TestLoadProviders(t *testing.T) {
+	t.Setenv("OIDC_PROVIDERS", " Google, incomplete ,")
+	t.Setenv("OIDC_GOOGLE_ISSUER", "https://accounts.google.com")
+	t.Setenv("OIDC_GOOGLE_CLIENT_ID", "id")
+	t.Setenv("OIDC_INCOMPLETE_ISSUER", "https://example.com")
+
+	providers := oidclogin.LoadProviders(callbackBase)
+	if len(providers) != 1 || providers["google"] == nil || providers["google"].Name != "google" {
+		t.Errorf("LoadProviders() = %v, want only google", providers)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	issuer := oidctest.NewProvider(t)
+	issuer.User = oidctest.User{Subject: "abc", Email: "ada@example.com", EmailVerified: true, PreferredUsername: "ada"}
+	provider := newProvider(t, issuer)
+	verifier := oidclogin.GenerateVerifier()
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "the-state", "the-nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, _ := url.Parse(authURL)
+	if query.Query().Get("code_challenge_method") != "S256" || strings.Contains(authURL, verifier) {
+		t.Errorf("AuthCodeURL() = %s, want an S256 challenge and no verifier", authURL)
+	}
+
+	code := authorize(t, issuer, provider, verifier, "the-nonce")
+	identity, err := provider.Exchange(context.Background(), code, verifier, "the-nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := oidclogin.Identity{Provider: "mock", Subject: "abc", Email: "ada@example.com", EmailVerified: true, PreferredUsername: "ada"}
+	if *identity != want {
+		t.Errorf("Exchange() = %+v, want %+v", *identity, want)
+	}
+
+	// Codes are single use
+	if _, err := provider.Exchange(context.Background(), code, verifier, "the-nonce"); err == nil {
+		t.Error("Exchange() accepted a used code")
+	}
+}
+
+func TestExchangeRejects(t *testing.T) {
+	issuer := oidctest.NewProvider(t)
+	provider := newProvider(t, issuer)
+
+	tests := []struct {
+		name     string
+		verifier string // Sent to the token endpoint instead of the one the login started with
+		nonce    string // Expected instead of the one the login started with
+	}{
+		{name: "another PKCE verifier", verifier: oidclogin.GenerateVerifier()},
+		{name: "no PKCE verifier", verifier: " "},
+		{name: "another nonce", nonce: "replayed-nonce"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := oidclogin.GenerateVerifier()
+			code := authorize(t, issuer, provider, verifier, "the-nonce")
+
+			sentVerifier, nonce := verifier, "the-nonce"
+			if tt.verifier != "" {
+				sentVerifier = strings.TrimSpace(tt.verifier)
+			}
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+			if identity, err := provider.Exchange(context.Background(), code, sentVerifier, nonce); err == nil {
+				t.Errorf("Exchange() = %+v, want an error", identity)
+			}
+		})
+	}
+}
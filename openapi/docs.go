@@ -0,0 +1,22 @@
+// openapi/docs.go
+
+package openapi
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// docsPage renders the document served at openapi.json next to it
+//
+//go:embed docs.html
+var docsPage []byte
+
+// DocsHandler serves a browsable reference for the document. It has to be mounted
+// in the same directory as the document, since the page loads it by relative URL.
+func DocsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(docsPage)
+	})
+}
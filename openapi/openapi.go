@@ -0,0 +1,298 @@
+// openapi/openapi.go
+
+// Package openapi describes the API as an OpenAPI 3.1 document. The paths come from
+// the routes registered on the router, so the document can't list an endpoint that
+// doesn't exist; what each operation takes and returns comes from a table of
+// Operations keyed by "METHOD /path/{template}", with schemas reflected from the
+// Go types the handlers decode and encode.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"gocommerce/apiversion"
+	"gocommerce/apperrors"
+	"gocommerce/cookies"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Version is the OpenAPI version of the generated document
+const Version = "3.1.0"
+
+// Operation documents one method on one route.
+type Operation struct {
+	Summary      string
+	Description  string
+	Tag          string  // Defaults to the first path segment after /api and the version
+	ID           string  // Defaults to the handler's method name
+	Query        []Param // Query string parameters; path parameters come from the route
+	Body         any     // A value of the request body type, or nil for none
+	OptionalBody bool    // The body may be left out, see validation.DecodeOptional
+	Status       int     // The success status, 200 if zero
+	Response     any     // A value of the success body type, or nil for none
+	ContentType  string  // The success content type when it isn't JSON
+}
+
+// Param is a query string parameter.
+type Param struct {
+	Name        string
+	Type        string // A JSON schema type, string if empty
+	Description string
+	Enum        []string
+}
+
+// oneOf is a body that takes one of several shapes
+type oneOf []any
+
+// OneOf documents a body that is one of values' types.
+func OneOf(values ...any) any {
+	return oneOf(values)
+}
+
+// pathParam matches a variable in a route template, with any pattern it has
+var pathParam = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// Undocumented lists the routes on router, as "METHOD /path", that ops doesn't cover.
+func Undocumented(router *mux.Router, ops map[string]Operation) []string {
+	var missing []string
+	walkRoutes(router, func(method, tpl, _ string, _ http.Handler) {
+		if _, ok := ops[method+" "+tpl]; !ok {
+			missing = append(missing, method+" "+tpl)
+		}
+	})
+	sort.Strings(missing)
+	return missing
+}
+
+// walkRoutes calls fn for each method of each route with a path template. path is
+// the template without variable patterns. Subrouter prefixes, which have no handler
+// of their own, are skipped.
+func walkRoutes(router *mux.Router, fn func(method, tpl, path string, handler http.Handler)) {
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil || route.GetHandler() == nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// Routes without a method matcher answer every method
+			methods = []string{"ANY"}
+		}
+		path := pathParam.ReplaceAllString(tpl, "{$1}")
+		for _, method := range methods {
+			fn(method, tpl, path, route.GetHandler())
+		}
+		return nil
+	})
+}
+
+// Build generates the document for every documented route on router. public
+// reports whether a path can be called without credentials.
+func Build(router *mux.Router, ops map[string]Operation, public func(path string) bool) map[string]any {
+	s := newSchemas()
+	paths := map[string]any{}
+	tags := map[string]bool{}
+
+	walkRoutes(router, func(method, tpl, path string, handler http.Handler) {
+		op, ok := ops[method+" "+tpl]
+		if !ok || method == "ANY" {
+			return
+		}
+		item, _ := paths[path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[path] = item
+		}
+		doc := s.operation(op, path, handler)
+		if d, ok := apiversion.Lookup(method, tpl); ok {
+			deprecate(doc, d)
+		}
+		if public(path) {
+			// Overrides the document-wide requirement
+			doc["security"] = []any{}
+		}
+		tags[doc["tags"].([]string)[0]] = true
+		item[strings.ToLower(method)] = doc
+	})
+
+	tagList := []map[string]any{}
+	for _, name := range sortedKeys(tags) {
+		tagList = append(tagList, map[string]any{"name": name})
+	}
+
+	s.components["Problem"] = s.structSchema(reflect.TypeOf(apperrors.Problem{}))
+	return map[string]any{
+		"openapi": Version,
+		"info": map[string]any{
+			"title":   "gocommerce API",
+			"version": "1.0.0",
+			"description": "Errors are RFC 7807 problem details (" + apperrors.ContentType + ") with a stable `code`. " +
+				"Unversioned /api paths are deprecated aliases of " + apiversion.V1.Prefix() + ".",
+		},
+		"tags":  tagList,
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": s.components,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"cookieAuth": map[string]any{"type": "apiKey", "in": "cookie", "name": cookies.AccessCookieName,
+					"description": "State-changing requests must also send the CSRF cookie's value in X-CSRF-Token"},
+				"apiKeyAuth": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+		"security": []any{
+			map[string]any{"bearerAuth": []string{}},
+			map[string]any{"cookieAuth": []string{}},
+			map[string]any{"apiKeyAuth": []string{}},
+		},
+	}
+}
+
+func (s *schemas) operation(op Operation, path string, handler http.Handler) map[string]any {
+	tag := op.Tag
+	if tag == "" {
+		segments := strings.Split(strings.TrimPrefix(apiversion.Unversioned(path), "/api/"), "/")
+		tag = segments[0]
+	}
+	doc := map[string]any{
+		"summary": op.Summary,
+		"tags":    []string{tag},
+	}
+	if op.Description != "" {
+		doc["description"] = op.Description
+	}
+	if id := operationID(op, handler); id != "" {
+		doc["operationId"] = id
+	}
+
+	var params []any
+	for _, match := range pathParam.FindAllStringSubmatch(path, -1) {
+		schema := map[string]any{"type": "string"}
+		if match[1] == "id" {
+			schema = map[string]any{"type": "integer"}
+		}
+		params = append(params, map[string]any{"name": match[1], "in": "path", "required": true, "schema": schema})
+	}
+	for _, p := range op.Query {
+		schema := map[string]any{"type": "string"}
+		if p.Type != "" {
+			schema["type"] = p.Type
+		}
+		if len(p.Enum) > 0 {
+			schema["enum"] = p.Enum
+		}
+		params = append(params, map[string]any{"name": p.Name, "in": "query", "description": p.Description, "schema": schema})
+	}
+	if len(params) > 0 {
+		doc["parameters"] = params
+	}
+
+	if op.Body != nil {
+		doc["requestBody"] = map[string]any{
+			"required": !op.OptionalBody,
+			"content":  map[string]any{"application/json": map[string]any{"schema": s.valueSchema(op.Body)}},
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]any{"description": http.StatusText(status)}
+	switch {
+	case op.ContentType != "":
+		success["content"] = map[string]any{op.ContentType: map[string]any{}}
+	case op.Response != nil:
+		success["content"] = map[string]any{"application/json": map[string]any{"schema": s.valueSchema(op.Response)}}
+	}
+	doc["responses"] = map[string]any{
+		strconv.Itoa(status): success,
+		"default": map[string]any{
+			"description": "Error",
+			"content":     map[string]any{apperrors.ContentType: map[string]any{"schema": ref("Problem")}},
+		},
+	}
+	return doc
+}
+
+// deprecate marks an operation deprecated and says what replaces it and when it goes
+func deprecate(doc map[string]any, d apiversion.Deprecation) {
+	doc["deprecated"] = true
+	description, _ := doc["description"].(string)
+	if d.Successor != "" {
+		description += " Use " + d.Successor + " instead."
+	}
+	if !d.Sunset.IsZero() {
+		description += " Removed on " + d.Sunset.Format(time.DateOnly) + "."
+	}
+	if description != "" {
+		doc["description"] = strings.TrimSpace(description)
+	}
+}
+
+// operationID is op.ID, or the name of the handler method when the route was
+// registered with one, such as GetProducts
+func operationID(op Operation, handler http.Handler) string {
+	if op.ID != "" {
+		return op.ID
+	}
+	fn, ok := handler.(http.HandlerFunc)
+	if !ok {
+		return ""
+	}
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	// Method values are named like gocommerce/handlers.(*ProductHandler).GetProducts-fm
+	if !strings.HasSuffix(name, "-fm") {
+		return ""
+	}
+	name = strings.TrimSuffix(name, "-fm")
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// Spec serves the document for a router. It's built on the first request, by which
+// time every route has been registered.
+type Spec struct {
+	router *mux.Router
+	ops    map[string]Operation
+	public func(path string) bool
+
+	once sync.Once
+	body []byte
+	err  error
+}
+
+// NewSpec returns a handler serving the document for router.
+func NewSpec(router *mux.Router, ops map[string]Operation, public func(path string) bool) *Spec {
+	return &Spec{router: router, ops: ops, public: public}
+}
+
+func (s *Spec) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.once.Do(func() {
+		s.body, s.err = json.MarshalIndent(Build(s.router, s.ops, s.public), "", "  ")
+	})
+	if s.err != nil {
+		apperrors.Write(w, r, apperrors.Internal("Error generating OpenAPI document", fmt.Errorf("openapi: %w", s.err)))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(s.body)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,179 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"gocommerce/apiversion"
+	"gocommerce/handlers"
+	"gocommerce/middleware"
+	"gocommerce/openapi"
+	"gocommerce/routes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// router registers every route the server does. The handlers are never called,
+// so they don't need a database.
+func router() *mux.Router {
+	r := mux.NewRouter()
+	routes.RegisterAll(r, &handlers.Handlers{})
+	return r
+}
+
+// TestEveryRouteIsDocumented fails when a route is added without an entry in
+// handlers.APIDocs.
+func TestEveryRouteIsDocumented(t *testing.T) {
+	for _, route := range openapi.Undocumented(router(), handlers.APIDocs) {
+		t.Errorf("%s is missing from handlers.APIDocs", route)
+	}
+}
+
+// TestNoStaleDocs fails when handlers.APIDocs documents a route that no longer exists.
+func TestNoStaleDocs(t *testing.T) {
+	registered := map[string]bool{}
+	router().Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tpl, _ := route.GetPathTemplate()
+		methods, _ := route.GetMethods()
+		for _, method := range methods {
+			registered[method+" "+tpl] = true
+		}
+		return nil
+	})
+	for route := range handlers.APIDocs {
+		if !registered[route] {
+			t.Errorf("handlers.APIDocs documents %s, which isn't registered", route)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	doc := openapi.Build(router(), handlers.APIDocs, middleware.IsPublicPath)
+	paths := doc["paths"].(map[string]any)
+
+	ids := map[string]string{}
+	operations := 0
+	for path, item := range paths {
+		for method, op := range item.(map[string]any) {
+			operations++
+			route := strings.ToUpper(method) + " " + path
+			id, _ := op.(map[string]any)["operationId"].(string)
+			if id == "" {
+				t.Errorf("%s has no operationId", route)
+			} else if other, taken := ids[id]; taken {
+				t.Errorf("%s and %s share operationId %s", route, other, id)
+			}
+			ids[id] = route
+		}
+	}
+	if operations != len(handlers.APIDocs) {
+		t.Errorf("Document has %d operations, want one for each of the %d documented routes", operations, len(handlers.APIDocs))
+	}
+
+	operation := func(method, path string) map[string]any {
+		t.Helper()
+		item, ok := paths[path].(map[string]any)
+		if !ok {
+			t.Fatalf("%s is missing from the document", path)
+		}
+		return item[method].(map[string]any)
+	}
+	if security, ok := operation("post", "/api/v1/auth/login")["security"]; !ok || len(security.([]any)) != 0 {
+		t.Errorf("Login should be public, has security %v", security)
+	}
+	if _, ok := operation("get", "/api/v1/me")["security"]; ok {
+		t.Error("/api/v1/me should use the document-wide security requirement")
+	}
+	if tags := operation("get", "/api/v1/products/{id}")["tags"]; !reflect.DeepEqual(tags, []string{"products"}) {
+		t.Errorf("Product tags = %v, want [products]", tags)
+	}
+
+	// The whole document has to be valid JSON for the /docs page to load it
+	if _, err := json.Marshal(doc); err != nil {
+		t.Errorf("Document doesn't marshal: %v", err)
+	}
+}
+
+type widgetRequest struct {
+	Name  string   `json:"name" validate:"required,min=2,max=20"`
+	Color string   `json:"color" validate:"oneof=red blue"`
+	Tags  []string `json:"tags" validate:"max=3"`
+}
+
+type widget struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	DeletedAt *time.Time `json:"deleted_at"`
+	Note      string     `json:"note,omitempty"`
+}
+
+func (widget) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+func TestBuildSchemas(t *testing.T) {
+	r := mux.NewRouter()
+	r.Handle("/api/v1/widgets", widget{}).Methods("POST")
+	r.Handle("/api/v1/widgets/{id:[0-9]+}", widget{}).Methods("GET")
+	r.Handle("/api/v1/gadgets", widget{}).Methods("GET")
+	apiversion.Deprecate("GET", "/api/v1/widgets/{id:[0-9]+}", apiversion.Deprecation{
+		Since:     time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		Sunset:    time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+		Successor: "/api/v2/widgets/{id}",
+	})
+
+	ops := map[string]openapi.Operation{
+		"POST /api/v1/widgets":            {Summary: "Create a widget", ID: "CreateWidget", Body: widgetRequest{}, Status: http.StatusCreated, Response: widget{}},
+		"GET /api/v1/widgets/{id:[0-9]+}": {Summary: "Get a widget", ID: "GetWidget", Response: widget{}},
+	}
+	if missing := openapi.Undocumented(r, ops); !reflect.DeepEqual(missing, []string{"GET /api/v1/gadgets"}) {
+		t.Errorf("Undocumented() = %v, want [GET /api/v1/gadgets]", missing)
+	}
+
+	doc := openapi.Build(r, ops, func(string) bool { return false })
+	if _, ok := doc["paths"].(map[string]any)["/api/v1/gadgets"]; ok {
+		t.Error("Undocumented route is in the document")
+	}
+	get := doc["paths"].(map[string]any)["/api/v1/widgets/{id}"].(map[string]any)["get"].(map[string]any)
+	if get["deprecated"] != true || !strings.Contains(get["description"].(string), "/api/v2/widgets/{id}") ||
+		!strings.Contains(get["description"].(string), "2027-01-01") {
+		t.Errorf("Deprecated operation = %v", get)
+	}
+
+	schemas := doc["components"].(map[string]any)["schemas"].(map[string]any)
+	request := schemas["WidgetRequest"].(map[string]any)
+	if !reflect.DeepEqual(request["required"], []string{"name"}) {
+		t.Errorf("Request required = %v, want only the fields with a required rule", request["required"])
+	}
+	properties := request["properties"].(map[string]any)
+	name := properties["name"].(map[string]any)
+	if fmt.Sprint(name["minLength"], name["maxLength"]) != "2 20" {
+		t.Errorf("name schema = %v, want minLength 2 and maxLength 20", name)
+	}
+	if color := properties["color"].(map[string]any); fmt.Sprint(color["enum"]) != "[red blue]" {
+		t.Errorf("color schema = %v, want an enum", color)
+	}
+	if tags := properties["tags"].(map[string]any); fmt.Sprint(tags["maxItems"]) != "3" {
+		t.Errorf("tags schema = %v, want maxItems 3", tags)
+	}
+
+	response := schemas["Widget"].(map[string]any)
+	if !reflect.DeepEqual(response["required"], []string{"id", "name"}) {
+		t.Errorf("Response required = %v, want the fields that are always present", response["required"])
+	}
+}
+
+func TestSpec(t *testing.T) {
+	w := httptest.NewRecorder()
+	openapi.NewSpec(router(), handlers.APIDocs, middleware.IsPublicPath).ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/openapi.json", nil))
+	var doc map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Spec served %d: %v", w.Code, err)
+	}
+	if doc["openapi"] != openapi.Version {
+		t.Errorf("openapi = %v, want %s", doc["openapi"], openapi.Version)
+	}
+}
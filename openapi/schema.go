@@ -0,0 +1,221 @@
+// openapi/schema.go
+
+package openapi
+
+import (
+	"encoding/json"
+	"gocommerce/validation"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// schemas collects a named component for each struct type a schema refers to
+type schemas struct {
+	components map[string]any
+	names      map[reflect.Type]string
+}
+
+func newSchemas() *schemas {
+	return &schemas{components: map[string]any{}, names: map[reflect.Type]string{}}
+}
+
+func ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+)
+
+// valueSchema is the schema of the JSON v encodes to
+func (s *schemas) valueSchema(v any) map[string]any {
+	if values, ok := v.(oneOf); ok {
+		var options []any
+		for _, value := range values {
+			options = append(options, s.valueSchema(value))
+		}
+		return map[string]any{"oneOf": options}
+	}
+	return s.typeSchema(reflect.TypeOf(v))
+}
+
+func (s *schemas) typeSchema(t reflect.Type) map[string]any {
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case rawType:
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return nullable(s.typeSchema(t.Elem()))
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema := map[string]any{"type": "integer"}
+		if t.Kind() == reflect.Int64 {
+			schema["format"] = "int64"
+		}
+		return schema
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": s.typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.typeSchema(t.Elem())}
+	case reflect.Struct:
+		return ref(s.component(t))
+	default:
+		// interface{} holds anything
+		return map[string]any{}
+	}
+}
+
+// component registers t's schema, if it hasn't been already, and returns its name
+func (s *schemas) component(t reflect.Type) string {
+	if name, ok := s.names[t]; ok {
+		return name
+	}
+	name := exportedName(t.Name())
+	if _, taken := s.components[name]; taken || name == "" {
+		// Types from different packages can share a name
+		pkg := t.PkgPath()
+		name = exportedName(pkg[strings.LastIndex(pkg, "/")+1:]) + name
+	}
+	s.names[t] = name
+	// Placeholder first so types that refer to themselves terminate
+	s.components[name] = map[string]any{}
+	s.components[name] = s.structSchema(t)
+	return name
+}
+
+func exportedName(name string) string {
+	if name == "" {
+		return ""
+	}
+	r := []rune(name)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+// structSchema describes a struct's JSON object. Request types, the ones with
+// validate tags, require the fields tagged required; any other struct requires the
+// fields it always writes.
+func (s *schemas) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	isRequest := hasValidateTags(t)
+
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				// Embedded structs' fields are promoted into the object
+				addFields(field.Type)
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+
+			rules := strings.Split(field.Tag.Get("validate"), ",")
+			properties[name] = s.fieldSchema(field.Type, rules)
+			if isRequest && slices.Contains(rules, "required") ||
+				!isRequest && !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Pointer {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func hasValidateTags(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("validate") != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldSchema is t's schema with the keywords its validation rules translate to
+func (s *schemas) fieldSchema(t reflect.Type, rules []string) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		return nullable(s.fieldSchema(t.Elem(), rules))
+	}
+	schema := s.typeSchema(t)
+	items := schema
+	if t.Kind() == reflect.Slice && schema["items"] != nil {
+		// min and max limit the length; other rules apply to every item
+		items = schema["items"].(map[string]any)
+	}
+
+	for _, rule := range rules {
+		rule, arg, _ := strings.Cut(rule, "=")
+		switch rule {
+		case "min", "max":
+			n, _ := strconv.ParseFloat(arg, 64)
+			schema[boundKeyword(t.Kind(), rule)] = n
+		case "oneof":
+			items["enum"] = strings.Fields(arg)
+		case "email":
+			items["format"] = "email"
+		case "url":
+			items["format"] = "uri"
+		case "username":
+			items["pattern"] = validation.UsernamePattern.String()
+		}
+	}
+	return schema
+}
+
+// boundKeyword is the JSON schema keyword min or max translates to for kind
+func boundKeyword(kind reflect.Kind, rule string) string {
+	switch kind {
+	case reflect.String:
+		return rule + "Length"
+	case reflect.Slice:
+		return rule + "Items"
+	case reflect.Float32, reflect.Float64, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if rule == "min" {
+			return "minimum"
+		}
+		return "maximum"
+	}
+	panic("openapi: " + rule + " does not apply to " + kind.String())
+}
+
+// nullable allows null as well as what schema allows, which is how a nil pointer encodes
+func nullable(schema map[string]any) map[string]any {
+	if typ, ok := schema["type"].(string); ok {
+		schema["type"] = []string{typ, "null"}
+		return schema
+	}
+	return map[string]any{"oneOf": []any{schema, map[string]any{"type": "null"}}}
+}
@@ -0,0 +1,73 @@
+// privacy/erase.go
+
+package privacy
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"gocommerce/events"
+	"strings"
+)
+
+// Erase removes a user's personal data. The users row is kept but anonymized, so
+// orders still reference a customer and totals, statuses and dates stay intact for
+// accounting. Everything that identifies the person is deleted or overwritten.
+func Erase(ctx context.Context, tx *sql.Tx, userID int) error {
+	var username string
+	if err := tx.QueryRowContext(ctx, "SELECT username FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&username); err != nil {
+		return fmt.Errorf("error loading user: %w", err)
+	}
+
+	// Placeholders stay unique, as the columns require, and can't receive mail
+	_, err := tx.ExecContext(ctx, `UPDATE users SET
+			username = 'erased-user-' || id,
+			email = 'erased-user-' || id || '@invalid',
+			password_hash = '',
+			pending_email = NULL,
+			email_verified_at = NULL,
+			totp_secret = NULL,
+			mfa_enabled_at = NULL,
+			totp_last_step = NULL,
+			deleted_at = COALESCE(deleted_at, NOW()),
+			anonymized_at = NOW(),
+			updated_at = NOW()
+		WHERE id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("error anonymizing user: %w", err)
+	}
+
+	for _, statement := range []string{
+		"DELETE FROM cart_items WHERE user_id = $1",
+		"DELETE FROM sessions WHERE user_id = $1",
+		"DELETE FROM user_identities WHERE user_id = $1",
+		"DELETE FROM email_verification_tokens WHERE user_id = $1",
+		"DELETE FROM password_reset_tokens WHERE user_id = $1",
+		"DELETE FROM mfa_recovery_codes WHERE user_id = $1",
+	} {
+		if _, err := tx.ExecContext(ctx, statement, userID); err != nil {
+			return fmt.Errorf("error erasing user data (%s): %w", statement, err)
+		}
+	}
+
+	// Failed login counters are keyed by the username that was typed in
+	if _, err := tx.ExecContext(ctx, "DELETE FROM login_throttles WHERE scope = 'account' AND key = $1",
+		strings.ToLower(username)); err != nil {
+		return fmt.Errorf("error erasing login throttle: %w", err)
+	}
+
+	// The registration event and its webhook deliveries carry the username and email.
+	// Deliveries wrap the event payload in an envelope under "data".
+	const scrubbed = `jsonb_build_object('user_id', $1::int)`
+	if _, err := tx.ExecContext(ctx, `UPDATE webhook_deliveries SET payload = jsonb_set(payload, '{data}', `+scrubbed+`)
+		WHERE event_id IN (SELECT id FROM outbox_events WHERE event_type = $2 AND aggregate_id = $1)`,
+		userID, events.UserRegistered); err != nil {
+		return fmt.Errorf("error scrubbing webhook deliveries: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE outbox_events SET payload = `+scrubbed+`
+		WHERE event_type = $2 AND aggregate_id = $1`, userID, events.UserRegistered); err != nil {
+		return fmt.Errorf("error scrubbing outbox events: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package privacy
+
+import (
+	"context"
+	"database/sql"
+	"gocommerce/dbtest"
+	"strings"
+	"testing"
+)
+
+// createCustomer inserts a customer with something in every table Erase touches
+// and returns the user and order IDs
+func createCustomer(t *testing.T, db *sql.DB, username string) (int, int) {
+	t.Helper()
+	var userID, productID, orderID, eventID int
+	err := db.QueryRow(`INSERT INTO users (username, email, password_hash, pending_email, email_verified_at, totp_secret, mfa_enabled_at)
+		VALUES ($1, $1 || '@example.com', 'hash', $1 || '@new.example', NOW(), 'JBSWY3DPEHPK3PXP', NOW()) RETURNING id`, username).Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.QueryRow(`INSERT INTO products (name, price) VALUES ('Widget', 10) RETURNING id`).Scan(&productID)
+	db.QueryRow(`INSERT INTO orders (customer_id, total_price, status) VALUES ($1, 42.50, 'delivered') RETURNING id`, userID).Scan(&orderID)
+	db.QueryRow(`INSERT INTO outbox_events (event_type, aggregate_id, payload)
+		VALUES ('user.registered', $1, jsonb_build_object('user_id', $1::int, 'username', $2::text, 'email', $2 || '@example.com'))
+		RETURNING id`, userID, username).Scan(&eventID)
+
+	if _, err := db.Exec(`INSERT INTO cart_items (user_id, product_id, quantity) VALUES ($1, $2, 1)`, userID, productID); err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range []string{
+		`INSERT INTO sessions (user_id, device, user_agent, ip_address, expires_at) VALUES ($1, 'Firefox', 'Mozilla/5.0', '203.0.113.7', NOW() + INTERVAL '1 day')`,
+		`INSERT INTO user_identities (user_id, provider, subject, email) VALUES ($1, 'google', 'sub-' || $1, 'personal@gmail.example')`,
+		`INSERT INTO email_verification_tokens (user_id, email, token_hash, expires_at) VALUES ($1, 'x@new.example', md5($1::text) || md5('v'), NOW())`,
+		`INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES ($1, md5($1::text) || md5('r'), NOW())`,
+		`INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, md5($1::text) || md5('c'))`,
+	} {
+		if _, err := db.Exec(statement, userID); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+	if _, err := db.Exec(`INSERT INTO login_throttles (scope, key, failures) VALUES ('account', $1, 3)`, strings.ToLower(username)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO webhook_subscriptions (url, secret, event_types) VALUES ('https://hooks.example.com', 'secret', '{*}')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload)
+		SELECT id, $1, 'user.registered', jsonb_build_object('id', $1::bigint, 'data', o.payload)
+		FROM webhook_subscriptions, outbox_events o WHERE o.id = $1`, eventID); err != nil {
+		t.Fatal(err)
+	}
+	return userID, orderID
+}
+
+func TestErase(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	userID, orderID := createCustomer(t, db, "Ada")
+	otherID, _ := createCustomer(t, db, "grace")
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Erase(ctx, tx, userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	var username, email, passwordHash string
+	var pending, totpSecret sql.NullString
+	var verified, mfa, deleted, anonymized bool
+	err = db.QueryRow(`SELECT username, email, password_hash, pending_email, totp_secret, email_verified_at IS NOT NULL,
+		mfa_enabled_at IS NOT NULL, deleted_at IS NOT NULL, anonymized_at IS NOT NULL FROM users WHERE id = $1`, userID).
+		Scan(&username, &email, &passwordHash, &pending, &totpSecret, &verified, &mfa, &deleted, &anonymized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(username+email, "Ada") || strings.Contains(email, "example.com") || passwordHash != "" ||
+		pending.Valid || totpSecret.Valid || verified || mfa || !deleted || !anonymized {
+		t.Errorf("Erased user = %s %s %q %v %v verified=%v mfa=%v deleted=%v anonymized=%v",
+			username, email, passwordHash, pending, totpSecret, verified, mfa, deleted, anonymized)
+	}
+
+	for _, table := range []string{"cart_items", "sessions", "user_identities", "email_verification_tokens", "password_reset_tokens", "mfa_recovery_codes"} {
+		var left, others int
+		db.QueryRow(`SELECT COUNT(*) FILTER (WHERE user_id = $1), COUNT(*) FILTER (WHERE user_id = $2) FROM `+table, userID, otherID).Scan(&left, &others)
+		if left != 0 || others != 1 {
+			t.Errorf("%s has %d rows left for the erased user and %d for the other, want 0 and 1", table, left, others)
+		}
+	}
+	var throttles int
+	db.QueryRow(`SELECT COUNT(*) FROM login_throttles WHERE key = 'ada'`).Scan(&throttles)
+	if throttles != 0 {
+		t.Error("The erased user's login throttle was kept")
+	}
+
+	// Orders stay for accounting, still pointing at the anonymized account
+	var customerID int
+	var total, status string
+	if err := db.QueryRow(`SELECT customer_id, total_price, status FROM orders WHERE id = $1`, orderID).Scan(&customerID, &total, &status); err != nil {
+		t.Fatalf("Order was removed: %v", err)
+	}
+	if customerID != userID || total != "42.50" || status != "delivered" {
+		t.Errorf("Order = customer %d, %s, %s, want it unchanged", customerID, total, status)
+	}
+
+	// Events and deliveries no longer carry the username or address
+	var eventPayload, deliveryPayload string
+	db.QueryRow(`SELECT payload::text FROM outbox_events WHERE aggregate_id = $1 AND event_type = 'user.registered'`, userID).Scan(&eventPayload)
+	db.QueryRow(`SELECT d.payload::text FROM webhook_deliveries d JOIN outbox_events o ON o.id = d.event_id
+		WHERE o.aggregate_id = $1 AND o.event_type = 'user.registered'`, userID).Scan(&deliveryPayload)
+	for _, payload := range []string{eventPayload, deliveryPayload} {
+		if payload == "" || strings.Contains(payload, "Ada") {
+			t.Errorf("Payload after erasure = %s", payload)
+		}
+	}
+}
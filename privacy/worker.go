@@ -0,0 +1,249 @@
+// privacy/worker.go
+
+// Package privacy processes data export and erasure requests in the background.
+package privacy
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"gocommerce/config"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Request types
+const (
+	TypeExport  = "export"
+	TypeErasure = "erasure"
+)
+
+// Request statuses
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusCompleted  = "completed"
+	StatusFailed     = "failed"
+	StatusExpired    = "expired"
+)
+
+var (
+	// ExportDir is where export bundles are written until they expire
+	ExportDir = config.String("EXPORT_DIR", "exports")
+	// exportTTL is how long an export stays available for download
+	exportTTL = config.Duration("EXPORT_TTL", 7*24*time.Hour)
+)
+
+// Worker claims pending requests and runs them. Requests that fail are retried
+// until MaxAttempts, then left failed for an admin to look at and retry.
+type Worker struct {
+	DB           *sql.DB
+	PollInterval time.Duration
+	MaxAttempts  int
+	// Lease is how long a claimed request is hidden from other workers; a request
+	// whose worker died becomes claimable again once it runs out
+	Lease time.Duration
+	// Heartbeat, if set, is called before each request so health checks can tell the worker is alive
+	Heartbeat func()
+}
+
+func NewWorker(db *sql.DB) *Worker {
+	return &Worker{
+		DB:           db,
+		PollInterval: 5 * time.Second,
+		MaxAttempts:  3,
+		Lease:        10 * time.Minute,
+	}
+}
+
+// Run processes requests until ctx is cancelled.
+func (wk *Worker) Run(ctx context.Context) {
+	slog.Info("Data request worker started")
+	ticker := time.NewTicker(wk.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Work through the queue, then wait for the next tick
+		for {
+			if wk.Heartbeat != nil {
+				wk.Heartbeat()
+			}
+			processed, err := wk.processNext(ctx)
+			if err != nil {
+				slog.Error("Data request worker: error claiming request", "error", err)
+				break
+			}
+			if !processed {
+				break
+			}
+		}
+
+		if err := wk.expireExports(ctx); err != nil {
+			slog.Error("Data request worker: error expiring exports", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Info("Data request worker stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// processNext claims and runs one request. It returns false when there was nothing to do.
+func (wk *Worker) processNext(ctx context.Context) (bool, error) {
+	var id, userID, attempts int
+	var requestType string
+	// SKIP LOCKED lets several API instances share the queue
+	err := wk.DB.QueryRowContext(ctx, `
+		UPDATE data_requests SET status = $1, started_at = NOW(), attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM data_requests
+			WHERE status = $2 OR (status = $1 AND started_at < $3)
+			ORDER BY requested_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, user_id, request_type, attempts`,
+		StatusProcessing, StatusPending, time.Now().Add(-wk.Lease)).Scan(&id, &userID, &requestType, &attempts)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	slog.Info("Processing data request", "data_request_id", id, "type", requestType, "user_id", userID)
+
+	switch requestType {
+	case TypeExport:
+		err = wk.export(ctx, id, userID)
+	case TypeErasure:
+		err = wk.erase(ctx, id, userID)
+	default:
+		err = fmt.Errorf("unknown request type %q", requestType)
+	}
+	if err == nil {
+		return true, nil
+	}
+
+	// Retry later unless the request has used up its attempts
+	status := StatusPending
+	if attempts >= wk.MaxAttempts {
+		status = StatusFailed
+	}
+	slog.Error("Error processing data request", "data_request_id", id, "type", requestType, "attempt", attempts, "error", err)
+	_, dbErr := wk.DB.ExecContext(ctx, "UPDATE data_requests SET status = $1, last_error = $2 WHERE id = $3",
+		status, err.Error(), id)
+	return true, dbErr
+}
+
+// export writes the bundle to ExportDir and completes the request.
+func (wk *Worker) export(ctx context.Context, id, userID int) error {
+	if err := os.MkdirAll(ExportDir, 0o700); err != nil {
+		return err
+	}
+
+	fileName := fmt.Sprintf("export-%d-%d.zip", userID, id)
+	// Write to a temporary file first so a half-written bundle is never served
+	tmp, err := os.CreateTemp(ExportDir, fileName+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := WriteExport(ctx, wk.DB, userID, tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(ExportDir, fileName)); err != nil {
+		return err
+	}
+
+	_, err = wk.DB.ExecContext(ctx, `UPDATE data_requests SET status = $1, file_name = $2, completed_at = NOW(),
+		expires_at = $3, last_error = NULL WHERE id = $4`, StatusCompleted, fileName, time.Now().Add(exportTTL), id)
+	return err
+}
+
+// erase anonymizes the user and completes the request in one transaction.
+func (wk *Worker) erase(ctx context.Context, id, userID int) error {
+	tx, err := wk.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := Erase(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	// Earlier exports are personal data too. RETURNING sees the new row, so the
+	// old file names come from the CTE.
+	rows, err := tx.QueryContext(ctx, `WITH exports AS (
+			SELECT id, file_name FROM data_requests WHERE user_id = $2 AND file_name IS NOT NULL FOR UPDATE
+		)
+		UPDATE data_requests d SET status = $1, file_name = NULL FROM exports e WHERE d.id = e.id
+		RETURNING e.file_name`, StatusExpired, userID)
+	if err != nil {
+		return err
+	}
+	var files []string
+	for rows.Next() {
+		var fileName string
+		if err := rows.Scan(&fileName); err != nil {
+			rows.Close()
+			return err
+		}
+		files = append(files, fileName)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE data_requests SET status = $1, completed_at = NOW(), last_error = NULL
+		WHERE id = $2`, StatusCompleted, id); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, fileName := range files {
+		if err := os.Remove(filepath.Join(ExportDir, fileName)); err != nil && !os.IsNotExist(err) {
+			slog.Error("Error removing export", "file", fileName, "error", err)
+		}
+	}
+	return nil
+}
+
+// expireExports removes export bundles whose download period is over.
+func (wk *Worker) expireExports(ctx context.Context) error {
+	rows, err := wk.DB.QueryContext(ctx, `WITH exports AS (
+			SELECT id, file_name FROM data_requests
+			WHERE status = $2 AND expires_at < NOW() AND file_name IS NOT NULL
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE data_requests d SET status = $1, file_name = NULL FROM exports e WHERE d.id = e.id
+		RETURNING e.file_name`, StatusExpired, StatusCompleted)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var fileName string
+		if err := rows.Scan(&fileName); err != nil {
+			return err
+		}
+		if err := os.Remove(filepath.Join(ExportDir, fileName)); err != nil && !os.IsNotExist(err) {
+			slog.Error("Error removing export", "file", fileName, "error", err)
+		}
+	}
+	return rows.Err()
+}
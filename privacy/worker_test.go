@@ -0,0 +1,87 @@
+package privacy
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"gocommerce/dbtest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorker(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	defer func(dir string) { ExportDir = dir }(ExportDir)
+	ExportDir = t.TempDir()
+	wk := NewWorker(db)
+	userID, orderID := createCustomer(t, db, "ada")
+
+	file := func(requestType string) int {
+		t.Helper()
+		var id int
+		if err := db.QueryRow(`INSERT INTO data_requests (user_id, request_type) VALUES ($1, $2) RETURNING id`, userID, requestType).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		if processed, err := wk.processNext(ctx); err != nil || !processed {
+			t.Fatalf("processNext() = %v, %v", processed, err)
+		}
+		return id
+	}
+	request := func(id int) (status string, fileName *string) {
+		t.Helper()
+		if err := db.QueryRow(`SELECT status, file_name FROM data_requests WHERE id = $1`, id).Scan(&status, &fileName); err != nil {
+			t.Fatal(err)
+		}
+		return status, fileName
+	}
+
+	exportID := file(TypeExport)
+	status, fileName := request(exportID)
+	if status != StatusCompleted || fileName == nil {
+		t.Fatalf("Export request is %s with file %v", status, fileName)
+	}
+	bundle, err := zip.OpenReader(filepath.Join(ExportDir, *fileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var profile struct {
+		Username string `json:"username"`
+		Email    string `json:"email"`
+	}
+	var orders []struct {
+		ID int `json:"id"`
+	}
+	for _, f := range bundle.File {
+		r, _ := f.Open()
+		switch f.Name {
+		case "profile.json":
+			json.NewDecoder(r).Decode(&profile)
+		case "orders.json":
+			json.NewDecoder(r).Decode(&orders)
+		}
+		r.Close()
+	}
+	bundle.Close()
+	if len(bundle.File) != len(exportSections) || profile.Username != "ada" || profile.Email != "ada@example.com" ||
+		len(orders) != 1 || orders[0].ID != orderID {
+		t.Errorf("Export has %d files, profile %+v and orders %+v", len(bundle.File), profile, orders)
+	}
+
+	// Erasure takes earlier exports with it
+	erasureID := file(TypeErasure)
+	if status, _ := request(erasureID); status != StatusCompleted {
+		t.Errorf("Erasure request is %s, want completed", status)
+	}
+	if status, fileName := request(exportID); status != StatusExpired || fileName != nil {
+		t.Errorf("Export after the erasure is %s with file %v, want expired without one", status, fileName)
+	}
+	if entries, _ := os.ReadDir(ExportDir); len(entries) != 0 {
+		t.Errorf("%d files left in the export directory", len(entries))
+	}
+
+	if processed, err := wk.processNext(ctx); err != nil || processed {
+		t.Errorf("processNext() on an empty queue = %v, %v", processed, err)
+	}
+}
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"gocommerce/handlers"
+
+	"github.com/gorilla/mux"
+)
+
+func RegisterAPIKeyRoutes(router *mux.Router, apiKeyHandler handlers.APIKeyHandler) {
+	// Setting up routes for API keys (admin only)
+	router.HandleFunc("/api-keys", apiKeyHandler.GetAPIKeys).Methods("GET")                // Lists keys; ?include_revoked=true adds revoked and expired ones
+	router.HandleFunc("/api-keys", apiKeyHandler.CreateAPIKey).Methods("POST")             // Creates a key and returns it once
+	router.HandleFunc("/api-keys/{id}/rotate", apiKeyHandler.RotateAPIKey).Methods("POST") // Issues a replacement key
+	router.HandleFunc("/api-keys/{id}", apiKeyHandler.RevokeAPIKey).Methods("DELETE")      // Revokes a key
+}
@@ -8,7 +8,41 @@ import (
 
 func RegisterAuthenticationRoutes(router *mux.Router, authHandler handlers.AuthenticationHandler) {
 	// Setting up authentication routes
-	router.HandleFunc("/api/auth/login", authHandler.Login).Methods("POST")          // Endpoint for user login
-	router.HandleFunc("/api/auth/register", authHandler.Register).Methods("POST")    // Endpoint for user registration
-	router.HandleFunc("/api/auth/refresh", authHandler.RefreshToken).Methods("POST") // Endpoint for refreshing the authentication token
+	router.HandleFunc("/auth/login", authHandler.Login).Methods("POST")                      // Endpoint for user login
+	router.HandleFunc("/auth/register", authHandler.Register).Methods("POST")                // Endpoint for user registration
+	router.HandleFunc("/auth/refresh", authHandler.RefreshToken).Methods("POST")             // Endpoint for refreshing the authentication token
+	router.HandleFunc("/auth/logout", authHandler.Logout).Methods("POST")                    // Clears the session cookies
+	router.HandleFunc("/auth/forgot-password", authHandler.ForgotPassword).Methods("POST")   // Emails a password reset link
+	router.HandleFunc("/auth/reset-password", authHandler.ResetPassword).Methods("POST")     // Sets a new password using a reset token
+	router.HandleFunc("/auth/verify", authHandler.VerifyEmail).Methods("GET")                // Confirms an email address from the emailed link
+	router.HandleFunc("/auth/verify/resend", authHandler.ResendVerification).Methods("POST") // Sends a new verification email to the current user
+
+	// Two-factor authentication
+	router.HandleFunc("/auth/mfa/verify", authHandler.VerifyMFA).Methods("POST")                     // Completes a login with a TOTP or recovery code
+	router.HandleFunc("/auth/mfa/enroll", authHandler.EnrollMFAForLogin).Methods("POST")             // Starts the enrollment required by the MFA policy
+	router.HandleFunc("/auth/mfa/enroll/confirm", authHandler.ConfirmMFAForLogin).Methods("POST")    // Finishes required enrollment and logs in
+	router.HandleFunc("/me/mfa/enroll", authHandler.EnrollMFA).Methods("POST")                       // Starts enrollment for the current user
+	router.HandleFunc("/me/mfa/confirm", authHandler.ConfirmMFA).Methods("POST")                     // Enables MFA after checking a code
+	router.HandleFunc("/me/mfa/disable", authHandler.DisableMFA).Methods("POST")                     // Disables MFA
+	router.HandleFunc("/me/mfa/recovery-codes", authHandler.RegenerateRecoveryCodes).Methods("POST") // Replaces the recovery codes
+
+	// Social login with OpenID Connect providers
+	router.HandleFunc("/auth/oidc/{provider}/login", authHandler.OIDCLogin).Methods("GET")       // Redirects to the provider
+	router.HandleFunc("/auth/oidc/{provider}/callback", authHandler.OIDCCallback).Methods("GET") // Completes the login when the provider redirects back
+
+	// The current user's own account
+	router.HandleFunc("/me", authHandler.GetMe).Methods("GET")                    // Gets the current user's profile
+	router.HandleFunc("/me", authHandler.UpdateMe).Methods("PATCH")               // Changes the username or (after verification) the email address
+	router.HandleFunc("/me", authHandler.DeleteMe).Methods("DELETE")              // Deletes the current user's account
+	router.HandleFunc("/me/password", authHandler.ChangePassword).Methods("POST") // Changes the password after checking the current one
+
+	// Sessions
+	router.HandleFunc("/me/sessions", authHandler.GetSessions).Methods("GET")                   // Lists the current user's sessions
+	router.HandleFunc("/me/sessions/{id}", authHandler.RevokeSession).Methods("DELETE")         // Signs the current user out of a session
+	router.HandleFunc("/users/{id}/sessions", authHandler.RevokeUserSessions).Methods("DELETE") // Signs a user out everywhere (admin only)
+}
+
+func RegisterWellKnownRoutes(router *mux.Router, authHandler handlers.AuthenticationHandler) {
+	// Setting up routes other services discover by convention (no authentication)
+	router.HandleFunc("/.well-known/jwks.json", authHandler.JWKS).Methods("GET") // Public keys for verifying tokens
 }
@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"gocommerce/handlers"
+
+	"github.com/gorilla/mux"
+)
+
+func RegisterDataRequestRoutes(router *mux.Router, dataRequestHandler handlers.DataRequestHandler) {
+	// Setting up routes for the current user's data export and erasure requests
+	router.HandleFunc("/me/export", dataRequestHandler.RequestExport).Methods("POST")                      // Queues an export of the user's data
+	router.HandleFunc("/me/erasure", dataRequestHandler.RequestErasure).Methods("POST")                    // Closes the account and queues its erasure
+	router.HandleFunc("/me/data-requests", dataRequestHandler.GetMyDataRequests).Methods("GET")            // Lists the user's requests
+	router.HandleFunc("/me/data-requests/{id}/download", dataRequestHandler.DownloadExport).Methods("GET") // Downloads a completed export
+
+	// Setting up routes for the request queue (admin only)
+	router.HandleFunc("/data-requests", dataRequestHandler.GetDataRequests).Methods("GET")              // Lists requests; ?status= and ?type= filter
+	router.HandleFunc("/data-requests/{id}", dataRequestHandler.GetDataRequest).Methods("GET")          // To get a specific request by ID
+	router.HandleFunc("/data-requests/{id}/retry", dataRequestHandler.RetryDataRequest).Methods("POST") // Requeues a failed request
+	router.HandleFunc("/users/{id}/erasure", dataRequestHandler.RequestUserErasure).Methods("POST")     // Files an erasure on a user's behalf
+}
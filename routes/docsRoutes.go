@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"gocommerce/handlers"
+	"gocommerce/middleware"
+	"gocommerce/openapi"
+
+	"github.com/gorilla/mux"
+)
+
+// RegisterDocsRoutes serves the API reference on router, describing every route on documented.
+func RegisterDocsRoutes(router *mux.Router, documented *mux.Router) {
+	// Setting up routes for the API reference (no authentication)
+	router.Handle("/openapi.json", openapi.NewSpec(documented, handlers.APIDocs, middleware.IsPublicPath)).Methods("GET") // OpenAPI 3.1 document
+	router.Handle("/docs", openapi.DocsHandler()).Methods("GET")                                                          // Browsable reference rendered from the document
+}
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"gocommerce/handlers"
+	"gocommerce/metrics"
+
+	"github.com/gorilla/mux"
+)
+
+func RegisterHealthRoutes(router *mux.Router, healthHandler handlers.HealthHandler) {
+	// Setting up routes for the orchestrator's probes (no authentication)
+	router.HandleFunc("/healthz", healthHandler.Healthz).Methods("GET") // Liveness: the process is up
+	router.HandleFunc("/readyz", healthHandler.Readyz).Methods("GET")   // Readiness: dependencies and workers are healthy
+	router.Handle("/metrics", metrics.Handler()).Methods("GET")         // Prometheus scrape endpoint
+}
@@ -8,9 +8,10 @@ import (
 
 func RegisterOrderRoutes(router *mux.Router, orderHandler handlers.OrderHandler) {
 	// Setting up routes for orders
-	router.HandleFunc("/api/orders", orderHandler.GetOrders).Methods("GET")           // Endpoint to get all orders
-	router.HandleFunc("/api/orders/{id}", orderHandler.GetOrder).Methods("GET")       // Endpoint to get a specific order by ID
-	router.HandleFunc("/api/orders", orderHandler.CreateOrder).Methods("POST")        // Endpoint to create a new order
-	router.HandleFunc("/api/orders/{id}", orderHandler.UpdateOrder).Methods("PUT")    // Endpoint to update an existing order by ID
-	router.HandleFunc("/api/orders/{id}", orderHandler.DeleteOrder).Methods("DELETE") // Endpoint to delete an order by ID
+	router.HandleFunc("/orders", orderHandler.GetOrders).Methods("GET")                  // Endpoint to get all orders
+	router.HandleFunc("/orders/{id}", orderHandler.GetOrder).Methods("GET")              // Endpoint to get a specific order by ID
+	router.HandleFunc("/orders", orderHandler.CreateOrder).Methods("POST")               // Endpoint to create a new order
+	router.HandleFunc("/orders/{id}", orderHandler.UpdateOrder).Methods("PUT")           // Endpoint to update an existing order by ID
+	router.HandleFunc("/orders/{id}", orderHandler.DeleteOrder).Methods("DELETE")        // Endpoint to delete an order by ID
+	router.HandleFunc("/orders/{id}/restore", orderHandler.RestoreOrder).Methods("POST") // Endpoint to restore a deleted order (admin only)
 }
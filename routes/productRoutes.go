@@ -8,9 +8,10 @@ import (
 
 func RegisterProductRoutes(router *mux.Router, productHandler handlers.ProductHandler) {
 	// Setting up routes for products
-	router.HandleFunc("/api/products", productHandler.GetProducts).Methods("GET")
-	router.HandleFunc("/api/products/{id}", productHandler.GetProduct).Methods("GET")
-	router.HandleFunc("/api/products", productHandler.CreateProduct).Methods("POST")
-	router.HandleFunc("/api/products/{id}", productHandler.UpdateProduct).Methods("PUT")
-	router.HandleFunc("/api/products/{id}", productHandler.DeleteProduct).Methods("DELETE")
+	router.HandleFunc("/products", productHandler.GetProducts).Methods("GET")
+	router.HandleFunc("/products/{id}", productHandler.GetProduct).Methods("GET")
+	router.HandleFunc("/products", productHandler.CreateProduct).Methods("POST")
+	router.HandleFunc("/products/{id}", productHandler.UpdateProduct).Methods("PUT")
+	router.HandleFunc("/products/{id}", productHandler.DeleteProduct).Methods("DELETE")
+	router.HandleFunc("/products/{id}/restore", productHandler.RestoreProduct).Methods("POST")
 }
@@ -3,15 +3,71 @@
 package routes
 
 import (
+	"gocommerce/apiversion"
+	"gocommerce/apperrors"
 	"gocommerce/handlers"
+	"net/http"
+	"regexp"
+	"sync"
 
 	"github.com/gorilla/mux"
 )
 
+// RegisterAll registers every route. Each API version is a subrouter under its own
+// prefix, so a v2 can register new handlers for the endpoints it changes, reuse the
+// route functions below for the ones it doesn't, and be served alongside v1.
+// Unversioned /api paths are aliases of v1, see apiversion.Aliases.
 func RegisterAll(router *mux.Router, handlers *handlers.Handlers) {
+	v1 := router.PathPrefix(apiversion.V1.Prefix()).Subrouter()
+	RegisterV1(v1, handlers)
+	RegisterDocsRoutes(v1, router)
+	v1.NotFoundHandler = unmatched(v1)
+
+	// Infrastructure endpoints aren't part of the API and aren't versioned
+	RegisterWellKnownRoutes(router, handlers.AuthenticationHandler)
+	RegisterHealthRoutes(router, handlers.HealthHandler)
+}
+
+// RegisterV1 registers version 1 of the API on router, a subrouter for /api/v1.
+func RegisterV1(router *mux.Router, handlers *handlers.Handlers) {
 	RegisterProductRoutes(router, handlers.ProductHandler)
 	RegisterUserRoutes(router, handlers.UserHandler)
 	RegisterOrderRoutes(router, handlers.OrderHandler)
 	RegisterShoppingCartRoutes(router, handlers.ShoppingCartHandler)
 	RegisterAuthenticationRoutes(router, handlers.AuthenticationHandler)
+	RegisterWebhookRoutes(router, handlers.WebhookHandler)
+	RegisterAPIKeyRoutes(router, handlers.APIKeyHandler)
+	RegisterDataRequestRoutes(router, handlers.DataRequestHandler)
+}
+
+// unmatched answers requests under a version's prefix that no route matched. mux
+// can lose track of a method mismatch within a subrouter, answering 404 where the
+// root router answers 405, so the version's routes are checked for the path here.
+func unmatched(version *mux.Router) http.Handler {
+	var (
+		once  sync.Once
+		paths []*regexp.Regexp
+	)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Collected on first use, once every route has been registered
+		once.Do(func() {
+			version.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+				if _, err := route.GetMethods(); err != nil {
+					return nil
+				}
+				if pattern, err := route.GetPathRegexp(); err == nil {
+					paths = append(paths, regexp.MustCompile(pattern))
+				}
+				return nil
+			})
+		})
+
+		for _, path := range paths {
+			if path.MatchString(r.URL.Path) {
+				apperrors.MethodNotAllowedHandler().ServeHTTP(w, r)
+				return
+			}
+		}
+		apperrors.NotFoundHandler().ServeHTTP(w, r)
+	})
 }
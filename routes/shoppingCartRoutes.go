@@ -8,8 +8,8 @@ import (
 
 func RegisterShoppingCartRoutes(router *mux.Router, cartHandler handlers.ShoppingCartHandler) {
 	// Setting up routes for shopping cart
-	router.HandleFunc("/api/cart", cartHandler.GetCart).Methods("GET")                          // Retrieves the current user's shopping cart
-	router.HandleFunc("/api/cart/items", cartHandler.AddItemToCart).Methods("POST")             // Adds an item to the shopping cart
-	router.HandleFunc("/api/cart/items/{id}", cartHandler.UpdateCartItem).Methods("PUT")        // Updates the quantity of an item in the cart
-	router.HandleFunc("/api/cart/items/{id}", cartHandler.RemoveItemFromCart).Methods("DELETE") // Removes an item from the shopping cart
+	router.HandleFunc("/cart", cartHandler.GetCart).Methods("GET")                          // Retrieves the current user's shopping cart
+	router.HandleFunc("/cart/items", cartHandler.AddItemToCart).Methods("POST")             // Adds an item to the shopping cart
+	router.HandleFunc("/cart/items/{id}", cartHandler.UpdateCartItem).Methods("PUT")        // Updates the quantity of an item in the cart
+	router.HandleFunc("/cart/items/{id}", cartHandler.RemoveItemFromCart).Methods("DELETE") // Removes an item from the shopping cart
 }
@@ -7,9 +7,12 @@ import (
 )
 
 func RegisterUserRoutes(router *mux.Router, userHandler handlers.UserHandler) {
-	// Setting up routes for users
-	router.HandleFunc("/api/users/{id}", userHandler.GetUser).Methods("GET")       // To get a specific user by ID
-	router.HandleFunc("/api/users", userHandler.CreateUser).Methods("POST")        // To create a new user
-	router.HandleFunc("/api/users/{id}", userHandler.UpdateUser).Methods("PUT")    // To update an existing user by ID
-	router.HandleFunc("/api/users/{id}", userHandler.DeleteUser).Methods("DELETE") // To delete a user by ID
+	// Setting up routes for users (admin only, though API keys with users:read may list and get them;
+	// users manage their own account under /api/me)
+	router.HandleFunc("/users", userHandler.GetUsers).Methods("GET")                  // To list users
+	router.HandleFunc("/users/{id}", userHandler.GetUser).Methods("GET")              // To get a specific user by ID
+	router.HandleFunc("/users", userHandler.CreateUser).Methods("POST")               // To create a new user
+	router.HandleFunc("/users/{id}", userHandler.UpdateUser).Methods("PUT")           // To update an existing user by ID
+	router.HandleFunc("/users/{id}", userHandler.DeleteUser).Methods("DELETE")        // To delete a user by ID
+	router.HandleFunc("/users/{id}/restore", userHandler.RestoreUser).Methods("POST") // To restore a deleted user
 }
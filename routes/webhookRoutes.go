@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"gocommerce/handlers"
+
+	"github.com/gorilla/mux"
+)
+
+func RegisterWebhookRoutes(router *mux.Router, webhookHandler handlers.WebhookHandler) {
+	// Setting up routes for webhook subscriptions (admins, and API keys with a webhooks scope)
+	router.HandleFunc("/webhooks", webhookHandler.GetWebhooks).Methods("GET")                                 // Lists subscriptions
+	router.HandleFunc("/webhooks", webhookHandler.CreateWebhook).Methods("POST")                              // Creates a subscription
+	router.HandleFunc("/webhooks/{id}", webhookHandler.GetWebhook).Methods("GET")                             // Gets a subscription by ID
+	router.HandleFunc("/webhooks/{id}", webhookHandler.UpdateWebhook).Methods("PUT")                          // Updates a subscription
+	router.HandleFunc("/webhooks/{id}", webhookHandler.DeleteWebhook).Methods("DELETE")                       // Deletes a subscription
+	router.HandleFunc("/webhooks/{id}/deliveries", webhookHandler.GetWebhookDeliveries).Methods("GET")        // Delivery log of a subscription
+	router.HandleFunc("/webhooks/deliveries/{id}", webhookHandler.GetWebhookDelivery).Methods("GET")          // A delivery and its attempts
+	router.HandleFunc("/webhooks/deliveries/{id}/redeliver", webhookHandler.RedeliverWebhook).Methods("POST") // Sends a delivery again
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"gocommerce/config"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// serverConfig holds the HTTP server settings, read from the environment.
+type serverConfig struct {
+	Addr              string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	ShutdownTimeout   time.Duration // How long in-flight requests and workers get to finish
+	TLSCertFile       string        // TLS is enabled when both files are set
+	TLSKeyFile        string
+}
+
+func loadServerConfig() serverConfig {
+	return serverConfig{
+		Addr:              config.String("HTTP_ADDR", ":8088"),
+		ReadTimeout:       config.Duration("HTTP_READ_TIMEOUT", 15*time.Second),
+		ReadHeaderTimeout: config.Duration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second),
+		WriteTimeout:      config.Duration("HTTP_WRITE_TIMEOUT", 60*time.Second),
+		IdleTimeout:       config.Duration("HTTP_IDLE_TIMEOUT", 120*time.Second),
+		MaxHeaderBytes:    config.Int("HTTP_MAX_HEADER_BYTES", 1<<20),
+		ShutdownTimeout:   config.Duration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		TLSCertFile:       config.String("TLS_CERT_FILE", ""),
+		TLSKeyFile:        config.String("TLS_KEY_FILE", ""),
+	}
+}
+
+func (c serverConfig) tlsEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+func newServer(c serverConfig, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:              c.Addr,
+		Handler:           handler,
+		ReadTimeout:       c.ReadTimeout,
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+		MaxHeaderBytes:    c.MaxHeaderBytes,
+	}
+	if c.tlsEnabled() {
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return srv
+}
+
+// serve runs srv until ctx is cancelled, then stops accepting connections and waits
+// up to c.ShutdownTimeout for in-flight requests. It returns early if the server fails.
+func serve(ctx context.Context, srv *http.Server, c serverConfig) error {
+	errs := make(chan error, 1)
+	go func() {
+		if c.tlsEnabled() {
+			slog.Info("Server starting", "addr", c.Addr, "tls", true)
+			errs <- srv.ListenAndServeTLS(c.TLSCertFile, c.TLSKeyFile)
+		} else {
+			slog.Info("Server starting", "addr", c.Addr, "tls", false)
+			errs <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), c.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	// ListenAndServe returns ErrServerClosed as soon as Shutdown is called
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	slog.Info("Server stopped")
+	return nil
+}
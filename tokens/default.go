@@ -0,0 +1,64 @@
+// tokens/default.go
+
+package tokens
+
+import (
+	"errors"
+	"gocommerce/config"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DefaultRetention keeps retired keys for a day longer than the 7 day refresh token lifetime
+const DefaultRetention = 8 * 24 * time.Hour
+
+var defaultKeys *KeySet
+
+var errNotInitialized = errors.New("signing keys have not been loaded")
+
+// Init loads the application key set from JWT_KEYS_DIR. An empty directory is
+// initialised with a JWT_SIGNING_ALG key so development setups work out of the
+// box.
+func Init() error {
+	dir := config.String("JWT_KEYS_DIR", "keys")
+
+	ks, err := LoadKeySet(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		key, rotateErr := Rotate(dir, config.String("JWT_SIGNING_ALG", AlgRS256), DefaultRetention)
+		if rotateErr != nil {
+			return rotateErr
+		}
+		slog.Info("Created signing key", "kid", key.ID, "alg", key.Algorithm, "dir", dir)
+		ks, err = LoadKeySet(dir)
+	}
+	if err != nil {
+		return err
+	}
+
+	defaultKeys = ks
+	return nil
+}
+
+// Default returns the key set loaded by Init.
+func Default() *KeySet {
+	return defaultKeys
+}
+
+// Sign signs claims with the application's active key.
+func Sign(claims jwt.Claims) (string, error) {
+	if defaultKeys == nil {
+		return "", errNotInitialized
+	}
+	return defaultKeys.Sign(claims)
+}
+
+// Parse verifies a token against the application's key set.
+func Parse(tokenString string, claims jwt.Claims, opts ...jwt.ParserOption) (*jwt.Token, error) {
+	if defaultKeys == nil {
+		return nil, errNotInitialized
+	}
+	return defaultKeys.Parse(tokenString, claims, opts...)
+}
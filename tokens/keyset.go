@@ -0,0 +1,366 @@
+// tokens/keyset.go
+
+// Package tokens signs and verifies JWTs with asymmetric keys. Keys live in a
+// directory with a keys.json manifest. A new key is published in the JWKS a while
+// before it starts signing, and retired keys stay in the set until every token
+// they signed has expired, so rotating keys never logs anybody out and every
+// instance and verifier knows a key before it sees a token signed with it.
+package tokens
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported signing algorithms
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+const manifestFile = "keys.json"
+
+// reloadInterval is how often a key set re-reads its directory, picking up keys
+// rotated by another process
+const reloadInterval = 10 * time.Second
+
+// PublishAhead is how long a rotated key is published before it starts signing. It
+// covers every instance's next reload and the 5 minutes verifiers may cache the JWKS.
+var PublishAhead = 10 * time.Minute
+
+// Key is a signing key pair
+type Key struct {
+	ID          string
+	Algorithm   string
+	Private     crypto.Signer
+	Public      crypto.PublicKey
+	CreatedAt   time.Time
+	ActivatesAt time.Time  // When it starts signing; until then it is only published
+	RetiredAt   *time.Time // When another key took over; the key then only verifies
+}
+
+type manifestEntry struct {
+	ID        string    `json:"kid"`
+	Algorithm string    `json:"alg"`
+	File      string    `json:"file"`
+	CreatedAt time.Time `json:"created_at"`
+	// Keys written before keys were published ahead are active from created_at
+	ActivatesAt *time.Time `json:"activates_at,omitempty"`
+	RetiredAt   *time.Time `json:"retired_at,omitempty"`
+}
+
+// activatesAt is when the entry's key starts signing
+func (e manifestEntry) activatesAt() time.Time {
+	if e.ActivatesAt != nil {
+		return *e.ActivatesAt
+	}
+	return e.CreatedAt
+}
+
+type manifest struct {
+	// Active is the newest key. It signs from its activation time, and the key
+	// it replaces until then.
+	Active string          `json:"active"`
+	Keys   []manifestEntry `json:"keys"`
+}
+
+// KeySet holds the signing keys, the published ones not active yet and the retiring
+// ones still accepted for verification.
+type KeySet struct {
+	mu         sync.RWMutex
+	dir        string
+	keys       map[string]*Key
+	lastReload time.Time
+}
+
+var errNoActiveKey = errors.New("no active signing key")
+
+// LoadKeySet reads the keys in dir.
+func LoadKeySet(dir string) (*KeySet, error) {
+	ks := &KeySet{dir: dir}
+	if err := ks.Reload(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Reload re-reads the manifest and key files from disk.
+func (ks *KeySet) Reload() error {
+	m, err := readManifest(ks.dir)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*Key, len(m.Keys))
+	for _, entry := range m.Keys {
+		key, err := loadKey(ks.dir, entry)
+		if err != nil {
+			return fmt.Errorf("error loading key %s: %w", entry.ID, err)
+		}
+		keys[key.ID] = key
+	}
+
+	if _, ok := keys[m.Active]; !ok {
+		return errNoActiveKey
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = keys
+	ks.lastReload = time.Now()
+	return nil
+}
+
+// refresh reloads the set if it was last read more than reloadInterval ago. A
+// failed reload keeps the keys already loaded and is retried an interval later.
+func (ks *KeySet) refresh() {
+	ks.mu.RLock()
+	stale := time.Since(ks.lastReload) > reloadInterval
+	ks.mu.RUnlock()
+	if !stale {
+		return
+	}
+
+	if err := ks.Reload(); err != nil {
+		slog.Error("Error reloading signing keys, keeping the loaded ones", "dir", ks.dir, "error", err)
+		ks.mu.Lock()
+		ks.lastReload = time.Now()
+		ks.mu.Unlock()
+	}
+}
+
+// Active returns the key new tokens are signed with: the key activated most recently.
+func (ks *KeySet) Active() *Key {
+	ks.refresh()
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	now := time.Now()
+	var active *Key
+	for _, key := range ks.keys {
+		if key.ActivatesAt.After(now) {
+			continue
+		}
+		if active == nil || key.ActivatesAt.After(active.ActivatesAt) {
+			active = key
+		}
+	}
+	return active
+}
+
+// lookup finds a key by ID.
+func (ks *KeySet) lookup(kid string) (*Key, bool) {
+	ks.refresh()
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+// Sign signs claims with the active key and sets the kid header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key := ks.Active()
+	if key == nil {
+		return "", errNoActiveKey
+	}
+
+	token := jwt.NewWithClaims(signingMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// Parse verifies tokenString and decodes it into claims. opts are passed to the
+// jwt parser, for example to require an issuer or audience.
+func (ks *KeySet) Parse(tokenString string, claims jwt.Claims, opts ...jwt.ParserOption) (*jwt.Token, error) {
+	opts = append(opts, jwt.WithValidMethods([]string{AlgRS256, AlgEdDSA}))
+
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no kid header")
+		}
+
+		key, ok := ks.lookup(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		// The algorithm is pinned by the key, never taken from the token alone
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("token algorithm %s does not match key %s", token.Method.Alg(), kid)
+		}
+		return key.Public, nil
+	}, opts...)
+}
+
+func signingMethod(alg string) jwt.SigningMethod {
+	if alg == AlgEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// GenerateKey creates a new key pair for alg.
+func GenerateKey(alg string) (*Key, error) {
+	var private crypto.Signer
+	var err error
+	switch alg {
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	return &Key{
+		ID:        now.Format("20060102") + "-" + hex.EncodeToString(suffix),
+		Algorithm: alg,
+		Private:   private,
+		Public:    private.Public(),
+		CreatedAt: now,
+	}, nil
+}
+
+// Rotate generates a new key in dir that takes over from the active one after
+// PublishAhead, and retires the previous key from then. Keys retired for longer
+// than retainFor are removed; retainFor must exceed the lifetime of the
+// longest-lived token. Rotate also initialises an empty dir, whose first key is
+// active straight away.
+func Rotate(dir, alg string, retainFor time.Duration) (*Key, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	m, err := readManifest(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := GenerateKey(alg)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return nil, err
+	}
+	file := key.ID + ".pem"
+	if err := os.WriteFile(filepath.Join(dir, file), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	activatesAt := now
+	if len(m.Keys) > 0 {
+		activatesAt = now.Add(PublishAhead)
+	}
+	key.ActivatesAt = activatesAt
+
+	var kept []manifestEntry
+	for _, entry := range m.Keys {
+		// Every key not retired yet, including one still waiting to activate, is replaced by this one
+		if entry.RetiredAt == nil {
+			entry.RetiredAt = &activatesAt
+		}
+		if now.Sub(*entry.RetiredAt) > retainFor {
+			os.Remove(filepath.Join(dir, entry.File))
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	kept = append(kept, manifestEntry{ID: key.ID, Algorithm: alg, File: file, CreatedAt: key.CreatedAt, ActivatesAt: &activatesAt})
+
+	if err := writeManifest(dir, manifest{Active: key.ID, Keys: kept}); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func readManifest(dir string) (manifest, error) {
+	var m manifest
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+// writeManifest replaces the manifest atomically so a running server never reads half of it.
+func writeManifest(dir string, m manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, manifestFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, manifestFile))
+}
+
+func loadKey(dir string, entry manifestEntry) (*Key, error) {
+	data, err := os.ReadFile(filepath.Join(dir, entry.File))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	private, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("key cannot sign")
+	}
+	switch private.(type) {
+	case *rsa.PrivateKey:
+		if entry.Algorithm != AlgRS256 {
+			return nil, fmt.Errorf("RSA key listed as %s", entry.Algorithm)
+		}
+	case ed25519.PrivateKey:
+		if entry.Algorithm != AlgEdDSA {
+			return nil, fmt.Errorf("Ed25519 key listed as %s", entry.Algorithm)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+
+	return &Key{
+		ID:          entry.ID,
+		Algorithm:   entry.Algorithm,
+		Private:     private,
+		Public:      private.Public(),
+		CreatedAt:   entry.CreatedAt,
+		ActivatesAt: entry.activatesAt(),
+		RetiredAt:   entry.RetiredAt,
+	}, nil
+}
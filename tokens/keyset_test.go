@@ -0,0 +1,137 @@
+package tokens
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func published(ks *KeySet, kid string) bool {
+	for _, jwk := range ks.JWKS().Keys {
+		if jwk.KeyID == kid {
+			return true
+		}
+	}
+	return false
+}
+
+func signedBy(t *testing.T, ks *KeySet) string {
+	t.Helper()
+	token, err := ks.Sign(jwt.RegisteredClaims{Subject: "1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Parse(token, &jwt.RegisteredClaims{}); err != nil {
+		t.Errorf("Parse() of a token the set signed: %v", err)
+	}
+	return parsed.Header["kid"].(string)
+}
+
+func TestRotate(t *testing.T) {
+	for _, alg := range []string{AlgRS256, AlgEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			dir := t.TempDir()
+			first, err := Rotate(dir, alg, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ks, err := LoadKeySet(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The first key of a new set signs straight away
+			if kid := signedBy(t, ks); kid != first.ID {
+				t.Errorf("Signed with %s, want the first key %s", kid, first.ID)
+			}
+			oldToken, _ := ks.Sign(jwt.RegisteredClaims{Subject: "1"})
+
+			second, err := Rotate(dir, alg, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !second.ActivatesAt.After(time.Now().Add(PublishAhead - time.Minute)) {
+				t.Errorf("New key activates at %s, want about %s from now", second.ActivatesAt, PublishAhead)
+			}
+			if err := ks.Reload(); err != nil {
+				t.Fatal(err)
+			}
+			// The new key is published before it signs anything
+			if !published(ks, second.ID) || !published(ks, first.ID) {
+				t.Errorf("JWKS = %+v, want both keys", ks.JWKS())
+			}
+			if kid := signedBy(t, ks); kid != first.ID {
+				t.Errorf("Signed with %s before the new key activated, want %s", kid, first.ID)
+			}
+
+			// Once active, the new key signs and the old one still verifies
+			ks.keys[second.ID].ActivatesAt = time.Now()
+			if kid := signedBy(t, ks); kid != second.ID {
+				t.Errorf("Signed with %s after the new key activated, want %s", kid, second.ID)
+			}
+			if _, err := ks.Parse(oldToken, &jwt.RegisteredClaims{}); err != nil {
+				t.Errorf("Token signed by the retired key no longer verifies: %v", err)
+			}
+		})
+	}
+}
+
+func TestRotatePrunesRetiredKeys(t *testing.T) {
+	dir := t.TempDir()
+	first, _ := Rotate(dir, AlgEdDSA, time.Hour)
+
+	defer func(ahead time.Duration) { PublishAhead = ahead }(PublishAhead)
+	PublishAhead = -2 * time.Hour // The first key retired well past the retention
+	Rotate(dir, AlgEdDSA, time.Hour)
+	PublishAhead = time.Minute
+	third, err := Rotate(dir, AlgEdDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err := LoadKeySet(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if published(ks, first.ID) || !published(ks, third.ID) || len(ks.JWKS().Keys) != 2 {
+		t.Errorf("JWKS = %+v, want the first key pruned", ks.JWKS())
+	}
+}
+
+// TestRefresh checks a running set picks up a key rotated by another process
+// without seeing a token signed with it first.
+func TestRefresh(t *testing.T) {
+	dir := t.TempDir()
+	Rotate(dir, AlgEdDSA, time.Hour)
+	ks, err := LoadKeySet(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := Rotate(dir, AlgEdDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if published(ks, next.ID) {
+		t.Fatal("Set reloaded before reloadInterval passed")
+	}
+	ks.lastReload = time.Now().Add(-2 * reloadInterval)
+	if !published(ks, next.ID) {
+		t.Error("Stale set didn't reload for the JWKS")
+	}
+
+	// A manifest that can't be read keeps the loaded keys in use
+	if err := os.WriteFile(filepath.Join(dir, manifestFile), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ks.lastReload = time.Now().Add(-2 * reloadInterval)
+	if ks.Active() == nil || !published(ks, next.ID) {
+		t.Error("Failed reload dropped the loaded keys")
+	}
+}
@@ -0,0 +1,104 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the RFC 6238 appendix B SHA1 key, "12345678901234567890", base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestCodeAt checks the RFC 6238 test vectors, truncated to 6 digits
+func TestCodeAt(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := CodeAt(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil || got != tt.want {
+			t.Errorf("CodeAt(%d) = %q, %v, want %q", tt.unix, got, err, tt.want)
+		}
+	}
+
+	// Secrets are accepted the way people copy them
+	if got, _ := CodeAt(" gezdgnbvgy3tqojqgezdgnbvgy3tqojq ", Step(time.Unix(59, 0))); got != "287082" {
+		t.Errorf("CodeAt() with a lower case secret = %q", got)
+	}
+	if _, err := CodeAt("not base32!", 1); err == nil {
+		t.Error("CodeAt() accepted an invalid secret")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := Step(now)
+	code := func(step int64) string {
+		c, err := CodeAt(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", rfcSecret, code(step), step, true},
+		{"previous step", rfcSecret, code(step - 1), step - 1, true},
+		{"next step", rfcSecret, code(step + 1), step + 1, true},
+		{"surrounding spaces", rfcSecret, " " + code(step) + " ", step, true},
+		{"outside the skew", rfcSecret, code(step - 2), 0, false},
+		{"wrong length", rfcSecret, code(step)[:5], 0, false},
+		{"wrong code", rfcSecret, "000000", 0, false},
+		{"invalid secret", "not base32!", code(step), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := Validate(tt.secret, tt.code, now)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("Validate() = %d, %v, want %d, %v", gotStep, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := GenerateSecret()
+	if a == b || len(a) != 32 {
+		t.Errorf("GenerateSecret() = %q, %q, want distinct 32 character secrets", a, b)
+	}
+	if _, err := CodeAt(a, 1); err != nil {
+		t.Errorf("Generated secret doesn't decode: %v", err)
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri, err := url.Parse(ProvisioningURI("Go Commerce", "ada@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := uri.Query()
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Go Commerce:ada@example.com" {
+		t.Errorf("ProvisioningURI() = %s", uri)
+	}
+	if q.Get("secret") != rfcSecret || q.Get("issuer") != "Go Commerce" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("ProvisioningURI() parameters = %v", q)
+	}
+}
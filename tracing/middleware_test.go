@@ -0,0 +1,65 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMiddleware(t *testing.T) {
+	recorder := recordSpans(t)
+
+	var handlerSpan trace.SpanContext
+	r := mux.NewRouter()
+	r.Use(Middleware)
+	r.HandleFunc("/api/v1/products/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	const (
+		traceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+		callerID = "00f067aa0ba902b7"
+	)
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/products/42", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-"+callerID+"-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("Recorded %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	// The caller's trace continues through this service
+	if span.SpanContext().TraceID().String() != traceID || span.Parent().SpanID().String() != callerID || !span.Parent().IsRemote() {
+		t.Errorf("Span is in trace %s under %s, want trace %s under the caller's span %s",
+			span.SpanContext().TraceID(), span.Parent().SpanID(), traceID, callerID)
+	}
+	if handlerSpan.SpanID() != span.SpanContext().SpanID() {
+		t.Error("Handler context doesn't carry the server span")
+	}
+	if span.Name() != "GET /api/v1/products/{id}" || span.SpanKind() != trace.SpanKindServer ||
+		attr(span, "http.route") != "/api/v1/products/{id}" || attr(span, "http.response.status_code") != "200" {
+		t.Errorf("Server span = %s %v", span.Name(), span.Attributes())
+	}
+	if span.Status().Code == codes.Error {
+		t.Error("Successful request recorded as an error")
+	}
+
+	// Without a traceparent a new trace starts, and server errors mark the span
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/products/42?fail=1", nil))
+	spans = recorder.Ended()
+	span = spans[len(spans)-1]
+	if span.Parent().IsValid() || span.SpanContext().TraceID().String() == traceID {
+		t.Errorf("Request without traceparent joined trace %s", span.SpanContext().TraceID())
+	}
+	if span.Status().Code != codes.Error || attr(span, "http.response.status_code") != "500" {
+		t.Errorf("Failed request span has status %v", span.Status())
+	}
+}
@@ -0,0 +1,306 @@
+// tracing/sql.go
+
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RegisterDriver registers a traced wrapper of base under name, for sql.Open.
+func RegisterDriver(name string, base driver.Driver) {
+	sql.Register(name, &tracedDriver{base: base})
+}
+
+// SanitizeSQL replaces literal values in query with ? so spans never carry data:
+// strings, including escape (E'...') and dollar-quoted ones, and numbers. Comments
+// are dropped. Bind parameters ($1) are kept; their values are never recorded.
+func SanitizeSQL(query string) string {
+	var out strings.Builder
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'':
+			i = skipString(query, i, false)
+			out.WriteByte('?')
+		case (c == 'e' || c == 'E') && i+1 < len(query) && query[i+1] == '\'' && !isIdentByte(prev(query, i)):
+			i = skipString(query, i+1, true)
+			out.WriteByte('?')
+		case c == '$' && i+1 < len(query) && isDigit(query[i+1]):
+			// Bind parameter
+			j := i + 1
+			for j < len(query) && isDigit(query[j]) {
+				j++
+			}
+			out.WriteString(query[i:j])
+			i = j
+		case c == '$' && !isIdentByte(prev(query, i)):
+			tag, ok := dollarTag(query, i)
+			if !ok {
+				out.WriteByte(c)
+				i++
+				continue
+			}
+			end := strings.Index(query[i+len(tag):], tag)
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += len(tag) + end + len(tag)
+			}
+			out.WriteByte('?')
+		case c == '"':
+			// Quoted identifier, kept as is
+			j := i + 1
+			for j < len(query) {
+				if query[j] == '"' && !strings.HasPrefix(query[j:], `""`) {
+					j++
+					break
+				}
+				if query[j] == '"' {
+					j++
+				}
+				j++
+			}
+			j = min(j, len(query))
+			out.WriteString(query[i:j])
+			i = j
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			i += end
+			out.WriteByte(' ')
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += 2 + end + 2
+			}
+			out.WriteByte(' ')
+		case (isDigit(c) || (c == '.' && i+1 < len(query) && isDigit(query[i+1]))) && !isIdentByte(prev(query, i)):
+			i = skipNumber(query, i)
+			out.WriteByte('?')
+		case isIdentByte(c):
+			j := i
+			for j < len(query) && isIdentByte(query[j]) {
+				j++
+			}
+			out.WriteString(query[i:j])
+			i = j
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return strings.Join(strings.Fields(out.String()), " ")
+}
+
+// skipString returns the index just past the string literal opening at query[i].
+// Quotes are escaped by doubling them, and in escape strings by a backslash too.
+func skipString(query string, i int, backslashEscapes bool) int {
+	for j := i + 1; j < len(query); j++ {
+		switch {
+		case backslashEscapes && query[j] == '\\':
+			j++
+		case query[j] == '\'' && j+1 < len(query) && query[j+1] == '\'':
+			j++
+		case query[j] == '\'':
+			return j + 1
+		}
+	}
+	return len(query)
+}
+
+// dollarTag returns the $tag$ opening a dollar-quoted string at query[i]
+func dollarTag(query string, i int) (string, bool) {
+	for j := i + 1; j < len(query); j++ {
+		if query[j] == '$' {
+			return query[i : j+1], true
+		}
+		if !isIdentByte(query[j]) || isDigit(query[j]) && j == i+1 {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// skipNumber returns the index just past the numeric literal starting at query[i]
+func skipNumber(query string, i int) int {
+	j := i
+	for j < len(query) && (isIdentByte(query[j]) || query[j] == '.') {
+		// Exponents may be signed: 1e-5
+		if (query[j] == 'e' || query[j] == 'E') && j+1 < len(query) && (query[j+1] == '+' || query[j+1] == '-') {
+			j++
+		}
+		j++
+	}
+	return j
+}
+
+func prev(query string, i int) byte {
+	if i == 0 {
+		return ' '
+	}
+	return query[i-1]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// operation returns the statement's leading keyword, used as the span name.
+func operation(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "SQL"
+	}
+	return strings.ToUpper(fields[0])
+}
+
+type tracedDriver struct {
+	base driver.Driver
+}
+
+func (d *tracedDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.base.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedConn{Conn: conn}, nil
+}
+
+// tracedConn wraps a driver connection. database/sql hands a connection to one
+// transaction at a time, so the open transaction's span is kept on the connection
+// and becomes the parent of statements run inside it, including those called
+// without a context.
+type tracedConn struct {
+	driver.Conn
+	txCtx context.Context
+}
+
+func (c *tracedConn) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if c.txCtx != nil {
+		// Only the parent span comes from the transaction; cancellation stays with ctx
+		ctx = trace.ContextWithSpan(ctx, trace.SpanFromContext(c.txCtx))
+	}
+	attrs = append(attrs, attribute.String("db.system", "postgresql"))
+	return tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil && err != driver.ErrSkip {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (c *tracedConn) statementSpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	return c.startSpan(ctx, operation(query),
+		attribute.String("db.statement", SanitizeSQL(query)),
+		attribute.String("db.operation", operation(query)))
+}
+
+func (c *tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, span := c.statementSpan(ctx, query)
+	rows, err := queryer.QueryContext(ctx, query, args)
+	endSpan(span, err)
+	return rows, err
+}
+
+func (c *tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, span := c.statementSpan(ctx, query)
+	result, err := execer.ExecContext(ctx, query, args)
+	endSpan(span, err)
+	return result, err
+}
+
+func (c *tracedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	txCtx, span := c.startSpan(ctx, "transaction")
+	var tx driver.Tx
+	var err error
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = beginner.BeginTx(txCtx, opts)
+	} else {
+		tx, err = c.Conn.Begin() // Fallback for drivers without BeginTx
+	}
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	c.txCtx = txCtx
+	return &tracedTx{Tx: tx, conn: c, span: span}, nil
+}
+
+func (c *tracedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// tracedTx ends the transaction span on commit or rollback
+type tracedTx struct {
+	driver.Tx
+	conn *tracedConn
+	span trace.Span
+}
+
+func (t *tracedTx) Commit() error {
+	err := t.Tx.Commit()
+	t.finish("commit", err)
+	return err
+}
+
+func (t *tracedTx) Rollback() error {
+	err := t.Tx.Rollback()
+	t.finish("rollback", err)
+	return err
+}
+
+func (t *tracedTx) finish(outcome string, err error) {
+	t.conn.txCtx = nil
+	t.span.SetAttributes(attribute.String("db.transaction.outcome", outcome))
+	endSpan(t.span, err)
+}
@@ -0,0 +1,144 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSanitizeSQL(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"string", "SELECT id FROM users WHERE email = 'a@b.com'", "SELECT id FROM users WHERE email = ?"},
+		{"doubled quote", "UPDATE users SET username = 'o''brien' WHERE id = 1", "UPDATE users SET username = ? WHERE id = ?"},
+		{"escape string", `SELECT 1 WHERE note = E'it\'s a@b.com' AND x = e'\\'`, "SELECT ? WHERE note = ? AND x = ?"},
+		{"dollar quoted", "SELECT $$a@b.com$$, $tag$it's $$ secret$tag$", "SELECT ?, ?"},
+		{"bind parameters", "SELECT * FROM orders WHERE id = $1 AND total > $12", "SELECT * FROM orders WHERE id = $1 AND total > $12"},
+		{"numbers", "SELECT * FROM t1 LIMIT 10 OFFSET 2.5 WHERE x > 1e-5 AND y = .5", "SELECT * FROM t1 LIMIT ? OFFSET ? WHERE x > ? AND y = ?"},
+		{"quoted identifier", `SELECT "it's" FROM "a""b"`, `SELECT "it's" FROM "a""b"`},
+		{"comments", "SELECT 1 -- a@b.com\nFROM t /* secret */", "SELECT ? FROM t"},
+		{"unterminated string", "SELECT 'a@b.com", "SELECT ?"},
+		{"whitespace", "SELECT\n\tid\n  FROM users", "SELECT id FROM users"},
+		{"typed literal", "SELECT X'FF', interval '1 day'", "SELECT X?, interval ?"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SanitizeSQL(tt.query)
+			if got != tt.want {
+				t.Errorf("SanitizeSQL(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+			for _, secret := range []string{"a@b.com", "secret", "brien"} {
+				if strings.Contains(got, secret) {
+					t.Errorf("SanitizeSQL(%q) leaks %q", tt.query, secret)
+				}
+			}
+		})
+	}
+}
+
+// fakeDriver is a database that accepts every statement. Statements containing
+// "fail" return errFake.
+type fakeDriver struct{}
+
+var errFake = errors.New("statement failed")
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return fakeTx{}, nil }
+
+func (fakeConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	if strings.Contains(query, "fail") {
+		return nil, errFake
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (fakeConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return fakeRows{}, nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string         { return []string{"n"} }
+func (fakeRows) Close() error              { return nil }
+func (fakeRows) Next([]driver.Value) error { return io.EOF }
+
+func init() {
+	RegisterDriver("traced-fake", fakeDriver{})
+}
+
+func TestTracedDriver(t *testing.T) {
+	recorder := recordSpans(t)
+	db, err := sql.Open("traced-fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, parent := tracer().Start(context.Background(), "request")
+
+	if _, err := db.ExecContext(ctx, "UPDATE users SET email = 'a@b.com' WHERE id = $1", 7); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Statements without a context still join the transaction's trace
+	rows, err := tx.Query("SELECT 42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	if _, err := tx.Exec("DELETE FROM fail"); err != errFake {
+		t.Fatalf("Exec() = %v, want the driver's error", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 5 {
+		t.Fatalf("Recorded %d spans, want 5", len(spans))
+	}
+	update, query, failed, transaction := spans[0], spans[1], spans[2], spans[3]
+
+	if update.Name() != "UPDATE" || update.SpanKind() != trace.SpanKindClient ||
+		attr(update, "db.statement") != "UPDATE users SET email = ? WHERE id = $1" || attr(update, "db.system") != "postgresql" {
+		t.Errorf("UPDATE span = %s %v", update.Name(), update.Attributes())
+	}
+	if update.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("UPDATE span isn't a child of the request span")
+	}
+
+	if transaction.Name() != "transaction" || attr(transaction, "db.transaction.outcome") != "rollback" ||
+		transaction.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("Transaction span = %s %v", transaction.Name(), transaction.Attributes())
+	}
+	if query.Name() != "SELECT" || query.Parent().SpanID() != transaction.SpanContext().SpanID() {
+		t.Errorf("SELECT in the transaction has parent %s, want the transaction span", query.Parent().SpanID())
+	}
+	if failed.Parent().SpanID() != transaction.SpanContext().SpanID() || failed.Status().Code != codes.Error || len(failed.Events()) != 1 {
+		t.Errorf("Failed statement span has status %v and %d events, want an error recorded in the transaction", failed.Status(), len(failed.Events()))
+	}
+}
@@ -0,0 +1,84 @@
+// tracing/tracing.go
+
+// Package tracing sets up OpenTelemetry tracing: the exporter, W3C trace context
+// propagation, a server middleware and a database/sql driver that traces queries.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"gocommerce/config"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "gocommerce"
+
+// tracer is resolved through the global provider on each use, so spans started
+// before Init (or without it, in tests) go to the no-op provider
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Init configures the global tracer provider from the environment and returns a
+// function that flushes and stops it.
+//
+// TRACING_EXPORTER selects where spans go: "otlp" (OTLP over HTTP, configured with
+// the standard OTEL_EXPORTER_OTLP_* variables), "stdout", or "none" (the default),
+// which records nothing. TRACING_SAMPLE_RATIO sets the fraction of new traces kept;
+// incoming requests follow the caller's sampling decision.
+func Init(ctx context.Context) (func(context.Context) error, error) {
+	// Trace context is propagated even when nothing is exported, so callers'
+	// traces continue through this service's outgoing calls
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch name := strings.ToLower(config.String("TRACING_EXPORTER", "none")); name {
+	case "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unknown TRACING_EXPORTER %q", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating trace exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", config.String("OTEL_SERVICE_NAME", "gocommerce")),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	ratio := config.String("TRACING_SAMPLE_RATIO", "1")
+	sampleRatio, err := strconv.ParseFloat(ratio, 64)
+	if err != nil || sampleRatio < 0 || sampleRatio > 1 {
+		slog.Warn("Invalid TRACING_SAMPLE_RATIO, sampling every trace", "value", ratio)
+		sampleRatio = 1
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	slog.Info("Tracing enabled", "exporter", config.String("TRACING_EXPORTER", "none"), "sample_ratio", sampleRatio)
+	return provider.Shutdown, nil
+}
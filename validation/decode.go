@@ -0,0 +1,68 @@
+// validation/decode.go
+
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"gocommerce/apperrors"
+	"gocommerce/config"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MaxBodyBytes is the largest JSON body Decode reads, from REQUEST_MAX_BODY_BYTES
+var MaxBodyBytes = int64(config.Int("REQUEST_MAX_BODY_BYTES", 1<<20))
+
+// Decode reads r's JSON body into v and checks it with Struct. The returned error
+// is an *apperrors.Error ready to be written; it lists every invalid field.
+func Decode(w http.ResponseWriter, r *http.Request, v any) error {
+	if err := decodeJSON(w, r, v); err != nil {
+		return err
+	}
+	if errs := Struct(v); len(errs) > 0 {
+		return apperrors.Validation("Request body failed validation", errs...)
+	}
+	return nil
+}
+
+// DecodeOptional is Decode for endpoints whose body may be left out entirely.
+func DecodeOptional(w http.ResponseWriter, r *http.Request, v any) error {
+	if r.ContentLength == 0 {
+		return nil
+	}
+	return Decode(w, r, v)
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBodyBytes))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(v)
+	if err == nil {
+		// A body holds exactly one JSON value
+		if _, extra := dec.Token(); extra != io.EOF {
+			err = errors.New("unexpected data after JSON value")
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		return apperrors.New(http.StatusRequestEntityTooLarge, apperrors.CodeRequestTooLarge,
+			"Request body must not be larger than "+strconv.FormatInt(MaxBodyBytes, 10)+" bytes")
+	case errors.Is(err, io.EOF):
+		return apperrors.New(http.StatusBadRequest, apperrors.CodeInvalidJSON, "Request body is empty")
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no typed error for unknown fields
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		return apperrors.Validation("Request body has an unknown field", apperrors.FieldError{Field: field, Message: "is not a known field"})
+	default:
+		return apperrors.InvalidJSON(err)
+	}
+}
@@ -0,0 +1,208 @@
+// validation/validation.go
+
+// Package validation checks request bodies against rules declared in `validate`
+// struct tags, and decodes JSON bodies strictly: unknown fields, trailing data and
+// oversized bodies are rejected. Every failing field is reported, not just the first.
+//
+// Rules are separated by commas:
+//
+//	required     strings must not be blank, numbers non-zero, pointers non-nil, slices non-empty
+//	min=N, max=N length of strings (in characters) and slices, value of numbers
+//	oneof=a b c  strings must be one of the listed values
+//	email        a single address such as jane@example.com
+//	url          an absolute http or https URL
+//	username     letters, digits, '.', '_' and '-'
+//
+// Optional strings left empty skip every rule but required. Optional pointers suit
+// partial updates: nil skips every rule, but a string that was sent must not be
+// blank. For a slice of strings, min and max apply to its length and the other
+// rules to each element.
+package validation
+
+import (
+	"fmt"
+	"gocommerce/apperrors"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validator is implemented by request types with rules tags can't express, such as
+// fields that depend on each other. Validate runs after the tag rules.
+type Validator interface {
+	Validate() []apperrors.FieldError
+}
+
+// UsernamePattern is what the username rule accepts
+var UsernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Struct checks v, a struct or pointer to one, and returns an error for each field
+// that breaks one of its rules.
+func Struct(v any) []apperrors.FieldError {
+	var errs []apperrors.FieldError
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" || !field.IsExported() {
+			continue
+		}
+		errs = append(errs, checkField(jsonName(field), rv.Field(i), strings.Split(tag, ","))...)
+	}
+	if validator, ok := v.(Validator); ok {
+		errs = append(errs, validator.Validate()...)
+	}
+	return errs
+}
+
+// jsonName is the name clients know the field by
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+func checkField(name string, value reflect.Value, rules []string) []apperrors.FieldError {
+	required := false
+	for _, rule := range rules {
+		if rule == "required" {
+			required = true
+		}
+	}
+
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			if required {
+				return []apperrors.FieldError{{Field: name, Message: "is required"}}
+			}
+			return nil
+		}
+		// A string that was sent can't be blank, even when the field is optional
+		if value.Elem().Kind() == reflect.String && isBlank(value.Elem()) {
+			return []apperrors.FieldError{{Field: name, Message: "must not be empty"}}
+		}
+		value = value.Elem()
+	}
+
+	if required && isBlank(value) {
+		return []apperrors.FieldError{{Field: name, Message: "is required"}}
+	}
+	if value.Kind() == reflect.String && value.String() == "" {
+		return nil
+	}
+
+	var errs []apperrors.FieldError
+	for _, rule := range rules {
+		rule, arg, _ := strings.Cut(rule, "=")
+		if rule == "required" {
+			continue
+		}
+		if value.Kind() == reflect.Slice && rule != "min" && rule != "max" {
+			for i := 0; i < value.Len(); i++ {
+				if msg := check(value.Index(i), rule, arg); msg != "" {
+					errs = append(errs, apperrors.FieldError{Field: fmt.Sprintf("%s[%d]", name, i), Message: msg})
+				}
+			}
+			continue
+		}
+		if msg := check(value, rule, arg); msg != "" {
+			errs = append(errs, apperrors.FieldError{Field: name, Message: msg})
+		}
+	}
+	return errs
+}
+
+func isBlank(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	default:
+		return value.IsZero()
+	}
+}
+
+// check applies one rule to value and returns why it failed, or "" if it passed
+func check(value reflect.Value, rule, arg string) string {
+	switch rule {
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			panic(fmt.Sprintf("validation: bad argument for %s: %q", rule, arg))
+		}
+		return checkBound(value, rule, limit, arg)
+	case "oneof":
+		options := strings.Fields(arg)
+		for _, option := range options {
+			if value.String() == option {
+				return ""
+			}
+		}
+		return "must be one of: " + strings.Join(options, ", ")
+	case "email":
+		if !IsEmail(value.String()) {
+			return "must be a valid email address"
+		}
+	case "url":
+		if !IsHTTPURL(value.String()) {
+			return "must be an absolute http or https URL"
+		}
+	case "username":
+		if !UsernamePattern.MatchString(value.String()) {
+			return "may only contain letters, digits, '.', '_' and '-'"
+		}
+	default:
+		panic("validation: unknown rule " + rule)
+	}
+	return ""
+}
+
+func checkBound(value reflect.Value, rule string, limit float64, arg string) string {
+	var n float64
+	unit := ""
+	switch value.Kind() {
+	case reflect.String:
+		n, unit = float64(utf8.RuneCountInString(value.String())), " characters"
+	case reflect.Slice:
+		n, unit = float64(value.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(value.Int())
+	case reflect.Float32, reflect.Float64:
+		n = value.Float()
+	default:
+		panic(fmt.Sprintf("validation: %s does not apply to %s", rule, value.Kind()))
+	}
+
+	switch {
+	case rule == "min" && n < limit:
+		return "must be at least " + arg + unit
+	case rule == "max" && n > limit:
+		return "must be at most " + arg + unit
+	}
+	return ""
+}
+
+// IsEmail reports whether s is a bare email address with a dotted domain. Any case
+// and top-level domain is accepted; callers lower-case addresses before storing them.
+func IsEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Address != s || addr.Name != "" {
+		return false
+	}
+	_, domain, _ := strings.Cut(s, "@")
+	return strings.Contains(domain, ".") && !strings.HasPrefix(domain, ".") && !strings.HasSuffix(domain, ".")
+}
+
+// IsHTTPURL reports whether s is an absolute http or https URL.
+func IsHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
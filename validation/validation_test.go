@@ -0,0 +1,156 @@
+package validation
+
+import (
+	"errors"
+	"gocommerce/apperrors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type signup struct {
+	Username string   `json:"username" validate:"required,min=3,max=10,username"`
+	Email    string   `json:"email" validate:"required,email"`
+	Website  string   `json:"website" validate:"url"`
+	Plan     string   `json:"plan" validate:"oneof=free pro"`
+	Age      int      `json:"age" validate:"min=13"`
+	Tags     []string `json:"tags" validate:"max=2,oneof=a b c"`
+	Nickname *string  `json:"nickname" validate:"max=5"`
+	Internal string   // Not validated
+}
+
+// Validate requires a website for the pro plan
+func (s signup) Validate() []apperrors.FieldError {
+	if s.Plan == "pro" && s.Website == "" {
+		return []apperrors.FieldError{{Field: "website", Message: "is required for the pro plan"}}
+	}
+	return nil
+}
+
+func valid() signup {
+	return signup{Username: "ada", Email: "ada@example.com", Age: 36}
+}
+
+func ptr(s string) *string { return &s }
+
+func TestStruct(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*signup)
+		want   []apperrors.FieldError
+	}{
+		{"valid", func(s *signup) {}, nil},
+		{"optional fields sent", func(s *signup) {
+			s.Website, s.Plan, s.Tags, s.Nickname = "https://ada.dev", "pro", []string{"a", "c"}, ptr("ada")
+		}, nil},
+		{"required blank", func(s *signup) { s.Username = "   " }, []apperrors.FieldError{{Field: "username", Message: "is required"}}},
+		{"too short", func(s *signup) { s.Username = "ad" }, []apperrors.FieldError{{Field: "username", Message: "must be at least 3 characters"}}},
+		{"length counts characters, not bytes", func(s *signup) { s.Nickname = ptr("ééééé") }, nil},
+		{"every failing rule is reported", func(s *signup) { s.Username = "a b c d e f g" }, []apperrors.FieldError{
+			{Field: "username", Message: "must be at most 10 characters"},
+			{Field: "username", Message: "may only contain letters, digits, '.', '_' and '-'"},
+		}},
+		{"email", func(s *signup) { s.Email = "Ada <ada@example.com>" }, []apperrors.FieldError{{Field: "email", Message: "must be a valid email address"}}},
+		{"url", func(s *signup) { s.Website = "ftp://ada.dev" }, []apperrors.FieldError{{Field: "website", Message: "must be an absolute http or https URL"}}},
+		{"oneof", func(s *signup) { s.Plan = "gold" }, []apperrors.FieldError{{Field: "plan", Message: "must be one of: free, pro"}}},
+		{"number minimum", func(s *signup) { s.Age = 12 }, []apperrors.FieldError{{Field: "age", Message: "must be at least 13"}}},
+		{"slice length and elements", func(s *signup) { s.Tags = []string{"a", "d", "b"} }, []apperrors.FieldError{
+			{Field: "tags", Message: "must be at most 2 items"},
+			{Field: "tags[1]", Message: "must be one of: a, b, c"},
+		}},
+		{"pointer sent blank", func(s *signup) { s.Nickname = ptr(" ") }, []apperrors.FieldError{{Field: "nickname", Message: "must not be empty"}}},
+		{"pointer too long", func(s *signup) { s.Nickname = ptr("adalovelace") }, []apperrors.FieldError{{Field: "nickname", Message: "must be at most 5 characters"}}},
+		{"Validator runs after the tags", func(s *signup) { s.Plan = "pro"; s.Age = 1 }, []apperrors.FieldError{
+			{Field: "age", Message: "must be at least 13"},
+			{Field: "website", Message: "is required for the pro plan"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := valid()
+			tt.change(&s)
+			if got := Struct(s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Struct() = %v, want %v", got, tt.want)
+			}
+			// A pointer to the struct is checked the same way
+			if got := Struct(&s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Struct(&s) = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsEmail(t *testing.T) {
+	tests := map[string]bool{
+		"ada@example.com":                  true,
+		"Ada.Lovelace@Mail.IO":             true,
+		"ada@localhost":                    false,
+		"ada@.example.com":                 false,
+		"ada@example.com.":                 false,
+		"Ada <ada@example.com>":            false,
+		"ada@example.com, bob@example.com": false,
+		"":                                 false,
+	}
+	for email, want := range tests {
+		if got := IsEmail(email); got != want {
+			t.Errorf("IsEmail(%q) = %v, want %v", email, got, want)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCode   string
+		wantFields []apperrors.FieldError
+	}{
+		{"valid", `{"username":"ada","email":"ada@example.com","age":36}`, 0, "", nil},
+		{"empty", ``, http.StatusBadRequest, apperrors.CodeInvalidJSON, nil},
+		{"malformed", `{"username":`, http.StatusBadRequest, apperrors.CodeInvalidJSON, nil},
+		{"trailing data", `{"username":"ada","email":"ada@example.com","age":36} {}`, http.StatusBadRequest, apperrors.CodeInvalidJSON, nil},
+		{"unknown field", `{"username":"ada","admin":true}`, http.StatusBadRequest, apperrors.CodeValidation,
+			[]apperrors.FieldError{{Field: "admin", Message: "is not a known field"}}},
+		{"wrong type", `{"age":"old"}`, http.StatusBadRequest, apperrors.CodeValidation,
+			[]apperrors.FieldError{{Field: "age", Message: "must be a number"}}},
+		{"invalid fields", `{"username":"ada","email":"nope","age":36}`, http.StatusBadRequest, apperrors.CodeValidation,
+			[]apperrors.FieldError{{Field: "email", Message: "must be a valid email address"}}},
+		{"too large", `{"username":"` + strings.Repeat("a", int(MaxBodyBytes)) + `"}`, http.StatusRequestEntityTooLarge, apperrors.CodeRequestTooLarge, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s signup
+			err := Decode(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)), &s)
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("Decode() = %v", err)
+				}
+				if s.Username != "ada" || s.Age != 36 {
+					t.Errorf("Decoded %+v", s)
+				}
+				return
+			}
+
+			var appErr *apperrors.Error
+			if !errors.As(err, &appErr) {
+				t.Fatalf("Decode() = %v, want an *apperrors.Error", err)
+			}
+			if appErr.Status != tt.wantStatus || appErr.Code != tt.wantCode || !reflect.DeepEqual(appErr.Fields, tt.wantFields) {
+				t.Errorf("Decode() = %d %s %v, want %d %s %v", appErr.Status, appErr.Code, appErr.Fields, tt.wantStatus, tt.wantCode, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestDecodeOptional(t *testing.T) {
+	var s signup
+	if err := DecodeOptional(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil), &s); err != nil {
+		t.Errorf("DecodeOptional() without a body = %v", err)
+	}
+	if err := DecodeOptional(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"age":1}`)), &s); err == nil {
+		t.Error("DecodeOptional() accepted an invalid body")
+	}
+}
@@ -0,0 +1,93 @@
+// webhooks/target.go
+
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"gocommerce/config"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// AllowPrivateTargets lets subscriptions use plain http and reach loopback and
+// private networks, for developing against a local receiver. Anyone who can
+// manage webhooks, API keys included, could otherwise use deliveries to reach
+// internal services and cloud metadata endpoints, so it is off by default.
+var AllowPrivateTargets = config.Bool("WEBHOOK_ALLOW_PRIVATE_TARGETS", false)
+
+// ErrPrivateTarget is returned when a delivery would connect to an address that
+// isn't publicly routable
+var ErrPrivateTarget = errors.New("webhook target is not a public address")
+
+// sharedAddressSpace is the carrier-grade NAT range, which netip doesn't count as private
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddr reports whether addr may receive deliveries.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// ValidateURL checks a subscription URL before it is stored. It requires https
+// and rejects hosts that are obviously internal; addresses a name resolves to
+// are checked again when each delivery connects.
+func ValidateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if AllowPrivateTargets {
+		return nil
+	}
+	if u.Scheme != "https" {
+		return errors.New("must use https")
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrPrivateTarget
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !publicAddr(addr) {
+		return ErrPrivateTarget
+	}
+	return nil
+}
+
+// checkDial refuses connections to addresses that aren't public. It runs after
+// DNS resolution, so a name that resolves to an internal address is caught too.
+func checkDial(network, address string, _ syscall.RawConn) error {
+	if AllowPrivateTargets {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !publicAddr(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrPrivateTarget, addrPort.Addr())
+	}
+	return nil
+}
+
+// NewClient returns the HTTP client deliveries are sent with. It only connects
+// to public addresses, ignores proxy settings so that check sees the real
+// target, and doesn't follow redirects: a redirect is reported as the
+// receiver's response instead.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second, Control: checkDial}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,100 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+)
+
+func TestValidateURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://hooks.example.com/gocommerce", true},
+		{"https://93.184.216.34/hook", true},
+		{"http://hooks.example.com/gocommerce", false},
+		{"ftp://hooks.example.com", false},
+		{"https://localhost/hook", false},
+		{"https://api.LOCALHOST:8443/hook", false},
+		{"https://127.0.0.1/hook", false},
+		{"https://10.0.0.5/hook", false},
+		{"https://192.168.1.1/hook", false},
+		{"https://169.254.169.254/latest/meta-data/", false},
+		{"https://100.64.0.1/hook", false},
+		{"https://0.0.0.0/hook", false},
+		{"https://[::1]/hook", false},
+		{"https://[fd00::1]/hook", false},
+		{"https://[::ffff:127.0.0.1]/hook", false},
+	}
+	for _, tt := range tests {
+		if err := ValidateURL(tt.url); (err == nil) != tt.want {
+			t.Errorf("ValidateURL(%q) = %v, want allowed %v", tt.url, err, tt.want)
+		}
+	}
+
+	allowLocalReceivers(t)
+	if err := ValidateURL("http://localhost:9000/hook"); err != nil {
+		t.Errorf("ValidateURL() with private targets allowed = %v", err)
+	}
+}
+
+func TestPublicAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":        true,
+		"2606:2800:220:1::":    true,
+		"127.0.0.1":            false,
+		"172.16.0.1":           false,
+		"169.254.169.254":      false,
+		"fe80::1":              false,
+		"224.0.0.1":            false,
+		"::ffff:10.0.0.1":      false,
+		"::":                   false,
+		"100.127.255.254":      false,
+		"fc00::abcd:1234:5678": false,
+	} {
+		if got := publicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("publicAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+// TestSendRefusesPrivateTargets checks the dial time check, which also catches
+// names that resolve to internal addresses
+func TestSendRefusesPrivateTargets(t *testing.T) {
+	rc := &receiver{status: http.StatusOK}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	for _, target := range []string{server.URL, "http://localhost:" + u.Port()} {
+		_, err := NewWorker(nil).Send(context.Background(), 1, "order.placed", target, testSecret, []byte(`{}`))
+		if !errors.Is(err, ErrPrivateTarget) {
+			t.Errorf("Send(%s) = %v, want ErrPrivateTarget", target, err)
+		}
+	}
+	if rc.received() != 0 {
+		t.Errorf("The internal receiver got %d deliveries", rc.received())
+	}
+}
+
+func TestSendDoesNotFollowRedirects(t *testing.T) {
+	allowLocalReceivers(t)
+	internal := &receiver{status: http.StatusOK}
+	internalServer := httptest.NewServer(internal)
+	defer internalServer.Close()
+	redirector := httptest.NewServer(http.RedirectHandler(internalServer.URL, http.StatusTemporaryRedirect))
+	defer redirector.Close()
+
+	code, err := NewWorker(nil).Send(context.Background(), 1, "order.placed", redirector.URL, testSecret, []byte(`{}`))
+	if code != http.StatusTemporaryRedirect || err == nil {
+		t.Errorf("Send() = %d, %v, want the redirect reported as a failure", code, err)
+	}
+	if internal.received() != 0 {
+		t.Error("Send() followed the redirect")
+	}
+}
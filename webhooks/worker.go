@@ -0,0 +1,274 @@
+// webhooks/worker.go
+
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gocommerce/events"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Delivery statuses stored in webhook_deliveries.status
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusDead      = "dead"
+)
+
+// ErrDeliveryNotFound is returned by Redeliver for an unknown delivery ID
+var ErrDeliveryNotFound = errors.New("webhook delivery not found")
+
+// Worker turns domain events into webhook deliveries and sends them.
+type Worker struct {
+	DB           *sql.DB
+	Client       *http.Client
+	PollInterval time.Duration
+	BatchSize    int
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	// Lease is how long a claimed batch is hidden from other workers while it is being
+	// sent. It is never shorter than sending every delivery in the batch can take.
+	Lease time.Duration
+	// Heartbeat, if set, is called before each batch so health checks can tell the worker is alive
+	Heartbeat func()
+}
+
+func NewWorker(db *sql.DB) *Worker {
+	return &Worker{
+		DB:           db,
+		Client:       NewClient(10 * time.Second),
+		PollInterval: 2 * time.Second,
+		BatchSize:    20,
+		MaxAttempts:  8,
+		BaseBackoff:  30 * time.Second,
+		MaxBackoff:   6 * time.Hour,
+		Lease:        5 * time.Minute,
+	}
+}
+
+// envelope is the JSON body posted to subscribers
+type envelope struct {
+	ID          int64           `json:"id"`
+	Type        string          `json:"type"`
+	AggregateID int             `json:"aggregate_id"`
+	OccurredAt  time.Time       `json:"occurred_at"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// HandleEvent is an events.Handler that queues a delivery for every active
+// subscription interested in the event. Redelivered events are ignored thanks
+// to the (subscription_id, event_id) unique key.
+func (wk *Worker) HandleEvent(ctx context.Context, e events.Event) error {
+	body, err := json.Marshal(envelope{
+		ID:          e.ID,
+		Type:        e.Type,
+		AggregateID: e.AggregateID,
+		OccurredAt:  e.OccurredAt,
+		Data:        e.Payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = wk.DB.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload)
+		SELECT id, $1, $2, $3 FROM webhook_subscriptions
+		WHERE active AND ($2 = ANY(event_types) OR '*' = ANY(event_types))
+		ON CONFLICT (subscription_id, event_id) DO NOTHING`,
+		e.ID, e.Type, body)
+	if err != nil {
+		return fmt.Errorf("error queueing webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+// Run sends due deliveries until ctx is cancelled.
+func (wk *Worker) Run(ctx context.Context) {
+	slog.Info("Webhook worker started")
+	ticker := time.NewTicker(wk.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			if wk.Heartbeat != nil {
+				wk.Heartbeat()
+			}
+			n, err := wk.sendBatch(ctx)
+			if err != nil {
+				slog.Error("Webhook worker: batch failed", "error", err)
+				break
+			}
+			if n < wk.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Info("Webhook worker stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type pendingDelivery struct {
+	id        int64
+	eventType string
+	payload   []byte
+	attempts  int
+	url       string
+	secret    string
+}
+
+// sendBatch claims up to BatchSize due deliveries and sends them.
+func (wk *Worker) sendBatch(ctx context.Context) (int, error) {
+	// Claim rows by pushing next_attempt_at past the lease so the HTTP calls
+	// below don't have to run inside a transaction
+	rows, err := wk.DB.QueryContext(ctx, `
+		UPDATE webhook_deliveries d SET next_attempt_at = $2
+		FROM webhook_subscriptions s
+		WHERE d.subscription_id = s.id AND d.id IN (
+			SELECT dd.id FROM webhook_deliveries dd
+			JOIN webhook_subscriptions ss ON ss.id = dd.subscription_id
+			WHERE dd.status = 'pending' AND dd.next_attempt_at <= NOW() AND ss.active
+			ORDER BY dd.id
+			LIMIT $1
+			FOR UPDATE OF dd SKIP LOCKED)
+		RETURNING d.id, d.event_type, d.payload, d.attempts, s.url, s.secret`,
+		wk.BatchSize, time.Now().Add(wk.lease()))
+	if err != nil {
+		return 0, err
+	}
+
+	var batch []pendingDelivery
+	for rows.Next() {
+		var d pendingDelivery
+		if err := rows.Scan(&d.id, &d.eventType, &d.payload, &d.attempts, &d.url, &d.secret); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, d := range batch {
+		if err := wk.deliver(ctx, d); err != nil {
+			return 0, err
+		}
+	}
+	return len(batch), nil
+}
+
+// lease returns how long to claim a batch for: Lease, or the time it takes every
+// delivery in a full batch to time out if that is longer.
+func (wk *Worker) lease() time.Duration {
+	lease := wk.Lease
+	if wk.Client.Timeout > 0 {
+		if worst := time.Duration(wk.BatchSize)*wk.Client.Timeout + time.Minute; worst > lease {
+			lease = worst
+		}
+	}
+	return lease
+}
+
+// deliver makes one attempt at sending d and records the outcome.
+func (wk *Worker) deliver(ctx context.Context, d pendingDelivery) error {
+	attempt := d.attempts + 1
+	start := time.Now()
+	code, sendErr := wk.Send(ctx, d.id, d.eventType, d.url, d.secret, d.payload)
+	duration := time.Since(start)
+
+	var codeValue, errValue interface{}
+	if code != 0 {
+		codeValue = code
+	}
+	if sendErr != nil {
+		errValue = sendErr.Error()
+	}
+
+	if _, err := wk.DB.ExecContext(ctx, `
+		INSERT INTO webhook_delivery_attempts (delivery_id, attempt, response_code, error, duration_ms)
+		VALUES ($1, $2, $3, $4, $5)`, d.id, attempt, codeValue, errValue, duration.Milliseconds()); err != nil {
+		return err
+	}
+
+	if sendErr == nil {
+		_, err := wk.DB.ExecContext(ctx, `
+			UPDATE webhook_deliveries SET status = 'succeeded', attempts = $1, last_response_code = $2,
+			last_error = NULL, delivered_at = NOW() WHERE id = $3`, attempt, codeValue, d.id)
+		return err
+	}
+
+	if attempt >= wk.MaxAttempts {
+		slog.Error("Webhook worker: delivery is dead", "delivery_id", d.id, "attempts", attempt, "error", sendErr)
+		_, err := wk.DB.ExecContext(ctx, `
+			UPDATE webhook_deliveries SET status = 'dead', attempts = $1, last_response_code = $2,
+			last_error = $3 WHERE id = $4`, attempt, codeValue, errValue, d.id)
+		return err
+	}
+
+	delay := events.Backoff(wk.BaseBackoff, wk.MaxBackoff, attempt)
+	_, err := wk.DB.ExecContext(ctx, `
+		UPDATE webhook_deliveries SET attempts = $1, last_response_code = $2, last_error = $3,
+		next_attempt_at = $4 WHERE id = $5`, attempt, codeValue, errValue, time.Now().Add(delay), d.id)
+	return err
+}
+
+// Send posts a signed payload to url and returns the response status code.
+// Any non-2xx response is reported as an error.
+func (wk *Worker) Send(ctx context.Context, deliveryID int64, eventType, url, secret string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gocommerce-webhooks/1.0")
+	req.Header.Set(HeaderDeliveryID, strconv.FormatInt(deliveryID, 10))
+	req.Header.Set(HeaderEvent, eventType)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(secret, timestamp, body))
+
+	resp, err := wk.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Redeliver puts a delivery back in the queue with a fresh set of retries,
+// whatever its current status.
+func Redeliver(db *sql.DB, deliveryID int64) error {
+	result, err := db.Exec(`
+		UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = NOW(), delivered_at = NULL,
+		last_error = NULL
+		WHERE id = $1`, deliveryID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrDeliveryNotFound
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"gocommerce/dbtest"
+	"gocommerce/events"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSecret = "whsec_test"
+
+// receiver records what it is sent and answers with status
+type receiver struct {
+	mu         sync.Mutex
+	status     int
+	deliveries []*http.Request
+	bodies     [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.deliveries = append(rc.deliveries, r)
+	rc.bodies = append(rc.bodies, body)
+	w.WriteHeader(rc.status)
+}
+
+func (rc *receiver) received() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.deliveries)
+}
+
+// allowLocalReceivers lets the test deliver to httptest servers on loopback
+func allowLocalReceivers(t *testing.T) {
+	allow := AllowPrivateTargets
+	AllowPrivateTargets = true
+	t.Cleanup(func() { AllowPrivateTargets = allow })
+}
+
+func verified(t *testing.T, r *http.Request, body []byte) bool {
+	t.Helper()
+	timestamp, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		t.Fatalf("Bad %s header %q", HeaderTimestamp, r.Header.Get(HeaderTimestamp))
+	}
+	return Verify(testSecret, timestamp, body, r.Header.Get(HeaderSignature))
+}
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	signature := Sign(testSecret, 1700000000, body)
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp int64
+		body      []byte
+		want      bool
+	}{
+		{"matching", testSecret, 1700000000, body, true},
+		{"wrong secret", "other", 1700000000, body, false},
+		{"replayed with a new timestamp", testSecret, 1700000060, body, false},
+		{"tampered body", testSecret, 1700000000, []byte(`{"id":2}`), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Verify(tt.secret, tt.timestamp, tt.body, signature); got != tt.want {
+				t.Errorf("Verify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSend(t *testing.T) {
+	allowLocalReceivers(t)
+	for _, status := range []int{http.StatusNoContent, http.StatusInternalServerError} {
+		rc := &receiver{status: status}
+		server := httptest.NewServer(rc)
+		defer server.Close()
+
+		wk := NewWorker(nil)
+		body := []byte(`{"id":7,"type":"order.placed"}`)
+		code, err := wk.Send(context.Background(), 42, events.OrderPlaced, server.URL, testSecret, body)
+		if code != status {
+			t.Errorf("Send() code = %d, want %d", code, status)
+		}
+		if ok := status < 300; ok != (err == nil) {
+			t.Errorf("Send() with a %d response returned error %v", status, err)
+		}
+
+		r := rc.deliveries[0]
+		if r.Header.Get(HeaderDeliveryID) != "42" || r.Header.Get(HeaderEvent) != events.OrderPlaced {
+			t.Errorf("Unexpected delivery headers %v", r.Header)
+		}
+		if !verified(t, r, rc.bodies[0]) {
+			t.Errorf("Signature %q doesn't verify", r.Header.Get(HeaderSignature))
+		}
+	}
+}
+
+// TestDelivery follows an event from the outbox through the dispatcher and the
+// worker to a receiver, then through retries to the dead letter state.
+func TestDelivery(t *testing.T) {
+	db := dbtest.Open(t)
+	allowLocalReceivers(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rc := &receiver{status: http.StatusOK}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	var subscriptionID int
+	if err := db.QueryRow(`INSERT INTO webhook_subscriptions (url, secret, event_types) VALUES ($1, $2, $3) RETURNING id`,
+		server.URL, testSecret, "{"+events.OrderPlaced+"}").Scan(&subscriptionID); err != nil {
+		t.Fatal(err)
+	}
+	recordEvent := func(orderID int) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := events.Record(tx, events.OrderPlaced, orderID, events.OrderPlacedPayload{OrderID: orderID}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wk := NewWorker(db)
+	wk.MaxAttempts = 2
+	bus := events.NewBus()
+	bus.SubscribeAll(wk.HandleEvent)
+	dispatcher := events.NewDispatcher(db, bus)
+	dispatcher.PollInterval = 50 * time.Millisecond
+	go dispatcher.Run(ctx)
+
+	// The delivery is queued while the dispatcher has the event claimed, which
+	// must not wait on the dispatcher's own locks
+	dispatched := func(eventCount int) {
+		t.Helper()
+		for {
+			var pending int
+			if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM outbox_events WHERE dispatched_at IS NULL`).Scan(&pending); err != nil {
+				t.Fatalf("Event was not dispatched: %v", err)
+			}
+			var queued int
+			db.QueryRowContext(ctx, `SELECT COUNT(*) FROM webhook_deliveries`).Scan(&queued)
+			if pending == 0 && queued == eventCount {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	recordEvent(1)
+	dispatched(1)
+	if n, err := wk.sendBatch(ctx); err != nil || n != 1 {
+		t.Fatalf("sendBatch() = %d, %v, want 1 delivery", n, err)
+	}
+	if rc.received() != 1 {
+		t.Fatalf("Receiver got %d deliveries, want 1", rc.received())
+	}
+	if !verified(t, rc.deliveries[0], rc.bodies[0]) {
+		t.Error("Delivered signature doesn't verify")
+	}
+	var sent envelope
+	if err := json.Unmarshal(rc.bodies[0], &sent); err != nil || sent.Type != events.OrderPlaced || sent.AggregateID != 1 {
+		t.Errorf("Unexpected delivery body %s", rc.bodies[0])
+	}
+	assertDelivery(t, db, 1, StatusSucceeded, 1)
+
+	// A failing receiver is retried with backoff, then given up on
+	rc.status = http.StatusServiceUnavailable
+	recordEvent(2)
+	dispatched(2)
+	if _, err := wk.sendBatch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertDelivery(t, db, 2, StatusPending, 1)
+	if n, _ := wk.sendBatch(ctx); n != 0 {
+		t.Fatal("Delivery was retried before its backoff ran out")
+	}
+	db.Exec(`UPDATE webhook_deliveries SET next_attempt_at = NOW() WHERE event_id = (SELECT MAX(id) FROM outbox_events)`)
+	if _, err := wk.sendBatch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	id := assertDelivery(t, db, 2, StatusDead, 2)
+
+	var attempts int
+	db.QueryRow(`SELECT COUNT(*) FROM webhook_delivery_attempts WHERE delivery_id = $1`, id).Scan(&attempts)
+	if attempts != 2 {
+		t.Errorf("Logged %d attempts, want 2", attempts)
+	}
+
+	// Redelivery starts over with a clean slate
+	if err := Redeliver(db, id); err != nil {
+		t.Fatal(err)
+	}
+	var lastError sql.NullString
+	db.QueryRow(`SELECT last_error FROM webhook_deliveries WHERE id = $1`, id).Scan(&lastError)
+	if lastError.Valid {
+		t.Errorf("Redeliver left last_error %q", lastError.String)
+	}
+	assertDelivery(t, db, 2, StatusPending, 0)
+	if err := Redeliver(db, id+1000); err != ErrDeliveryNotFound {
+		t.Errorf("Redeliver() of an unknown delivery = %v, want ErrDeliveryNotFound", err)
+	}
+}
+
+// assertDelivery checks the delivery of the order event for orderID and returns its ID
+func assertDelivery(t *testing.T, db *sql.DB, orderID int, status string, attempts int) int64 {
+	t.Helper()
+	var id int64
+	var gotStatus string
+	var gotAttempts int
+	err := db.QueryRow(`
+		SELECT d.id, d.status, d.attempts FROM webhook_deliveries d
+		JOIN outbox_events e ON e.id = d.event_id
+		WHERE e.aggregate_id = $1`, orderID).Scan(&id, &gotStatus, &gotAttempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotStatus != status || gotAttempts != attempts {
+		t.Errorf("Delivery for order %d is %s after %d attempts, want %s after %d", orderID, gotStatus, gotAttempts, status, attempts)
+	}
+	return id
+}